@@ -0,0 +1,225 @@
+package analytics
+
+import (
+	"sort"
+
+	"github.com/n4wei/highspot/models"
+)
+
+// Analytics are computed over a models.Mixtape alone, without the lookup
+// hash maps of a mixtape.Mixtape, so they work on any mixtape read from a
+// file. Songs are counted once per playlist they are in, even in playlists
+// that allow duplicates, and song ids that are not in the mixtape are
+// ignored.
+
+// Stats are the aggregate metrics of a mixtape. Lists are ordered by count,
+// highest first, then by id, and cut to the top n that Compute is given.
+type Stats struct {
+	Users     int `json:"users"`
+	Playlists int `json:"playlists"`
+	Songs     int `json:"songs"`
+
+	PlaylistsPerUser Distribution `json:"playlists_per_user"`
+	SongsPerPlaylist Distribution `json:"songs_per_playlist"`
+
+	TopSongs     []Count     `json:"top_songs"`
+	TopArtists   []Count     `json:"top_artists"`
+	TopSongPairs []PairCount `json:"top_song_pairs"`
+
+	// Songs that are in no playlist, and users that own none, in mixtape
+	// order
+	OrphanSongs           []string `json:"orphan_songs"`
+	UsersWithoutPlaylists []string `json:"users_without_playlists"`
+}
+
+// Count is the number of playlists something is in, eg. a song or an
+// artist's songs.
+type Count struct {
+	ID        string `json:"id"`
+	Playlists int    `json:"playlists"`
+}
+
+// Pair is two song ids, ordered so that A < B.
+type Pair struct {
+	A string `json:"a"`
+	B string `json:"b"`
+}
+
+func NewPair(a, b string) Pair {
+	if b < a {
+		a, b = b, a
+	}
+	return Pair{A: a, B: b}
+}
+
+// PairCount is the number of playlists both songs of a pair are in.
+type PairCount struct {
+	Pair
+	Playlists int `json:"playlists"`
+}
+
+// Compute computes every metric, keeping the top n of each list.
+// runtime: O(u + s + p*ps^2 + r log r), see CoOccurrence, r is the number
+// of song pairs in any playlist
+func Compute(mixtape *models.Mixtape, n int) *Stats {
+	songPlaylists := SongPlaylists(mixtape)
+	userPlaylists := PlaylistsPerUser(mixtape)
+
+	stats := &Stats{
+		Users:                 len(mixtape.Users),
+		Playlists:             len(mixtape.Playlists),
+		Songs:                 len(mixtape.Songs),
+		TopSongs:              top(songPlaylists, n),
+		TopArtists:            top(ArtistPlaylists(mixtape), n),
+		TopSongPairs:          topPairs(CoOccurrence(mixtape), n),
+		OrphanSongs:           []string{},
+		UsersWithoutPlaylists: []string{},
+	}
+
+	playlistsPerUser := []int{}
+	for _, user := range mixtape.Users {
+		playlistsPerUser = append(playlistsPerUser, userPlaylists[user.ID])
+		if userPlaylists[user.ID] == 0 {
+			stats.UsersWithoutPlaylists = append(stats.UsersWithoutPlaylists, user.ID)
+		}
+	}
+	stats.PlaylistsPerUser = NewDistribution(playlistsPerUser)
+
+	songsPerPlaylist := []int{}
+	for _, playlist := range mixtape.Playlists {
+		songsPerPlaylist = append(songsPerPlaylist, len(playlist.SongIDs))
+	}
+	stats.SongsPerPlaylist = NewDistribution(songsPerPlaylist)
+
+	for _, song := range mixtape.Songs {
+		if songPlaylists[song.ID] == 0 {
+			stats.OrphanSongs = append(stats.OrphanSongs, song.ID)
+		}
+	}
+	return stats
+}
+
+// PlaylistsPerUser maps user ids to the number of playlists they own.
+// runtime: O(p)
+func PlaylistsPerUser(mixtape *models.Mixtape) map[string]int {
+	counts := map[string]int{}
+	for _, playlist := range mixtape.Playlists {
+		counts[playlist.UserID]++
+	}
+	return counts
+}
+
+// SongPlaylists maps song ids to the number of playlists they are in.
+// runtime: O(s + p*ps)
+func SongPlaylists(mixtape *models.Mixtape) map[string]int {
+	songs := songSet(mixtape)
+	counts := map[string]int{}
+	for _, playlist := range mixtape.Playlists {
+		for songID := range distinctSongs(songs, playlist) {
+			counts[songID]++
+		}
+	}
+	return counts
+}
+
+// ArtistPlaylists maps artists to the number of playlists with at least one
+// of their songs.
+// runtime: O(s + p*ps)
+func ArtistPlaylists(mixtape *models.Mixtape) map[string]int {
+	artists := map[string]string{}
+	for _, song := range mixtape.Songs {
+		artists[song.ID] = song.Artist
+	}
+
+	counts := map[string]int{}
+	for _, playlist := range mixtape.Playlists {
+		seen := map[string]bool{}
+		for _, songID := range playlist.SongIDs {
+			artist, exist := artists[songID]
+			if exist && !seen[artist] {
+				seen[artist] = true
+				counts[artist]++
+			}
+		}
+	}
+	return counts
+}
+
+// CoOccurrence maps pairs of songs to the number of playlists they are
+// both in. Every pair of songs in a playlist is counted, so this is the
+// costly metric for long playlists.
+// runtime: O(s + p*ps^2)
+func CoOccurrence(mixtape *models.Mixtape) map[Pair]int {
+	songs := songSet(mixtape)
+	counts := map[Pair]int{}
+	for _, playlist := range mixtape.Playlists {
+		songIDs := []string{}
+		for songID := range distinctSongs(songs, playlist) {
+			songIDs = append(songIDs, songID)
+		}
+		for i := range songIDs {
+			for j := i + 1; j < len(songIDs); j++ {
+				counts[NewPair(songIDs[i], songIDs[j])]++
+			}
+		}
+	}
+	return counts
+}
+
+func songSet(mixtape *models.Mixtape) map[string]bool {
+	songs := map[string]bool{}
+	for _, song := range mixtape.Songs {
+		songs[song.ID] = true
+	}
+	return songs
+}
+
+// distinctSongs returns the set of songs in a playlist that are in the
+// mixtape's set of songs.
+func distinctSongs(songs map[string]bool, playlist models.Playlist) map[string]bool {
+	set := map[string]bool{}
+	for _, songID := range playlist.SongIDs {
+		if songs[songID] {
+			set[songID] = true
+		}
+	}
+	return set
+}
+
+// top returns the n highest counts, ties broken by id.
+func top(counts map[string]int, n int) []Count {
+	result := make([]Count, 0, len(counts))
+	for id, count := range counts {
+		result = append(result, Count{ID: id, Playlists: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Playlists != result[j].Playlists {
+			return result[i].Playlists > result[j].Playlists
+		}
+		return result[i].ID < result[j].ID
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+func topPairs(counts map[Pair]int, n int) []PairCount {
+	result := make([]PairCount, 0, len(counts))
+	for pair, count := range counts {
+		result = append(result, PairCount{Pair: pair, Playlists: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Playlists != result[j].Playlists {
+			return result[i].Playlists > result[j].Playlists
+		}
+		if result[i].A != result[j].A {
+			return result[i].A < result[j].A
+		}
+		return result[i].B < result[j].B
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
@@ -0,0 +1,92 @@
+package analytics_test
+
+import (
+	"github.com/n4wei/highspot/analytics"
+	"github.com/n4wei/highspot/models"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Analytics", func() {
+	var mixtape *models.Mixtape
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{
+				{ID: "user_1", Name: "test_user_1"},
+				{ID: "user_2", Name: "test_user_2"},
+				{ID: "user_3", Name: "test_user_3"},
+			},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_1", "song_2", "song_3"}},
+				{ID: "playlist_2", UserID: "user_1", SongIDs: []string{"song_2", "song_1", "song_2"}, AllowDuplicates: true},
+				{ID: "playlist_3", UserID: "user_2", SongIDs: []string{"song_3", "song_x"}},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1"},
+				{ID: "song_2", Artist: "some_artist", Title: "test_song_2"},
+				{ID: "song_3", Artist: "another_artist", Title: "test_song_3"},
+				{ID: "song_4", Artist: "another_artist", Title: "test_song_4"},
+			},
+		}
+	})
+
+	Describe("Compute", func() {
+		It("should compute every metric, counting a song once per playlist and ignoring songs not in mixtape", func() {
+			stats := analytics.Compute(mixtape, 2)
+
+			Expect(stats.Users).To(Equal(3))
+			Expect(stats.Playlists).To(Equal(3))
+			Expect(stats.Songs).To(Equal(4))
+			Expect(stats.PlaylistsPerUser).To(Equal(analytics.Distribution{
+				N: 3, Min: 0, Max: 2, Mean: 1, P50: 1, P90: 2, P99: 2,
+				Histogram: []analytics.Bucket{{Value: 0, N: 1}, {Value: 1, N: 1}, {Value: 2, N: 1}},
+			}))
+			Expect(stats.SongsPerPlaylist.Histogram).To(Equal([]analytics.Bucket{{Value: 2, N: 1}, {Value: 3, N: 2}}))
+			Expect(stats.TopSongs).To(Equal([]analytics.Count{
+				{ID: "song_1", Playlists: 2},
+				{ID: "song_2", Playlists: 2},
+			}))
+			Expect(stats.TopArtists).To(Equal([]analytics.Count{
+				{ID: "another_artist", Playlists: 2},
+				{ID: "some_artist", Playlists: 2},
+			}))
+			Expect(stats.TopSongPairs).To(Equal([]analytics.PairCount{
+				{Pair: analytics.NewPair("song_2", "song_1"), Playlists: 2},
+				{Pair: analytics.NewPair("song_1", "song_3"), Playlists: 1},
+			}))
+			Expect(stats.OrphanSongs).To(Equal([]string{"song_4"}))
+			Expect(stats.UsersWithoutPlaylists).To(Equal([]string{"user_3"}))
+		})
+
+		It("should compute zeros for an empty mixtape", func() {
+			stats := analytics.Compute(&models.Mixtape{}, 10)
+			Expect(stats.SongsPerPlaylist).To(Equal(analytics.Distribution{Histogram: []analytics.Bucket{}}))
+			Expect(stats.TopSongs).To(BeEmpty())
+			Expect(stats.OrphanSongs).To(BeEmpty())
+		})
+	})
+
+	Describe("CoOccurrence", func() {
+		It("should count the playlists every pair of songs is in", func() {
+			Expect(analytics.CoOccurrence(mixtape)).To(Equal(map[analytics.Pair]int{
+				{A: "song_1", B: "song_2"}: 2,
+				{A: "song_1", B: "song_3"}: 1,
+				{A: "song_2", B: "song_3"}: 1,
+			}))
+		})
+	})
+
+	table.DescribeTable("Percentile",
+		func(sorted []int, p, expected int) {
+			Expect(analytics.Percentile(sorted, p)).To(Equal(expected))
+		},
+		table.Entry("of nothing", []int{}, 50, 0),
+		table.Entry("of one count", []int{7}, 99, 7),
+		table.Entry("p50 of an even number of counts", []int{1, 2, 3, 4}, 50, 2),
+		table.Entry("p90 of ten counts", []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 90, 9),
+		table.Entry("p99 of ten counts", []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 99, 10),
+		table.Entry("p0", []int{3, 5}, 0, 3),
+	)
+})
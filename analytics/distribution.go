@@ -0,0 +1,66 @@
+package analytics
+
+import "sort"
+
+// Distribution summarizes a list of counts, eg. the number of songs in
+// each playlist. Percentiles use the nearest rank method, so they are
+// always one of the counts. All fields are 0 for an empty list.
+type Distribution struct {
+	N    int     `json:"n"`
+	Min  int     `json:"min"`
+	Max  int     `json:"max"`
+	Mean float64 `json:"mean"`
+	P50  int     `json:"p50"`
+	P90  int     `json:"p90"`
+	P99  int     `json:"p99"`
+	// How many of the counts have each value, ordered by value
+	Histogram []Bucket `json:"histogram"`
+}
+
+type Bucket struct {
+	Value int `json:"value"`
+	N     int `json:"n"`
+}
+
+// runtime: O(n log n)
+func NewDistribution(counts []int) Distribution {
+	d := Distribution{N: len(counts), Histogram: []Bucket{}}
+	if len(counts) == 0 {
+		return d
+	}
+
+	sorted := append([]int{}, counts...)
+	sort.Ints(sorted)
+	sum := 0
+	for _, count := range sorted {
+		sum += count
+		last := len(d.Histogram) - 1
+		if last >= 0 && d.Histogram[last].Value == count {
+			d.Histogram[last].N++
+		} else {
+			d.Histogram = append(d.Histogram, Bucket{Value: count, N: 1})
+		}
+	}
+
+	d.Min = sorted[0]
+	d.Max = sorted[len(sorted)-1]
+	d.Mean = float64(sum) / float64(len(sorted))
+	d.P50 = Percentile(sorted, 50)
+	d.P90 = Percentile(sorted, 90)
+	d.P99 = Percentile(sorted, 99)
+	return d
+}
+
+// Percentile returns the smallest count that at least p percent of the
+// sorted counts are less than or equal to.
+func Percentile(sorted []int, p int) int {
+	if len(sorted) == 0 {
+		return 0
+	}
+	// the nearest rank, ceil(p/100 * n), starting at 1
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -1,6 +1,8 @@
 package collection
 
 import (
+	"time"
+
 	mixtape_pkg "github.com/n4wei/highspot/mixtape"
 	"github.com/n4wei/highspot/models"
 	"github.com/n4wei/highspot/util"
@@ -10,10 +12,40 @@ import (
 // in main from the object implementing the ApplyChanges logic.
 type Collection interface {
 	ApplyChanges(changes *models.Changes) error
+	// Same outcome as ApplyChanges, with independent changes validated
+	// in parallel by the given number of workers
+	ApplyChangesParallel(changes *models.Changes, workers int) error
+	// Changes that took effect, see mixtape.Mixtape.Applied
+	Applied() []models.PlaylistChange
+	// What happened to every change, see mixtape.Mixtape.Report
+	Report() *models.Report
+}
+
+// A Collection that is safe for concurrent use. Besides applying changes,
+// it can be read from while other goroutines apply changes.
+type SafeCollection interface {
+	Collection
+	Playlist(id string) (models.Playlist, bool)
+	// Playlists the user owns or is an editor of
+	PlaylistsEditableBy(userID string) []string
+	// Total duration of the playlist's songs
+	Duration(id string) (time.Duration, bool)
+	Copy() *models.Mixtape
 }
 
 // This function is really simple, but we could use the factory pattern
 // for more complex instantiation needs
-func New(mixtape *models.Mixtape, logger util.Logger) Collection {
-	return mixtape_pkg.New(mixtape, logger)
+func New(mixtape *models.Mixtape, logger util.Logger, opts ...mixtape_pkg.Option) Collection {
+	return mixtape_pkg.New(mixtape, logger, opts...)
+}
+
+// Same as New, but reuses lookup hash maps loaded from a snapshot
+// instead of building them.
+func NewWithIndex(mixtape *models.Mixtape, index *mixtape_pkg.Index, logger util.Logger, opts ...mixtape_pkg.Option) Collection {
+	return mixtape_pkg.NewWithIndex(mixtape, index, logger, opts...)
+}
+
+// Same as New, but safe for concurrent use. The logger must be too.
+func NewSafe(mixtape *models.Mixtape, logger util.Logger, opts ...mixtape_pkg.Option) SafeCollection {
+	return mixtape_pkg.NewSafe(mixtape, logger, opts...)
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/n4wei/highspot/csvio"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/selector"
+)
+
+// highspot import -d <csv dir> -o <mixtape file>
+func runImport(args []string) {
+	var csvDir, outputFile string
+	var compressionLevel int
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.StringVar(&csvDir, "d", "", "directory containing users.csv, songs.csv and playlist_songs.csv")
+	flags.StringVar(&outputFile, "o", "./output.json", "filepath to write the imported mixtape JSON file, - for stdout")
+	addCompressionFlag(flags, &compressionLevel)
+	flags.Parse(args)
+
+	if csvDir == "" {
+		handleFlagError(flags, errors.New("missing required flag -d"))
+	}
+
+	mixtape, err := csvio.Import(csvDir)
+	handleError(err)
+
+	err = writeMixtape(mixtape, nil, outputFile, compressionLevel)
+	handleError(err)
+}
+
+// highspot export -m <mixtape file> -d <csv dir> [-where <selector>]
+// With -where, only the playlists matching it are exported, and every user
+// and song still is.
+func runExport(args []string) {
+	var mixtapeFile, csvDir, where string
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.StringVar(&mixtapeFile, "m", "", "filepath to the JSON mixtape file, - for stdin")
+	flags.StringVar(&csvDir, "d", "", "directory to write users.csv, songs.csv and playlist_songs.csv")
+	flags.StringVar(&where, "where", "", "selector expression the exported playlists must match, eg. 'songs < 2'")
+	flags.Parse(args)
+
+	if mixtapeFile == "" || csvDir == "" {
+		handleFlagError(flags, errors.New("missing required flags -m and -d"))
+	}
+	var s *selector.Selector
+	if where != "" {
+		var err error
+		s, err = selector.Parse(where)
+		if err != nil {
+			handleFlagError(flags, err)
+		}
+	}
+
+	mixtape, _, err := readMixtape(mixtapeFile)
+	handleError(err)
+	if s != nil {
+		lookup := selector.NewLookup(mixtape)
+		playlists := []models.Playlist{}
+		for _, playlist := range mixtape.Playlists {
+			if s.Match(playlist, lookup) {
+				playlists = append(playlists, playlist)
+			}
+		}
+		mixtape.Playlists = playlists
+	}
+
+	err = csvio.Export(mixtape, csvDir)
+	handleError(err)
+}
@@ -0,0 +1,536 @@
+package csvio
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/n4wei/highspot/models"
+)
+
+// A mixtape is spread across three CSV files so that each one can be
+// edited as a flat spreadsheet. Playlists do not get a file of their own,
+// they are reconstructed from the rows of playlist_songs.csv. A playlist
+// without songs, eg. one that remove_songs or set_songs emptied, has a
+// single row with no position and no song.
+//
+// The columns after song_id describe the playlist rather than the song, and
+// are the same on every row of a playlist, like user_id. Collaborators are
+// written as user_id:role pairs separated by ";", eg. "2:editor;3:viewer".
+// Files written before these columns existed leave them out. Versions and
+// timestamps are not kept.
+const (
+	UsersFile         = "users.csv"
+	SongsFile         = "songs.csv"
+	PlaylistSongsFile = "playlist_songs.csv"
+)
+
+var (
+	usersHeader         = []string{"id", "name"}
+	songsHeader         = []string{"id", "artist", "title", "duration_ms", "album", "genre", "year", "isrc"}
+	playlistSongsHeader = []string{"playlist_id", "user_id", "position", "song_id", "allow_duplicates", "name", "description", "visibility", "collaborators"}
+)
+
+// RowError identifies a bad row by file name and line number so it can be
+// found and fixed in the spreadsheet it came from.
+type RowError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("%s line %d: %v", e.File, e.Line, e.Err)
+}
+
+// RowErrors collects every bad row found during an import instead of
+// stopping at the first one, so a spreadsheet can be fixed in one pass.
+type RowErrors []*RowError
+
+func (e RowErrors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Export writes the mixtape as users.csv, songs.csv and playlist_songs.csv
+// in dir. The directory is created if it does not exist. A mixtape that
+// allows duplicates is exported as playlists that each allow them, since
+// the CSV files have no place for the mixtape's own setting.
+func Export(mixtape *models.Mixtape, dir string) error {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	err = writeFile(filepath.Join(dir, UsersFile), func(w io.Writer) error {
+		return WriteUsers(w, mixtape.Users)
+	})
+	if err != nil {
+		return err
+	}
+	err = writeFile(filepath.Join(dir, SongsFile), func(w io.Writer) error {
+		return WriteSongs(w, mixtape.Songs)
+	})
+	if err != nil {
+		return err
+	}
+	playlists := mixtape.Playlists
+	if mixtape.AllowDuplicates {
+		playlists = make([]models.Playlist, len(mixtape.Playlists))
+		for i, playlist := range mixtape.Playlists {
+			playlist.AllowDuplicates = true
+			playlists[i] = playlist
+		}
+	}
+	return writeFile(filepath.Join(dir, PlaylistSongsFile), func(w io.Writer) error {
+		return WritePlaylistSongs(w, playlists)
+	})
+}
+
+// Import reads users.csv, songs.csv and playlist_songs.csv from dir and
+// builds a mixtape out of them. Every row is validated, including that the
+// users and songs referenced by playlist_songs.csv exist. All bad rows are
+// returned together as RowErrors.
+func Import(dir string) (*models.Mixtape, error) {
+	var users []models.User
+	var songs []models.Song
+	var playlists []models.Playlist
+
+	err := readFile(filepath.Join(dir, UsersFile), func(r io.Reader) error {
+		var err error
+		users, err = ReadUsers(r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readFile(filepath.Join(dir, SongsFile), func(r io.Reader) error {
+		var err error
+		songs, err = ReadSongs(r)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = readFile(filepath.Join(dir, PlaylistSongsFile), func(r io.Reader) error {
+		var err error
+		playlists, err = ReadPlaylistSongs(r, users, songs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Mixtape{
+		Users:     users,
+		Playlists: playlists,
+		Songs:     songs,
+	}, nil
+}
+
+func WriteUsers(w io.Writer, users []models.User) error {
+	cw := csv.NewWriter(w)
+	cw.Write(usersHeader)
+	for _, user := range users {
+		cw.Write([]string{user.ID, user.Name})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func WriteSongs(w io.Writer, songs []models.Song) error {
+	cw := csv.NewWriter(w)
+	cw.Write(songsHeader)
+	for _, song := range songs {
+		cw.Write([]string{song.ID, song.Artist, song.Title, optionalInt(song.DurationMs), song.Album, song.Genre, optionalInt(int64(song.Year)), song.ISRC})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Positions are 1-based, the way a spreadsheet user would number them.
+// allow_duplicates is true or left empty.
+func WritePlaylistSongs(w io.Writer, playlists []models.Playlist) error {
+	cw := csv.NewWriter(w)
+	cw.Write(playlistSongsHeader)
+	for _, playlist := range playlists {
+		allowDuplicates := ""
+		if playlist.AllowDuplicates {
+			allowDuplicates = "true"
+		}
+		collaborators := make([]string, len(playlist.Collaborators))
+		for i, collaborator := range playlist.Collaborators {
+			collaborators[i] = collaborator.UserID + ":" + string(collaborator.Role)
+		}
+		row := func(position, songID string) []string {
+			return []string{playlist.ID, playlist.UserID, position, songID, allowDuplicates, playlist.Name, playlist.Description, string(playlist.Visibility), strings.Join(collaborators, ";")}
+		}
+
+		if len(playlist.SongIDs) == 0 {
+			cw.Write(row("", ""))
+		}
+		for i, songID := range playlist.SongIDs {
+			cw.Write(row(strconv.Itoa(i+1), songID))
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func ReadUsers(r io.Reader) ([]models.User, error) {
+	users := []models.User{}
+	seen := map[string]bool{}
+
+	err := readRows(r, UsersFile, usersHeader, 0, func(line int, row []string) error {
+		id := row[0]
+		if id == "" {
+			return errors.New("id missing")
+		}
+		if seen[id] {
+			return fmt.Errorf("duplicate id %s", id)
+		}
+		seen[id] = true
+		users = append(users, models.User{ID: id, Name: row[1]})
+		return nil
+	})
+	return users, err
+}
+
+func ReadSongs(r io.Reader) ([]models.Song, error) {
+	songs := []models.Song{}
+	seen := map[string]bool{}
+
+	err := readRows(r, SongsFile, songsHeader, 0, func(line int, row []string) error {
+		id := row[0]
+		if id == "" {
+			return errors.New("id missing")
+		}
+		if seen[id] {
+			return fmt.Errorf("duplicate id %s", id)
+		}
+		durationMs, err := parseOptionalInt(row[3])
+		if err != nil {
+			return fmt.Errorf("duration_ms %q is not a non-negative integer", row[3])
+		}
+		year, err := parseOptionalInt(row[6])
+		if err != nil {
+			return fmt.Errorf("year %q is not a non-negative integer", row[6])
+		}
+		seen[id] = true
+		songs = append(songs, models.Song{
+			ID:         id,
+			Artist:     row[1],
+			Title:      row[2],
+			DurationMs: durationMs,
+			Album:      row[4],
+			Genre:      row[5],
+			Year:       int(year),
+			ISRC:       row[7],
+		})
+		return nil
+	})
+	return songs, err
+}
+
+// Optional numbers are left empty when they are 0, like in the JSON file.
+func optionalInt(i int64) string {
+	if i == 0 {
+		return ""
+	}
+	return strconv.FormatInt(i, 10)
+}
+
+func parseOptionalInt(field string) (int64, error) {
+	if field == "" {
+		return 0, nil
+	}
+	i, err := strconv.ParseInt(field, 10, 64)
+	if err == nil && i < 0 {
+		err = errors.New("negative")
+	}
+	return i, err
+}
+
+// ReadPlaylistSongs rebuilds playlists from their rows, validating user and
+// song references against the given users and songs. Rows may appear in any
+// order. Playlists keep the order in which they first appear and their songs
+// are ordered by position, which must run from 1 to the number of songs
+// without gaps. A song can only be repeated in a playlist that allows
+// duplicates. A row without a position and a song must be the only row of
+// its playlist.
+func ReadPlaylistSongs(r io.Reader, users []models.User, songs []models.Song) ([]models.Playlist, error) {
+	userIDs := map[string]bool{}
+	for _, user := range users {
+		userIDs[user.ID] = true
+	}
+	songIDs := map[string]bool{}
+	for _, song := range songs {
+		songIDs[song.ID] = true
+	}
+
+	type entry struct {
+		line     int
+		position int
+		songID   string
+	}
+	order := []string{}
+	playlists := map[string]*models.Playlist{}
+	// the line of the row without a song, if any
+	empty := map[string]int{}
+	entries := map[string][]entry{}
+	playlistSongs := map[string]map[string]bool{}
+
+	rowErrs := RowErrors{}
+	err := readRows(r, PlaylistSongsFile, playlistSongsHeader, 5, func(line int, row []string) error {
+		playlistID, userID, songID := row[0], row[1], row[3]
+		if playlistID == "" {
+			return errors.New("playlist_id missing")
+		}
+		position := 0
+		if row[2] != "" || songID != "" {
+			var err error
+			position, err = strconv.Atoi(row[2])
+			if err != nil || position < 1 {
+				return fmt.Errorf("position %q is not a positive integer", row[2])
+			}
+		}
+		if !userIDs[userID] {
+			return fmt.Errorf("user_id %q not in %s", userID, UsersFile)
+		}
+		if position > 0 && !songIDs[songID] {
+			return fmt.Errorf("song_id %q not in %s", songID, SongsFile)
+		}
+		playlist, err := readPlaylist(row, userIDs)
+		if err != nil {
+			return err
+		}
+
+		existing, exist := playlists[playlistID]
+		if !exist {
+			order = append(order, playlistID)
+			playlists[playlistID] = &playlist
+			playlistSongs[playlistID] = map[string]bool{}
+			existing = &playlist
+		} else if err = conflict(playlist, *existing); err != nil {
+			return err
+		}
+		if position == 0 || empty[playlistID] > 0 {
+			if empty[playlistID] > 0 || len(entries[playlistID]) > 0 {
+				return fmt.Errorf("playlist_id %s has songs and a row without a song", playlistID)
+			}
+			empty[playlistID] = line
+			return nil
+		}
+		if playlistSongs[playlistID][songID] && !existing.AllowDuplicates {
+			return fmt.Errorf("song_id %s already in playlist_id %s", songID, playlistID)
+		}
+		playlistSongs[playlistID][songID] = true
+
+		entries[playlistID] = append(entries[playlistID], entry{line, position, songID})
+		return nil
+	})
+	if errs, ok := err.(RowErrors); ok {
+		rowErrs = append(rowErrs, errs...)
+	} else if err != nil {
+		return nil, err
+	}
+
+	result := make([]models.Playlist, 0, len(order))
+	for _, id := range order {
+		list := entries[id]
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].position < list[j].position
+		})
+
+		playlist := *playlists[id]
+		playlist.SongIDs = make([]string, len(list))
+		for i, e := range list {
+			if e.position != i+1 {
+				rowErrs = append(rowErrs, &RowError{
+					File: PlaylistSongsFile,
+					Line: e.line,
+					Err:  fmt.Errorf("position %d of playlist_id %s, expected %d", e.position, id, i+1),
+				})
+			}
+			playlist.SongIDs[i] = e.songID
+		}
+		result = append(result, playlist)
+	}
+
+	if len(rowErrs) > 0 {
+		return nil, rowErrs
+	}
+	return result, nil
+}
+
+// readPlaylist parses the columns of a row that describe its playlist.
+func readPlaylist(row []string, userIDs map[string]bool) (models.Playlist, error) {
+	playlist := models.Playlist{
+		ID:          row[0],
+		UserID:      row[1],
+		Name:        row[5],
+		Description: row[6],
+		Visibility:  models.Visibility(row[7]),
+	}
+	if row[4] != "" {
+		allow, err := strconv.ParseBool(row[4])
+		if err != nil {
+			return playlist, fmt.Errorf("allow_duplicates %q is not true or false", row[4])
+		}
+		playlist.AllowDuplicates = allow
+	}
+	if playlist.Visibility != "" && playlist.Visibility != models.Public && playlist.Visibility != models.Private {
+		return playlist, fmt.Errorf("visibility %q is not %s or %s", row[7], models.Public, models.Private)
+	}
+
+	if row[8] == "" {
+		return playlist, nil
+	}
+	seen := map[string]bool{}
+	for _, pair := range strings.Split(row[8], ";") {
+		i := strings.LastIndex(pair, ":")
+		if i < 0 {
+			return playlist, fmt.Errorf("collaborator %q is not user_id:role", pair)
+		}
+		collaborator := models.Collaborator{UserID: pair[:i], Role: models.Role(pair[i+1:])}
+		if !userIDs[collaborator.UserID] {
+			return playlist, fmt.Errorf("collaborator user_id %q not in %s", collaborator.UserID, UsersFile)
+		}
+		if collaborator.UserID == playlist.UserID {
+			return playlist, fmt.Errorf("collaborator user_id %s owns playlist_id %s", collaborator.UserID, playlist.ID)
+		}
+		if seen[collaborator.UserID] {
+			return playlist, fmt.Errorf("collaborator user_id %s repeated", collaborator.UserID)
+		}
+		if collaborator.Role != models.Editor && collaborator.Role != models.Viewer {
+			return playlist, fmt.Errorf("collaborator role %q is not %s or %s", collaborator.Role, models.Editor, models.Viewer)
+		}
+		seen[collaborator.UserID] = true
+		playlist.Collaborators = append(playlist.Collaborators, collaborator)
+	}
+	return playlist, nil
+}
+
+// conflict tells how a row's playlist columns differ from those of the
+// playlist's first row.
+func conflict(playlist, first models.Playlist) error {
+	if playlist.UserID != first.UserID {
+		return fmt.Errorf("user_id %s conflicts with user_id %s of playlist_id %s", playlist.UserID, first.UserID, first.ID)
+	}
+	if playlist.AllowDuplicates != first.AllowDuplicates {
+		return fmt.Errorf("allow_duplicates %t conflicts with allow_duplicates %t of playlist_id %s", playlist.AllowDuplicates, first.AllowDuplicates, first.ID)
+	}
+	columns := []struct{ name, value, first string }{
+		{"name", playlist.Name, first.Name},
+		{"description", playlist.Description, first.Description},
+		{"visibility", string(playlist.Visibility), string(first.Visibility)},
+	}
+	for _, column := range columns {
+		if column.value != column.first {
+			return fmt.Errorf("%s %q conflicts with %s %q of playlist_id %s", column.name, column.value, column.name, column.first, first.ID)
+		}
+	}
+	if len(playlist.Collaborators) != len(first.Collaborators) {
+		return fmt.Errorf("collaborators conflict with the collaborators of playlist_id %s", first.ID)
+	}
+	for i := range playlist.Collaborators {
+		if playlist.Collaborators[i] != first.Collaborators[i] {
+			return fmt.Errorf("collaborators conflict with the collaborators of playlist_id %s", first.ID)
+		}
+	}
+	return nil
+}
+
+// readRows checks the header row and calls fn for each following row with
+// the line number the row starts on. The last optional columns of header
+// may be left out of the file, in which case fn gets them empty. Rows with
+// the wrong number of fields, malformed quoting or that fn rejects are
+// collected into RowErrors.
+func readRows(r io.Reader, file string, header []string, optional int, fn func(line int, row []string) error) error {
+	cr := csv.NewReader(r)
+	// the number of fields of the header row
+	cr.FieldsPerRecord = 0
+
+	row, err := cr.Read()
+	if err == io.EOF {
+		return &RowError{File: file, Line: 1, Err: errors.New("header row missing")}
+	}
+	if err != nil {
+		return toRowError(file, err)
+	}
+	if len(row) < len(header)-optional || len(row) > len(header) {
+		return &RowError{File: file, Line: 1, Err: fmt.Errorf("header %v, expected %v", row, header)}
+	}
+	for i, name := range row {
+		if strings.TrimSpace(name) != header[i] {
+			return &RowError{File: file, Line: 1, Err: fmt.Errorf("header %v, expected %v", row, header)}
+		}
+	}
+	missing := make([]string, len(header)-len(row))
+
+	rowErrs := RowErrors{}
+	for {
+		row, err = cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rowErr := toRowError(file, err)
+			rowErrs = append(rowErrs, rowErr)
+			// the reader can not recover from malformed quoting
+			if _, ok := err.(*csv.ParseError); ok && !errors.Is(err, csv.ErrFieldCount) {
+				break
+			}
+			continue
+		}
+
+		line, _ := cr.FieldPos(0)
+		err = fn(line, append(row, missing...))
+		if err != nil {
+			rowErrs = append(rowErrs, &RowError{File: file, Line: line, Err: err})
+		}
+	}
+
+	if len(rowErrs) > 0 {
+		return rowErrs
+	}
+	return nil
+}
+
+func toRowError(file string, err error) *RowError {
+	if parseErr, ok := err.(*csv.ParseError); ok {
+		return &RowError{File: file, Line: parseErr.StartLine, Err: parseErr.Err}
+	}
+	return &RowError{File: file, Err: err}
+}
+
+func readFile(path string, fn func(r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fn(f)
+}
+
+func writeFile(path string, fn func(w io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = fn(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,13 @@
+package csvio_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestCsvio(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Csvio Suite")
+}
@@ -0,0 +1,222 @@
+package csvio_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/n4wei/highspot/csvio"
+	"github.com/n4wei/highspot/models"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CSV Import and Export", func() {
+	var mixtape *models.Mixtape
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{
+				{ID: "user_1", Name: "test_user_1"},
+				{ID: "user_2", Name: "Doe, Jane"},
+			},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_2", "song_1"}},
+				{ID: "playlist_2", UserID: "user_2", SongIDs: []string{"song_1"}},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: `say "hello"`},
+				{ID: "song_2", Artist: "some_other_artist", Title: "line\nbreak", DurationMs: 215000, Album: "some_album", Genre: "rock", Year: 1999, ISRC: "USRC17607839"},
+			},
+		}
+	})
+
+	Describe("Export and Import", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "csvio")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should round trip the mixtape, including fields that need quoting", func() {
+			Expect(csvio.Export(mixtape, dir)).To(Succeed())
+
+			imported, err := csvio.Import(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(imported).To(Equal(mixtape))
+		})
+
+		It("should round trip the metadata and collaborators of playlists, and empty playlists", func() {
+			mixtape.Playlists[0].Name = "Road, trip"
+			mixtape.Playlists[0].Description = "some_description"
+			mixtape.Playlists[0].Visibility = models.Private
+			mixtape.Playlists[0].Collaborators = []models.Collaborator{{UserID: "user_2", Role: models.Viewer}}
+			mixtape.Playlists[1].SongIDs = []string{}
+			Expect(csvio.Export(mixtape, dir)).To(Succeed())
+
+			imported, err := csvio.Import(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(imported).To(Equal(mixtape))
+		})
+
+		It("should round trip playlists with duplicate songs", func() {
+			mixtape.Playlists[1] = models.Playlist{ID: "playlist_2", UserID: "user_2", SongIDs: []string{"song_1", "song_2", "song_1"}, AllowDuplicates: true}
+			Expect(csvio.Export(mixtape, dir)).To(Succeed())
+
+			imported, err := csvio.Import(dir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(imported).To(Equal(mixtape))
+		})
+	})
+
+	Describe("ReadUsers", func() {
+		Context("when the header is missing", func() {
+			It("should return an error for line 1", func() {
+				_, err := csvio.ReadUsers(strings.NewReader(""))
+				Expect(err).To(MatchError("users.csv line 1: header row missing"))
+			})
+		})
+
+		Context("when rows are invalid", func() {
+			It("should report every bad row by line number", func() {
+				input := "id,name\nuser_1,a\n,b\nuser_1,c\nuser_2\n"
+				_, err := csvio.ReadUsers(strings.NewReader(input))
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("users.csv line 3: id missing"))
+				Expect(err.Error()).To(ContainSubstring("users.csv line 4: duplicate id user_1"))
+				Expect(err.Error()).To(ContainSubstring("users.csv line 5: wrong number of fields"))
+			})
+		})
+	})
+
+	Describe("ReadSongs", func() {
+		Context("when the optional numbers are invalid", func() {
+			It("should report every bad row by line number", func() {
+				input := "id,artist,title,duration_ms,album,genre,year,isrc\nsong_1,a,b,,,,,\nsong_2,a,b,-1,,,,\nsong_3,a,b,,,,1999s,\n"
+				_, err := csvio.ReadSongs(strings.NewReader(input))
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring(`songs.csv line 3: duration_ms "-1" is not a non-negative integer`))
+				Expect(err.Error()).To(ContainSubstring(`songs.csv line 4: year "1999s" is not a non-negative integer`))
+			})
+		})
+	})
+
+	Describe("ReadPlaylistSongs", func() {
+		read := func(input string) ([]models.Playlist, error) {
+			return csvio.ReadPlaylistSongs(strings.NewReader(input), mixtape.Users, mixtape.Songs)
+		}
+
+		Context("when rows are out of order", func() {
+			It("should order songs by position", func() {
+				playlists, err := read("playlist_id,user_id,position,song_id\np1,user_1,2,song_1\np1,user_1,1,song_2\n")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(playlists).To(Equal([]models.Playlist{
+					{ID: "p1", UserID: "user_1", SongIDs: []string{"song_2", "song_1"}},
+				}))
+			})
+		})
+
+		Context("when rows reference users or songs that do not exist", func() {
+			It("should report them by line number", func() {
+				_, err := read("playlist_id,user_id,position,song_id\np1,user_x,1,song_1\np2,user_1,1,song_x\n")
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring(`playlist_songs.csv line 2: user_id "user_x" not in users.csv`))
+				Expect(err.Error()).To(ContainSubstring(`playlist_songs.csv line 3: song_id "song_x" not in songs.csv`))
+			})
+		})
+
+		Context("when a playlist has conflicting owners", func() {
+			It("should report the conflicting row", func() {
+				_, err := read("playlist_id,user_id,position,song_id\np1,user_1,1,song_1\np1,user_2,2,song_2\n")
+				Expect(err).To(MatchError("playlist_songs.csv line 3: user_id user_2 conflicts with user_id user_1 of playlist_id p1"))
+			})
+		})
+
+		Context("when a song is repeated in a playlist", func() {
+			It("should report the repeated row", func() {
+				_, err := read("playlist_id,user_id,position,song_id\np1,user_1,1,song_1\np1,user_1,2,song_1\n")
+				Expect(err).To(MatchError("playlist_songs.csv line 3: song_id song_1 already in playlist_id p1"))
+			})
+		})
+
+		Context("when a playlist allows duplicates", func() {
+			It("should accept the repeated song", func() {
+				playlists, err := read("playlist_id,user_id,position,song_id,allow_duplicates\np1,user_1,1,song_1,true\np1,user_1,2,song_1,true\n")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(playlists).To(Equal([]models.Playlist{
+					{ID: "p1", UserID: "user_1", SongIDs: []string{"song_1", "song_1"}, AllowDuplicates: true},
+				}))
+			})
+
+			It("should report a row that disagrees", func() {
+				_, err := read("playlist_id,user_id,position,song_id,allow_duplicates\np1,user_1,1,song_1,true\np1,user_1,2,song_2,\n")
+				Expect(err).To(MatchError("playlist_songs.csv line 3: allow_duplicates false conflicts with allow_duplicates true of playlist_id p1"))
+			})
+		})
+
+		Context("when a playlist has no songs", func() {
+			It("should read the row without a song as an empty playlist", func() {
+				playlists, err := read("playlist_id,user_id,position,song_id\np1,user_1,,\n")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(playlists).To(Equal([]models.Playlist{{ID: "p1", UserID: "user_1", SongIDs: []string{}}}))
+			})
+
+			It("should report a row without a song in a playlist with songs", func() {
+				_, err := read("playlist_id,user_id,position,song_id\np1,user_1,1,song_1\np1,user_1,,\n")
+				Expect(err).To(MatchError("playlist_songs.csv line 3: playlist_id p1 has songs and a row without a song"))
+			})
+		})
+
+		Context("when the playlist columns are invalid or disagree", func() {
+			It("should report the rows", func() {
+				_, err := read("playlist_id,user_id,position,song_id,allow_duplicates,name,description,visibility,collaborators\n" +
+					"p1,user_1,1,song_1,,some_name,,,user_2:editor\n" +
+					"p1,user_1,2,song_2,,other_name,,,user_2:editor\n" +
+					"p2,user_1,1,song_1,,,,hidden,\n" +
+					"p3,user_1,1,song_1,,,,,user_1:editor\n" +
+					"p4,user_1,1,song_1,,,,,user_2:owner\n")
+				Expect(err).To(MatchError(ContainSubstring(`line 3: name "other_name" conflicts with name "some_name" of playlist_id p1`)))
+				Expect(err).To(MatchError(ContainSubstring(`line 4: visibility "hidden" is not public or private`)))
+				Expect(err).To(MatchError(ContainSubstring(`line 5: collaborator user_id user_1 owns playlist_id p3`)))
+				Expect(err).To(MatchError(ContainSubstring(`line 6: collaborator role "owner" is not editor or viewer`)))
+			})
+		})
+
+		Context("when positions have gaps", func() {
+			It("should report the row with the unexpected position", func() {
+				_, err := read("playlist_id,user_id,position,song_id\np1,user_1,1,song_1\np1,user_1,3,song_2\n")
+				Expect(err).To(MatchError("playlist_songs.csv line 3: position 3 of playlist_id p1, expected 2"))
+			})
+		})
+
+		Context("when the position is not a number", func() {
+			It("should report the row", func() {
+				_, err := read("playlist_id,user_id,position,song_id\np1,user_1,first,song_1\n")
+				Expect(err).To(MatchError(`playlist_songs.csv line 2: position "first" is not a positive integer`))
+			})
+		})
+	})
+
+	Describe("WritePlaylistSongs", func() {
+		It("should write one row per song with 1-based positions", func() {
+			buf := &bytes.Buffer{}
+			Expect(csvio.WritePlaylistSongs(buf, mixtape.Playlists)).To(Succeed())
+			Expect(buf.String()).To(Equal("playlist_id,user_id,position,song_id,allow_duplicates,name,description,visibility,collaborators\nplaylist_1,user_1,1,song_2,,,,,\nplaylist_1,user_1,2,song_1,,,,,\nplaylist_2,user_2,1,song_1,,,,,\n"))
+		})
+
+		It("should write a row without a song for an empty playlist", func() {
+			mixtape.Playlists[1].SongIDs = []string{}
+			mixtape.Playlists[1].Collaborators = []models.Collaborator{{UserID: "user_1", Role: models.Editor}}
+			buf := &bytes.Buffer{}
+			Expect(csvio.WritePlaylistSongs(buf, mixtape.Playlists[1:])).To(Succeed())
+			Expect(buf.String()).To(Equal("playlist_id,user_id,position,song_id,allow_duplicates,name,description,visibility,collaborators\nplaylist_2,user_2,,,,,,,user_1:editor\n"))
+		})
+	})
+})
@@ -0,0 +1,95 @@
+package dedupe
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/n4wei/highspot/fileio"
+)
+
+// A store records the idempotency keys of changes that were already
+// processed, so that retrying a run skips them instead of applying them
+// again. It is kept in its own file next to the mixtape, and must be saved
+// whenever the mixtape is. Keys are recorded whether the change was applied
+// or skipped, since replaying a skipped change against a changed mixtape
+// could apply it. The exceptions are changes skipped for a reason a retry
+// is meant to fix: an unauthorized actor, a stale if_match or a failed
+// precondition.
+type Store struct {
+	keys map[string]bool
+}
+
+// The file lists keys sorted, so the same keys always write the same file
+type file struct {
+	Keys []string `json:"keys"`
+}
+
+func New() *Store {
+	return &Store{keys: map[string]bool{}}
+}
+
+func (s *Store) Seen(key string) bool {
+	return s.keys[key]
+}
+
+func (s *Store) Record(key string) {
+	s.keys[key] = true
+}
+
+// Keys returns the recorded keys, sorted.
+func (s *Store) Keys() []string {
+	keys := make([]string, 0, len(s.keys))
+	for key := range s.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func Write(w io.Writer, s *Store) error {
+	return json.NewEncoder(w).Encode(file{Keys: s.Keys()})
+}
+
+func Read(r io.Reader) (*Store, error) {
+	f := file{}
+	err := json.NewDecoder(r).Decode(&f)
+	if err != nil {
+		return nil, err
+	}
+
+	s := New()
+	for _, key := range f.Keys {
+		s.Record(key)
+	}
+	return s, nil
+}
+
+func WriteFile(filepath string, s *Store) error {
+	w, err := fileio.Create(filepath, fileio.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	err = Write(w, s)
+	if err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// ReadFile returns an empty store if the file does not exist yet, ie. on
+// the first run.
+func ReadFile(filepath string) (*Store, error) {
+	r, err := fileio.Open(filepath)
+	if errors.Is(err, os.ErrNotExist) {
+		return New(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return Read(r)
+}
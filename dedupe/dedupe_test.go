@@ -0,0 +1,67 @@
+package dedupe_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/n4wei/highspot/dedupe"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dedupe Store", func() {
+	var store *dedupe.Store
+
+	BeforeEach(func() {
+		store = dedupe.New()
+		store.Record("key_b")
+		store.Record("key_a")
+	})
+
+	It("should tell which keys were recorded", func() {
+		Expect(store.Seen("key_a")).To(BeTrue())
+		Expect(store.Seen("key_c")).To(BeFalse())
+		Expect(store.Keys()).To(Equal([]string{"key_a", "key_b"}))
+	})
+
+	It("should write keys sorted and read them back", func() {
+		buf := &bytes.Buffer{}
+		Expect(dedupe.Write(buf, store)).To(Succeed())
+		Expect(buf.String()).To(Equal(`{"keys":["key_a","key_b"]}` + "\n"))
+
+		read, err := dedupe.Read(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read).To(Equal(store))
+	})
+
+	Context("with files", func() {
+		var dir string
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "dedupe")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should start empty when the file does not exist yet", func() {
+			read, err := dedupe.ReadFile(filepath.Join(dir, "keys.json"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(read.Keys()).To(BeEmpty())
+		})
+
+		It("should round trip through a file", func() {
+			file := filepath.Join(dir, "keys.json")
+			Expect(dedupe.WriteFile(file, store)).To(Succeed())
+
+			read, err := dedupe.ReadFile(file)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(read).To(Equal(store))
+		})
+	})
+})
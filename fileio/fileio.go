@@ -0,0 +1,174 @@
+package fileio
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression is picked by extension when writing, and by magic bytes when
+// reading, so a compressed file that was renamed still reads correctly.
+const (
+	GzipExtension = ".gz"
+	ZstdExtension = ".zst"
+
+	// DefaultCompression lets each compressor pick its default level.
+	DefaultCompression = -1
+
+	// Stdio as a filepath means stdin when reading and stdout when writing.
+	Stdio = "-"
+
+	defaultFilePermission = 0666
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// TrimCompression removes a compression extension, so the remaining
+// extension can be used to pick the file format, eg. mixtape.snap.gz.
+func TrimCompression(filepath string) string {
+	switch path.Ext(filepath) {
+	case GzipExtension, ZstdExtension:
+		return strings.TrimSuffix(filepath, path.Ext(filepath))
+	}
+	return filepath
+}
+
+// Open opens a file for reading and decompresses it on the fly if it
+// starts with gzip or zstd magic bytes. Stdio reads from stdin, which is
+// left open on Close.
+func Open(filepath string) (io.ReadCloser, error) {
+	if filepath == Stdio {
+		return NewReader(os.Stdin)
+	}
+
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &readCloser{Reader: r, closers: []io.Closer{r, f}}, nil
+}
+
+// NewReader wraps r with a decompressor if it starts with gzip or zstd
+// magic bytes. The returned reader must be closed, which does not close r.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	// a short read means a file too small to be compressed, which is
+	// left for the caller to fail on
+	head, _ := br.Peek(len(zstdMagic))
+
+	switch {
+	case bytes.HasPrefix(head, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(head, zstdMagic):
+		d, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return io.NopCloser(br), nil
+}
+
+// Create creates a file for writing and compresses it on the fly if it
+// has a .gz or .zst extension. Level is a gzip level (1-9) or a zstd level
+// (1-22), or DefaultCompression. Closing the writer flushes the compressor
+// and closes the file. Stdio writes uncompressed to stdout, which is left
+// open on Close.
+func Create(filepath string, level int) (io.WriteCloser, error) {
+	if filepath == Stdio {
+		bw := bufio.NewWriter(os.Stdout)
+		return &writeCloser{Writer: bw, closers: []io.Closer{flusher{bw}}}, nil
+	}
+
+	// the compressor is set up first, so that an invalid level does not
+	// truncate the file, and writes to the file once it is opened
+	bw := bufio.NewWriter(nil)
+	var w io.WriteCloser
+	var err error
+	switch path.Ext(filepath) {
+	case GzipExtension:
+		w, err = gzip.NewWriterLevel(bw, level)
+	case ZstdExtension:
+		options := []zstd.EOption{}
+		if level != DefaultCompression {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		w, err = zstd.NewWriter(bw, options...)
+	default:
+		w = nopWriteCloser{bw}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, defaultFilePermission)
+	if err != nil {
+		// stops the zstd encoder's goroutines
+		w.Close()
+		return nil, err
+	}
+	bw.Reset(f)
+
+	return &writeCloser{Writer: w, closers: []io.Closer{w, flusher{bw}, f}}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+type writeCloser struct {
+	io.Writer
+	closers []io.Closer
+}
+
+func (r *readCloser) Close() error {
+	return closeAll(r.closers)
+}
+
+func (w *writeCloser) Close() error {
+	return closeAll(w.closers)
+}
+
+// closeAll closes in order, and returns the first error after trying all.
+func closeAll(closers []io.Closer) error {
+	var firstErr error
+	for _, c := range closers {
+		err := c.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+type flusher struct {
+	w *bufio.Writer
+}
+
+func (f flusher) Close() error {
+	return f.w.Flush()
+}
@@ -0,0 +1,13 @@
+package fileio_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestFileio(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Fileio Suite")
+}
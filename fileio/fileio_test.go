@@ -0,0 +1,98 @@
+package fileio_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/n4wei/highspot/fileio"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compressed Files", func() {
+	var (
+		dir     string
+		content string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "fileio")
+		Expect(err).ToNot(HaveOccurred())
+		content = strings.Repeat(`{"id":"1","name":"Albin Jaye"}`, 100)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	write := func(name string, level int) string {
+		path := filepath.Join(dir, name)
+		w, err := fileio.Create(path, level)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = w.Write([]byte(content))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+		return path
+	}
+
+	read := func(path string) string {
+		r, err := fileio.Open(path)
+		Expect(err).ToNot(HaveOccurred())
+		defer r.Close()
+		bytes, err := ioutil.ReadAll(r)
+		Expect(err).ToNot(HaveOccurred())
+		return string(bytes)
+	}
+
+	DescribeTable("round trips by extension",
+		func(name string, level int, compressed bool) {
+			path := write(name, level)
+			Expect(read(path)).To(Equal(content))
+
+			raw, err := ioutil.ReadFile(path)
+			Expect(err).ToNot(HaveOccurred())
+			if compressed {
+				Expect(len(raw)).To(BeNumerically("<", len(content)))
+			} else {
+				Expect(string(raw)).To(Equal(content))
+			}
+		},
+		Entry("plain", "mixtape.json", fileio.DefaultCompression, false),
+		Entry("gzip", "mixtape.json.gz", fileio.DefaultCompression, true),
+		Entry("gzip with a level", "mixtape.json.gz", 9, true),
+		Entry("zstd", "mixtape.json.zst", fileio.DefaultCompression, true),
+		Entry("zstd with a level", "mixtape.json.zst", 19, true),
+	)
+
+	Context("when a compressed file does not have a compression extension", func() {
+		It("should detect compression by magic bytes", func() {
+			path := write("mixtape.json.zst", fileio.DefaultCompression)
+			renamed := filepath.Join(dir, "mixtape.json")
+			Expect(os.Rename(path, renamed)).To(Succeed())
+
+			Expect(read(renamed)).To(Equal(content))
+		})
+	})
+
+	Context("when the gzip level is invalid", func() {
+		It("should return an error and leave an existing file as it is", func() {
+			path := write("mixtape.json.gz", fileio.DefaultCompression)
+
+			_, err := fileio.Create(path, 42)
+			Expect(err).To(HaveOccurred())
+			Expect(read(path)).To(Equal(content))
+		})
+	})
+
+	Describe("TrimCompression", func() {
+		It("should only remove compression extensions", func() {
+			Expect(fileio.TrimCompression("a/mixtape.snap.gz")).To(Equal("a/mixtape.snap"))
+			Expect(fileio.TrimCompression("mixtape.snap.zst")).To(Equal("mixtape.snap"))
+			Expect(fileio.TrimCompression("mixtape.json")).To(Equal("mixtape.json"))
+		})
+	})
+})
@@ -5,61 +5,275 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"io"
+	"log/slog"
 	"os"
+	"path"
+	"time"
 
 	"github.com/n4wei/highspot/collection"
+	"github.com/n4wei/highspot/dedupe"
+	"github.com/n4wei/highspot/fileio"
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
 	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/patch"
+	"github.com/n4wei/highspot/policy"
+	"github.com/n4wei/highspot/snapshot"
+	"github.com/n4wei/highspot/state"
+	"github.com/n4wei/highspot/util"
 )
 
-const (
-	logFormat             = log.Ldate | log.Ltime | log.Lshortfile | log.LUTC
-	defaultFilePermission = 0666
-)
+// Subcommands are dispatched on the first argument. Running the binary
+// without a subcommand applies changes, as it always has, the same as the
+// apply subcommand.
+var commands = map[string]func(args []string){
+	"import":    runImport,
+	"export":    runExport,
+	"convert":   runConvert,
+	"apply":     runApply,
+	"plan":      runPlan,
+	"shard":     runShard,
+	"unshard":   runUnshard,
+	"query":     runQuery,
+	"stats":     runStats,
+	"recommend": runRecommend,
+}
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, exist := commands[os.Args[1]]; exist {
+			command(os.Args[2:])
+			return
+		}
+	}
+	runApply(os.Args[1:])
+}
+
+func runApply(args []string) {
 	// Parse command line flags
-	var mixtapeFile, changesFile, outputFile string
-	flag.StringVar(&mixtapeFile, "m", "", "filepath to the JSON mixtape file")
-	flag.StringVar(&changesFile, "c", "", "filepath to the JSON changes file")
-	flag.StringVar(&outputFile, "o", "./output.json", "filepath to write changed mixtape JSON file")
-	flag.Parse()
+	var mixtapeFile, changesFile, stateFile, outputFile, changesFormatName, patchFile, reportFile, dedupeFile, policyFile, now, onFailure, logFormat, logLevel string
+	var compressionLevel, workers, maxSongs, maxMinutes int
+	var planOpts state.Options
+	flags := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flags.StringVar(&mixtapeFile, "m", "", "filepath to the JSON mixtape file, - for stdin")
+	flags.StringVar(&changesFile, "c", "", "filepath to the JSON changes file, - for stdin")
+	flags.StringVar(&outputFile, "o", "./output.json", "filepath to write changed mixtape JSON file, - for stdout")
+	flags.StringVar(&stateFile, "s", "", "filepath to a JSON desired state to apply instead of a changes file, see the plan command, - for stdin")
+	flags.BoolVar(&planOpts.Prune, "prune", false, "with -s, remove the playlists that are not in the desired state, instead of leaving them alone")
+	flags.StringVar(&planOpts.Actor, "actor", "", "with -s, user ID the planned changes are made by, for -a to authorize them")
+	flags.StringVar(&changesFormatName, "f", changesFormat, "format of the changes file: changes, json-patch (RFC 6902) or merge-patch (RFC 7396)")
+	flags.StringVar(&patchFile, "p", "", "filepath to write the changes that took effect as a JSON Patch, - for stdout")
+	flags.StringVar(&reportFile, "r", "", "filepath to write a JSON report of what happened to each change, - for stdout")
+	flags.StringVar(&dedupeFile, "k", "", "filepath to the store of processed idempotency keys, kept next to the mixtape, created if missing")
+	flags.StringVar(&policyFile, "a", "", "filepath to the JSON authorization policy, changes are then only applied if their actor may make them")
+	flags.StringVar(&now, "now", "", "RFC 3339 time to timestamp changed playlists with instead of the current time, for reproducible output")
+	flags.IntVar(&maxSongs, "max-songs", 0, "maximum number of songs in a playlist, songs past it are not added, 0 for no limit")
+	flags.IntVar(&maxMinutes, "max-minutes", 0, "maximum total duration of a playlist in minutes, songs past it are not added, 0 for no limit")
+	flags.StringVar(&onFailure, "on-precondition-failure", string(models.SkipChange), "what happens when a change's preconditions fail, unless the change says: skip the change, or fail the whole run")
+	flags.IntVar(&workers, "j", 1, "number of workers applying independent changes in parallel, the result is the same as with 1")
+	flags.StringVar(&logFormat, "log-format", util.TextFormat, "format of logs: text or json")
+	flags.StringVar(&logLevel, "log-level", "info", "lowest level of logs to output: debug, info, warn or error")
+	addCompressionFlag(flags, &compressionLevel)
+	flags.Parse(args)
 
-	if mixtapeFile == "" || changesFile == "" {
-		handleFlagError(errors.New("missing required flags -m and -c"))
+	if mixtapeFile == "" || (changesFile == "") == (stateFile == "") {
+		handleFlagError(flags, errors.New("missing required flags -m and one of -c or -s"))
+	}
+	if planOpts.Actor != "" && stateFile == "" {
+		handleFlagError(flags, errors.New("-actor only applies with -s, changes files name their own actor"))
+	}
+	if workers < 1 {
+		handleFlagError(flags, errors.New("-j must be at least 1"))
+	}
+	if maxSongs < 0 || maxMinutes < 0 {
+		handleFlagError(flags, errors.New("-max-songs and -max-minutes can not be negative"))
+	}
+	if onFailure != string(models.SkipChange) && onFailure != string(models.FailBatch) {
+		handleFlagError(flags, errors.New("-on-precondition-failure must be skip or fail"))
+	}
+	if mixtapeFile == fileio.Stdio && (changesFile == fileio.Stdio || stateFile == fileio.Stdio) {
+		handleFlagError(flags, errors.New("only one of -m and -c or -s can read from stdin"))
+	}
+	stdout := 0
+	for _, file := range []string{outputFile, patchFile, reportFile} {
+		if file == fileio.Stdio {
+			stdout++
+		}
+	}
+	if stdout > 1 {
+		handleFlagError(flags, errors.New("only one of -o, -p and -r can write to stdout"))
 	}
 
-	// Read mixtape file
-	mixtape := &models.Mixtape{}
-	err := readFromFile(mixtapeFile, mixtape)
+	// Read mixtape file, a snapshot also carries the lookup hash maps
+	mixtape, index, err := readMixtape(mixtapeFile)
 	handleError(err)
 
-	// Read changes file
-	changes := &models.Changes{}
-	err = readFromFile(changesFile, changes)
+	// Read changes file, or plan the changes to the desired state
+	var changes *models.Changes
+	if stateFile != "" {
+		changes, err = readState(stateFile, mixtape, planOpts)
+	} else {
+		changes, err = readChanges(changesFile, changesFormatName, mixtape)
+	}
 	handleError(err)
 
 	// Create the object used to apply changes to mixtape
-	logger := log.New(os.Stdout, "", logFormat)
-	collection := collection.New(mixtape, logger)
+	logger, err := newLogger(logFormat, logLevel, outputFile == fileio.Stdio || patchFile == fileio.Stdio || reportFile == fileio.Stdio)
+	if err != nil {
+		handleFlagError(flags, err)
+	}
+	opts := []mixtape_pkg.Option{mixtape_pkg.WithPreconditionFailure(models.FailureAction(onFailure))}
+	var store *dedupe.Store
+	if dedupeFile != "" {
+		store, err = dedupe.ReadFile(dedupeFile)
+		handleError(err)
+		opts = append(opts, mixtape_pkg.WithDedupe(store))
+	}
+	if now != "" {
+		t, err := time.Parse(time.RFC3339, now)
+		if err != nil {
+			handleFlagError(flags, fmt.Errorf("invalid -now: %v", err))
+		}
+		opts = append(opts, mixtape_pkg.WithClock(func() time.Time { return t }))
+	}
+	if maxSongs > 0 || maxMinutes > 0 {
+		opts = append(opts, mixtape_pkg.WithLimits(mixtape_pkg.Limits{
+			MaxSongs:    maxSongs,
+			MaxDuration: time.Duration(maxMinutes) * time.Minute,
+		}))
+	}
+	if policyFile != "" {
+		p, err := policy.ReadFile(policyFile)
+		handleError(err)
+		opts = append(opts, mixtape_pkg.WithPolicy(p))
+	}
+	// the playlists as they are before the changes, which the indices
+	// of the JSON Patch refer to
+	var document *patch.Document
+	if patchFile != "" {
+		document = patch.NewDocument(mixtape)
+	}
+	var c collection.Collection
+	if index != nil {
+		c = collection.NewWithIndex(mixtape, index, logger, opts...)
+	} else {
+		c = collection.New(mixtape, logger, opts...)
+	}
 
 	// Apply changes to mixtape
-	err = collection.ApplyChanges(changes)
+	if workers > 1 {
+		err = c.ApplyChangesParallel(changes, workers)
+	} else {
+		err = c.ApplyChanges(changes)
+	}
 	handleError(err)
 
-	// Write mixtape to file
-	err = writeToFile(mixtape, outputFile)
+	// Built before anything is written, so that changes that can not be
+	// expressed as JSON Patch fail the run with nothing on disk
+	var jsonPatch patch.Patch
+	if patchFile != "" {
+		jsonPatch, err = document.FromChanges(c.Applied())
+		handleError(err)
+	}
+
+	// Write mixtape to file, the index of a snapshot input was kept up to
+	// date while applying changes
+	err = writeMixtape(mixtape, index, outputFile, compressionLevel)
 	handleError(err)
+
+	// saved right after the mixtape, the keys describe what is in it
+	if store != nil {
+		err = dedupe.WriteFile(dedupeFile, store)
+		handleError(err)
+	}
+
+	if patchFile != "" {
+		err = writePatch(jsonPatch, patchFile)
+		handleError(err)
+	}
+
+	if reportFile != "" {
+		err = writeToFile(c.Report(), reportFile, fileio.DefaultCompression)
+		handleError(err)
+	}
+}
+
+// newLogger sends skipped changes (warnings) and errors to stderr, and the
+// rest to stdout. When stdout is used for output, all logs go to stderr so
+// that stdout stays clean JSON.
+func newLogger(format, levelName string, stdoutTaken bool) (util.Logger, error) {
+	level, err := util.ParseLevel(levelName)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %s: %v", levelName, err)
+	}
+
+	stderr, err := util.NewHandler(os.Stderr, format, level)
+	if err != nil {
+		return nil, err
+	}
+	if stdoutTaken {
+		return util.NewSlogLogger(slog.New(stderr)), nil
+	}
+
+	stdout, err := util.NewHandler(os.Stdout, format, level)
+	if err != nil {
+		return nil, err
+	}
+	return util.NewSlogLogger(slog.New(util.NewSplitHandler(stdout, stderr, slog.LevelWarn))), nil
+}
+
+// Files ending in .gz or .zst are compressed on write, and compressed
+// input is detected by its magic bytes regardless of extension.
+func addCompressionFlag(flags *flag.FlagSet, level *int) {
+	flags.IntVar(level, "z", fileio.DefaultCompression, "compression level for .gz (1-9) or .zst (1-22) output files, -1 for the default level")
+}
+
+// readMixtape reads a JSON mixtape, or a snapshot if the file has the
+// snapshot extension, ignoring any compression extension after it.
+// The index is only returned for snapshots.
+func readMixtape(filepath string) (*models.Mixtape, *mixtape_pkg.Index, error) {
+	if path.Ext(fileio.TrimCompression(filepath)) == snapshot.Extension {
+		r, err := fileio.Open(filepath)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer r.Close()
+		return snapshot.Read(r)
+	}
+
+	mixtape := &models.Mixtape{}
+	err := readFromFile(filepath, mixtape)
+	return mixtape, nil, err
 }
 
+// writeMixtape writes a JSON mixtape, or a snapshot if the file has the
+// snapshot extension. A nil index is built from the mixtape if needed.
+func writeMixtape(mixtape *models.Mixtape, index *mixtape_pkg.Index, filepath string, level int) error {
+	if path.Ext(fileio.TrimCompression(filepath)) == snapshot.Extension {
+		w, err := fileio.Create(filepath, level)
+		if err != nil {
+			return err
+		}
+		err = snapshot.Write(w, mixtape, index)
+		if err != nil {
+			w.Close()
+			return err
+		}
+		return w.Close()
+	}
+	return writeToFile(mixtape, filepath, level)
+}
+
+// Both JSON helpers stream through the (de)compressor instead of
+// buffering whole files in memory.
 func readFromFile(filepath string, object interface{}) error {
-	bytes, err := ioutil.ReadFile(filepath)
+	r, err := fileio.Open(filepath)
 	if err != nil {
 		return err
 	}
+	defer r.Close()
 
-	err = json.Unmarshal(bytes, object)
+	err = json.NewDecoder(r).Decode(object)
 	if err != nil {
 		return fmt.Errorf("error unmarshaling %s to JSON: %v", filepath, err)
 	}
@@ -67,18 +281,35 @@ func readFromFile(filepath string, object interface{}) error {
 	return nil
 }
 
-func writeToFile(mixtape *models.Mixtape, filepath string) error {
-	bytes, err := json.Marshal(mixtape)
+// readAll is for files that are needed whole, eg. to find the source line
+// of each change.
+func readAll(filepath string) ([]byte, error) {
+	r, err := fileio.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func writeToFile(object interface{}, filepath string, level int) error {
+	w, err := fileio.Create(filepath, level)
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(w).Encode(object)
 	if err != nil {
-		return fmt.Errorf("error marshaling Mixtape object to JSON: %v", err)
+		w.Close()
+		return fmt.Errorf("error marshaling %s to JSON: %v", filepath, err)
 	}
 
-	return ioutil.WriteFile(filepath, bytes, defaultFilePermission)
+	return w.Close()
 }
 
-func handleFlagError(err error) {
+func handleFlagError(flags *flag.FlagSet, err error) {
 	fmt.Fprintf(os.Stderr, "Error parsing flags: %v\n", err)
-	flag.PrintDefaults()
+	flags.PrintDefaults()
 	os.Exit(1)
 }
 
@@ -1,17 +1,26 @@
 package main_test
 
 import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 
+	"github.com/n4wei/highspot/analytics"
+	"github.com/n4wei/highspot/models"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
 
+// timestamps the expected output was generated with
+const now = "2020-01-02T03:04:05Z"
+
 var _ = Describe("End-to-End Integration Tests", func() {
 	Context("Running the code with real inputs", func() {
 		It("should produce the expected output JSON file", func() {
-			highspotCmd := exec.Command("go", "run", "./main.go", "-m", "./test_assets/expected/input.json", "-c", "./test_assets/expected/changes.json", "-o", "./results.json")
+			highspotCmd := exec.Command("go", "run", ".", "-m", "./test_assets/expected/input.json", "-c", "./test_assets/expected/changes.json", "-o", "./results.json", "-now", now)
 			err := highspotCmd.Run()
 			Expect(err).ToNot(HaveOccurred())
 
@@ -24,5 +33,288 @@ var _ = Describe("End-to-End Integration Tests", func() {
 			err = os.Remove("./results.json")
 			Expect(err).ToNot(HaveOccurred())
 		})
+
+		It("should read from stdin and write to stdout when given -", func() {
+			changes, err := os.Open("./test_assets/expected/changes.json")
+			Expect(err).ToNot(HaveOccurred())
+			defer changes.Close()
+
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			highspotCmd := exec.Command("go", "run", ".", "-m", "./test_assets/expected/input.json", "-c", "-", "-o", "-", "-now", now)
+			highspotCmd.Stdin = changes
+			highspotCmd.Stdout = stdout
+			highspotCmd.Stderr = stderr
+			err = highspotCmd.Run()
+			Expect(err).ToNot(HaveOccurred())
+
+			expected, err := ioutil.ReadFile("./test_assets/expected/output_compact.json")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(stdout.String()).To(Equal(string(expected)))
+			Expect(stderr.String()).To(ContainSubstring(`msg="added playlist" change=add change_index=2 correlation_id=stdin:26 playlist_id=4`))
+		})
+
+		It("should refuse to write more than one output to stdout", func() {
+			stderr := &bytes.Buffer{}
+			highspotCmd := exec.Command("go", "run", ".", "-m", "./test_assets/expected/input.json", "-c", "./test_assets/expected/changes.json", "-o", "-", "-r", "-")
+			highspotCmd.Stderr = stderr
+			Expect(highspotCmd.Run()).ToNot(Succeed())
+			Expect(stderr.String()).To(ContainSubstring("only one of -o, -p and -r can write to stdout"))
+		})
+
+		It("should log skipped changes to stderr and the rest to stdout", func() {
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			highspotCmd := exec.Command("go", "run", ".", "-m", "./test_assets/expected/input.json", "-c", "./test_assets/expected/changes.json", "-o", "./results.json", "-log-format", "json")
+			highspotCmd.Stdout = stdout
+			highspotCmd.Stderr = stderr
+			err := highspotCmd.Run()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(stdout.String()).To(ContainSubstring(`"level":"INFO","msg":"added playlist","change":"add","change_index":2,"correlation_id":"./test_assets/expected/changes.json:26","playlist_id":"4"`))
+			Expect(stdout.String()).ToNot(ContainSubstring(`"level":"WARN"`))
+			Expect(stderr.String()).To(ContainSubstring(`"level":"WARN","msg":"skipped change","change":"add","change_index":3,"correlation_id":"./test_assets/expected/changes.json:38","playlist_id":"3","reason":"playlist_id already exists"`))
+			Expect(stderr.String()).ToNot(ContainSubstring(`"level":"INFO"`))
+
+			err = os.Remove("./results.json")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should report what happened to each change with its source line", func() {
+			stdout := &bytes.Buffer{}
+			highspotCmd := exec.Command("go", "run", ".", "-m", "./test_assets/expected/input.json", "-c", "./test_assets/expected/changes.json", "-o", "./results.json", "-r", "-")
+			highspotCmd.Stdout = stdout
+			err := highspotCmd.Run()
+			Expect(err).ToNot(HaveOccurred())
+
+			report := &models.Report{}
+			err = json.Unmarshal(stdout.Bytes(), report)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(report.Entries).To(HaveLen(10))
+			Expect(report.Entries[3]).To(Equal(models.ReportEntry{
+				ChangeIndex:   3,
+				CorrelationID: "./test_assets/expected/changes.json:38",
+				Source:        "./test_assets/expected/changes.json:38",
+				Change:        models.Add,
+				PlaylistID:    "3",
+				Status:        models.Skipped,
+				Reason:        "playlist_id already exists",
+			}))
+
+			err = os.Remove("./results.json")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should make a retried run with idempotency keys a no-op", func() {
+			dir, err := ioutil.TempDir("", "highspot")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			changes := filepath.Join(dir, "changes.json")
+			err = ioutil.WriteFile(changes, []byte(`{"playlist_changes": [
+				{"id": "remove", "playlist": {"id": "1"}, "idempotency_key": "remove-1"},
+				{"id": "add", "playlist": {"id": "1", "user_id": "2", "song_ids": ["8"]}, "idempotency_key": "readd-1"}
+			]}`), 0644)
+			Expect(err).ToNot(HaveOccurred())
+
+			output, keys := filepath.Join(dir, "output.json"), filepath.Join(dir, "output.keys.json")
+			highspotCmd := exec.Command("go", "run", ".", "-m", "./test_assets/expected/input.json", "-c", changes, "-o", output, "-k", keys)
+			Expect(highspotCmd.Run()).To(Succeed())
+			first, err := ioutil.ReadFile(output)
+			Expect(err).ToNot(HaveOccurred())
+
+			// the retry runs on its own output
+			highspotCmd = exec.Command("go", "run", ".", "-m", output, "-c", changes, "-o", output, "-k", keys)
+			Expect(highspotCmd.Run()).To(Succeed())
+			second, err := ioutil.ReadFile(output)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(second).To(Equal(first))
+		})
+	})
+
+	Context("Writing the applied changes as a JSON Patch", func() {
+		It("should write nothing when a change can not be expressed as JSON Patch", func() {
+			dir, err := ioutil.TempDir("", "highspot")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			changes := filepath.Join(dir, "changes.json")
+			err = ioutil.WriteFile(changes, []byte(`{"playlist_changes": [
+				{"id": "remove_songs", "playlist": {"id": "1", "song_ids": ["2"]}, "mode": "all", "idempotency_key": "remove-1"}
+			]}`), 0644)
+			Expect(err).ToNot(HaveOccurred())
+
+			output, keys, p := filepath.Join(dir, "output.json"), filepath.Join(dir, "output.keys.json"), filepath.Join(dir, "patch.json")
+			highspotCmd := exec.Command("go", "run", ".", "-m", "./test_assets/expected/input.json", "-c", changes, "-o", output, "-k", keys, "-p", p)
+			Expect(highspotCmd.Run()).ToNot(Succeed())
+			for _, file := range []string{output, keys, p} {
+				_, err = os.Stat(file)
+				Expect(os.IsNotExist(err)).To(BeTrue(), file)
+			}
+		})
+	})
+
+	Context("Planning and applying a desired state", func() {
+		It("should plan the changes to the desired state, and apply them", func() {
+			dir, err := ioutil.TempDir("", "highspot")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			desired := filepath.Join(dir, "desired.json")
+			err = ioutil.WriteFile(desired, []byte(`{"playlists": [
+				{"id": "1", "song_ids": ["2", "8"]},
+				{"id": "4", "user_id": "3", "song_ids": ["1"]}
+			]}`), 0644)
+			Expect(err).ToNot(HaveOccurred())
+
+			stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+			highspotCmd := exec.Command("go", "run", ".", "plan", "-m", "./test_assets/expected/input.json", "-s", desired, "-prune")
+			highspotCmd.Stdout = stdout
+			highspotCmd.Stderr = stderr
+			Expect(highspotCmd.Run()).To(Succeed())
+			Expect(stderr.String()).To(Equal("Plan: 1 to add, 1 to change, 2 to remove.\n"))
+			changes := &models.Changes{}
+			Expect(json.Unmarshal(stdout.Bytes(), changes)).To(Succeed())
+			Expect(changes.PlaylistChanges).To(HaveLen(4))
+
+			output := filepath.Join(dir, "output.json")
+			highspotCmd = exec.Command("go", "run", ".", "apply", "-m", "./test_assets/expected/input.json", "-s", desired, "-prune", "-o", output)
+			Expect(highspotCmd.Run()).To(Succeed())
+			mixtape := &models.Mixtape{}
+			data, err := ioutil.ReadFile(output)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(json.Unmarshal(data, mixtape)).To(Succeed())
+			Expect(mixtape.Playlists).To(HaveLen(2))
+			Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"2", "8"}))
+			Expect(mixtape.Playlists[1].ID).To(Equal("4"))
+
+			// the mixtape is now as desired
+			stdout = &bytes.Buffer{}
+			highspotCmd = exec.Command("go", "run", ".", "plan", "-m", output, "-s", desired, "-prune")
+			highspotCmd.Stdout = stdout
+			Expect(highspotCmd.Run()).To(Succeed())
+			Expect(json.Unmarshal(stdout.Bytes(), changes)).To(Succeed())
+			Expect(changes.PlaylistChanges).To(BeEmpty())
+		})
+	})
+
+	Context("Applying a desired state under a policy", func() {
+		It("should make the planned changes as the given actor", func() {
+			dir, err := ioutil.TempDir("", "highspot")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			desired, policy := filepath.Join(dir, "desired.json"), filepath.Join(dir, "policy.json")
+			err = ioutil.WriteFile(desired, []byte(`{"playlists": [{"id": "2", "song_ids": ["1"]}]}`), 0644)
+			Expect(err).ToNot(HaveOccurred())
+			err = ioutil.WriteFile(policy, []byte(`{"admins": ["1"]}`), 0644)
+			Expect(err).ToNot(HaveOccurred())
+
+			apply := func(actor string) []string {
+				output := filepath.Join(dir, "output.json")
+				highspotCmd := exec.Command("go", "run", ".", "apply", "-m", "./test_assets/expected/input.json", "-s", desired, "-a", policy, "-actor", actor, "-o", output)
+				Expect(highspotCmd.Run()).To(Succeed())
+				mixtape := &models.Mixtape{}
+				data, err := ioutil.ReadFile(output)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(json.Unmarshal(data, mixtape)).To(Succeed())
+				return mixtape.Playlists[1].SongIDs
+			}
+			// user 2 owns playlist 2, user 3 may not change it
+			Expect(apply("2")).To(Equal([]string{"1"}))
+			Expect(apply("3")).To(Equal([]string{"5", "6", "7"}))
+		})
+	})
+
+	Context("Sharding a mixtape", func() {
+		It("should compress the shard files with -ext", func() {
+			dir, err := ioutil.TempDir("", "highspot")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			highspotCmd := exec.Command("go", "run", ".", "shard", "-m", "./test_assets/expected/input.json", "-c", "./test_assets/expected/changes.json", "-n", "2", "-d", dir, "-ext", ".gz")
+			Expect(highspotCmd.Run()).To(Succeed())
+			for _, name := range []string{"mixtape-0.json.gz", "changes-0.json.gz", "mixtape-1.json.gz", "changes-1.json.gz"} {
+				data, err := ioutil.ReadFile(filepath.Join(dir, name))
+				Expect(err).ToNot(HaveOccurred())
+				// gzip magic bytes
+				Expect(data).To(HavePrefix("\x1f\x8b"), name)
+			}
+
+			output := filepath.Join(dir, "output.json")
+			highspotCmd = exec.Command("go", "run", ".", "unshard", "-m", "./test_assets/expected/input.json", "-o", output, filepath.Join(dir, "mixtape-0.json.gz"), filepath.Join(dir, "mixtape-1.json.gz"))
+			Expect(highspotCmd.Run()).To(Succeed())
+		})
+	})
+
+	Context("Querying a mixtape", func() {
+		query := func(args ...string) string {
+			stdout := &bytes.Buffer{}
+			highspotCmd := exec.Command("go", append([]string{"run", ".", "query"}, args...)...)
+			highspotCmd.Stdout = stdout
+			Expect(highspotCmd.Run()).To(Succeed())
+			return stdout.String()
+		}
+
+		It("should show a playlist with its songs resolved as JSON", func() {
+			output := query("playlist", "-m", "./test_assets/expected/input.json", "-p", "2", "-format", "json")
+			Expect(output).To(MatchJSON(`{
+				"id": "2",
+				"user_id": "2",
+				"song_ids": ["5", "6", "7"],
+				"songs": [
+					{"id": "5", "artist": "Bebe Rexha", "title": "Meant to Be"},
+					{"id": "6", "artist": "Imagine Dragons", "title": "Whatever It Takes"},
+					{"id": "7", "artist": "Maroon 5", "title": "Wait"}
+				],
+				"duration_ms": 0
+			}`))
+		})
+
+		It("should list the songs matching a search as a table", func() {
+			output := query("search-songs", "-m", "./test_assets/expected/input.json", "-artist", "the weeknd")
+			Expect(output).To(HavePrefix("ID  ARTIST      TITLE        ALBUM  YEAR  DURATION\n3   The Weeknd  Pray For Me"))
+		})
+
+		It("should list the playlists matching a selector", func() {
+			output := query("playlists", "-m", "./test_assets/expected/input.json", "-where", `songs < 3 and song.artist ~ "dua"`)
+			Expect(output).To(Equal("ID  OWNER  NAME  SONGS  VISIBILITY\n3   3            2      public\n"))
+		})
+
+		It("should reject -where on queries that do not list playlists", func() {
+			highspotCmd := exec.Command("go", "run", ".", "query", "search-songs", "-m", "./test_assets/expected/input.json", "-artist", "the weeknd", "-where", "songs < 3")
+			Expect(highspotCmd.Run()).ToNot(Succeed())
+		})
+
+		It("should fail for a user that is not in the mixtape", func() {
+			highspotCmd := exec.Command("go", "run", ".", "query", "user-playlists", "-m", "./test_assets/expected/input.json", "-u", "user_x")
+			Expect(highspotCmd.Run()).ToNot(Succeed())
+		})
+	})
+
+	Context("Recommending songs", func() {
+		It("should list the songs that share playlists with the playlist's songs", func() {
+			stdout := &bytes.Buffer{}
+			highspotCmd := exec.Command("go", "run", ".", "recommend", "-m", "./json/mixtape_original.json", "-p", "1", "-k", "1", "-similarity", "cooccurrence")
+			highspotCmd.Stdout = stdout
+			Expect(highspotCmd.Run()).To(Succeed())
+			Expect(stdout.String()).To(Equal("RANK  SONG_ID  ARTIST        TITLE        SCORE\n1     11       Shawn Mendes  In My Blood  1.000\n"))
+		})
+	})
+
+	Context("Computing stats", func() {
+		It("should write the metrics of a mixtape as JSON", func() {
+			stdout := &bytes.Buffer{}
+			highspotCmd := exec.Command("go", "run", ".", "stats", "-m", "./test_assets/expected/input.json", "-top", "1", "-format", "json")
+			highspotCmd.Stdout = stdout
+			Expect(highspotCmd.Run()).To(Succeed())
+
+			stats := &analytics.Stats{}
+			Expect(json.Unmarshal(stdout.Bytes(), stats)).To(Succeed())
+			Expect(stats.Playlists).To(Equal(3))
+			Expect(stats.SongsPerPlaylist.Max).To(Equal(3))
+			Expect(stats.TopSongs).To(Equal([]analytics.Count{{ID: "1", Playlists: 1}}))
+			Expect(stats.OrphanSongs).To(Equal([]string{"3", "4", "8"}))
+			Expect(stats.UsersWithoutPlaylists).To(BeEmpty())
+		})
 	})
 })
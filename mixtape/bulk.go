@@ -0,0 +1,86 @@
+package mixtape
+
+import (
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/selector"
+)
+
+// Reasons a bulk change is skipped
+const (
+	reasonWhereMissing     = "where missing"
+	reasonOperationMissing = "operation missing"
+	reasonOperationInvalid = "operation must change a single existing playlist"
+	reasonNoMatches        = "where matches no playlists"
+)
+
+// This method applies the change's operation to every playlist its where
+// selector matches, in the order of the playlist array. Playlists are
+// matched before any of them is changed, so an operation never changes
+// which playlists are matched, and each one is applied as its own change:
+// it is authorized, validated, logged, versioned and recorded as applied
+// on its own, with the actor, correlation id and source of the bulk
+// change. The operation can not be a change that spans playlists, see
+// SpansPlaylists. Their report entries are the targets of the bulk
+// change's entry, which is skipped if no operation was applied.
+
+// See tests in bulk_test.go for all invalid cases.
+
+// runtime: O(p*e + p*o), e is the cost of matching a playlist, see
+// selector.Selector.Match, and o the cost of the operation
+func (m *Mixtape) bulk(c *call, i int, change models.PlaylistChange) error {
+	if change.Where == "" {
+		c.skip(reasonWhereMissing)
+		return nil
+	}
+	s, err := selector.Parse(change.Where)
+	if err != nil {
+		c.skip(err.Error())
+		return nil
+	}
+	if change.Operation == nil {
+		c.skip(reasonOperationMissing)
+		return nil
+	}
+	// operations that read or change other playlists could change which
+	// playlists the next one would match
+	if id := change.Operation.ID; id == "" || id == models.Add || SpansPlaylists(*change.Operation) {
+		c.skip(reasonOperationInvalid)
+		return nil
+	}
+
+	ids := []string{}
+	for _, playlist := range m.mixtape.Playlists {
+		if s.Match(playlist, m) {
+			ids = append(ids, playlist.ID)
+		}
+	}
+	if len(ids) == 0 {
+		c.skip(reasonNoMatches)
+		return nil
+	}
+
+	applied := false
+	for _, id := range ids {
+		operation := *change.Operation
+		operation.Playlist.ID = id
+		operation.Actor = change.Actor
+		operation.CorrelationID = change.CorrelationID
+		operation.Source = change.Source
+		// these apply to the bulk change as a whole
+		operation.IdempotencyKey = ""
+		operation.IfMatch = nil
+
+		err = m.applyChange(i, operation)
+		entry := m.report[len(m.report)-1]
+		m.report = m.report[:len(m.report)-1]
+		c.entry.Targets = append(c.entry.Targets, entry)
+		applied = applied || entry.Status == models.Applied
+		if err != nil {
+			return err
+		}
+	}
+	if !applied {
+		c.skip(reasonNothingApplied)
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package mixtape_test
+
+import (
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/policy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Bulk Changes", func() {
+	var (
+		mixtape *models.Mixtape
+		logs    *gbytes.Buffer
+		m       *mixtape_pkg.Mixtape
+	)
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{
+				{ID: "user_1", Name: "test_user_1"},
+				{ID: "user_2", Name: "test_user_2"},
+			},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_1", "song_2"}},
+				{ID: "playlist_2", UserID: "user_2", SongIDs: []string{"song_1"}},
+				{ID: "playlist_3", UserID: "user_1", SongIDs: []string{"song_3"}},
+				{ID: "playlist_4", UserID: "user_2", SongIDs: []string{"song_2", "song_3"}},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1"},
+				{ID: "song_2", Artist: "some_other_artist", Title: "test_song_2"},
+				{ID: "song_3", Artist: "another_artist", Title: "test_song_3"},
+			},
+		}
+		logs = gbytes.NewBuffer()
+		m = mixtape_pkg.New(mixtape, newTestLogger(logs))
+	})
+
+	apply := func(changes ...models.PlaylistChange) {
+		Expect(m.ApplyChanges(&models.Changes{PlaylistChanges: changes})).To(Succeed())
+		Expect(m.Index()).To(Equal(mixtape_pkg.BuildIndex(mixtape)))
+	}
+
+	bulk := func(where string, operation *models.PlaylistChange) models.PlaylistChange {
+		return models.PlaylistChange{ID: models.Bulk, Where: where, Operation: operation, CorrelationID: "bulk_1"}
+	}
+
+	It("should apply the operation to every matching playlist as its own change", func() {
+		apply(bulk(`user_id = "user_1"`, &models.PlaylistChange{ID: models.AddSongs, Playlist: models.Playlist{SongIDs: []string{"song_3"}}}))
+
+		Expect(logs).To(gbytes.Say(`msg="added song" change=add_songs change_index=0 correlation_id=bulk_1 playlist_id=playlist_1 song_id=song_3`))
+		Expect(logs).To(gbytes.Say(`msg="skipped song" change=add_songs change_index=0 correlation_id=bulk_1 playlist_id=playlist_3 song_id=song_3 reason="song_id already in playlist"`))
+		Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"song_1", "song_2", "song_3"}))
+		Expect(mixtape.Playlists[0].Version).To(Equal(int64(1)))
+		Expect(mixtape.Version).To(Equal(int64(1)))
+		Expect(m.Applied()).To(Equal([]models.PlaylistChange{
+			{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_3"}}},
+		}))
+
+		entries := m.Report().Entries
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Change).To(Equal(models.Bulk))
+		Expect(entries[0].Status).To(Equal(models.Applied))
+		Expect(entries[0].Targets).To(HaveLen(2))
+		Expect(entries[0].Targets[0].PlaylistID).To(Equal("playlist_1"))
+		Expect(entries[0].Targets[0].Version).To(Equal(int64(1)))
+		Expect(entries[0].Targets[1].PlaylistID).To(Equal("playlist_3"))
+		Expect(entries[0].Targets[1].Reason).To(Equal("nothing to apply"))
+	})
+
+	It("should match every playlist before changing any", func() {
+		apply(bulk(`songs < 2 or song.id = "song_3"`, &models.PlaylistChange{ID: models.Remove}))
+
+		Expect(mixtape.Playlists).To(HaveLen(1))
+		Expect(mixtape.Playlists[0].ID).To(Equal("playlist_1"))
+		Expect(mixtape.Version).To(Equal(int64(3)))
+		Expect(m.Report().Entries[0].Targets).To(HaveLen(3))
+	})
+
+	It("should authorize the operation on each playlist for the bulk change's actor", func() {
+		m = mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithPolicy(&policy.Policy{}))
+		change := bulk(`songs = 1`, &models.PlaylistChange{ID: models.UpdatePlaylist, Actor: "user_2", Playlist: models.Playlist{Name: "Singles"}})
+		change.Actor = "user_1"
+		apply(change)
+
+		Expect(mixtape.Playlists[1].Name).To(BeEmpty())
+		Expect(mixtape.Playlists[2].Name).To(Equal("Singles"))
+		targets := m.Report().Entries[0].Targets
+		Expect(targets[0].Reason).To(Equal(policy.ErrNotOwner.Error()))
+		Expect(targets[1].Actor).To(Equal("user_1"))
+		Expect(targets[1].Status).To(Equal(models.Applied))
+	})
+
+	Context("when the bulk change is invalid", func() {
+		It("should not apply anything, output a log, and continue", func() {
+			addSongs := &models.PlaylistChange{ID: models.AddSongs, Playlist: models.Playlist{SongIDs: []string{"song_1"}}}
+			apply(
+				bulk("", addSongs),
+				bulk(`songs <`, addSongs),
+				bulk(`songs < 2`, nil),
+				bulk(`songs < 2`, &models.PlaylistChange{ID: models.Add}),
+				bulk(`songs < 2`, &models.PlaylistChange{ID: models.Bulk}),
+				bulk(`songs < 2`, &models.PlaylistChange{ID: models.AutoFill, Count: 1}),
+				bulk(`songs < 2`, &models.PlaylistChange{ID: models.ClonePlaylist, From: "playlist_1"}),
+				bulk(`songs < 2`, &models.PlaylistChange{ID: models.MergePlaylists, Sources: []string{"playlist_1"}}),
+				bulk(`songs < 2`, &models.PlaylistChange{ID: models.SplitPlaylist, Size: 1}),
+				bulk(`songs > 2`, addSongs),
+				bulk(`song.id = "song_1"`, addSongs),
+			)
+
+			Expect(logs).To(gbytes.Say(`change=bulk change_index=0 correlation_id=bulk_1 playlist_id="" reason="where missing"`))
+			Expect(logs).To(gbytes.Say(`reason="invalid selector: unexpected end of expression at 8"`))
+			Expect(logs).To(gbytes.Say(`reason="operation missing"`))
+			for i := 0; i < 6; i++ {
+				Expect(logs).To(gbytes.Say(`reason="operation must change a single existing playlist"`))
+			}
+			Expect(logs).To(gbytes.Say(`reason="where matches no playlists"`))
+			Expect(logs).To(gbytes.Say(`change=bulk change_index=10 correlation_id=bulk_1 playlist_id="" reason="nothing to apply"`))
+			for _, entry := range m.Report().Entries {
+				Expect(entry.Status).To(Equal(models.Skipped))
+			}
+			Expect(m.Report().Entries[10].Targets).To(HaveLen(2))
+			Expect(m.Applied()).To(BeEmpty())
+		})
+	})
+
+	It("should be applied sequentially in a parallel batch", func() {
+		other := mixtape_pkg.New(&models.Mixtape{
+			Users:     mixtape.Users,
+			Playlists: append([]models.Playlist{}, mixtape.Playlists...),
+			Songs:     mixtape.Songs,
+		}, newTestLogger(gbytes.NewBuffer()))
+		changes := &models.Changes{PlaylistChanges: []models.PlaylistChange{
+			{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_2", SongIDs: []string{"song_2"}}},
+			bulk(`songs = 2`, &models.PlaylistChange{ID: models.RemoveSongs, Playlist: models.Playlist{SongIDs: []string{"song_2"}}}),
+		}}
+		Expect(m.ApplyChanges(changes)).To(Succeed())
+		Expect(other.ApplyChangesParallel(changes, 4)).To(Succeed())
+
+		Expect(m.Report().Entries[1].Targets).To(HaveLen(3))
+		Expect(other.Report()).To(Equal(m.Report()))
+		Expect(other.Applied()).To(Equal(m.Applied()))
+	})
+})
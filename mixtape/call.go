@@ -0,0 +1,75 @@
+package mixtape
+
+import (
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/util"
+)
+
+// call is the context of applying a single change: a logger with the
+// change's fields and the change's report entry. Each change gets its own,
+// so nothing about the change in progress is kept on the shared Mixtape.
+type call struct {
+	logger util.Logger
+	entry  *models.ReportEntry
+	// skipped for a reason that can be fixed, see recordKey
+	retryable bool
+}
+
+func (m *Mixtape) newCall(i int, change models.PlaylistChange) *call {
+	entry := &models.ReportEntry{
+		ChangeIndex:   i,
+		CorrelationID: change.Ref(),
+		Source:        change.Source,
+		Change:        change.ID,
+		Actor:         change.Actor,
+		PlaylistID:    change.Playlist.ID,
+		Status:        models.Applied,
+	}
+
+	fields := []util.Field{
+		{Key: util.ChangeKey, Value: change.ID},
+		util.ChangeIndex(i),
+	}
+	if entry.CorrelationID != "" {
+		fields = append(fields, util.CorrelationID(entry.CorrelationID))
+	}
+	if change.Actor != "" {
+		fields = append(fields, util.Actor(change.Actor))
+	}
+	fields = append(fields, util.PlaylistID(change.Playlist.ID))
+
+	return &call{logger: m.logger.With(fields...), entry: entry}
+}
+
+// skip logs that the whole change was skipped, and why.
+func (c *call) skip(reason string, fields ...util.Field) {
+	c.entry.Status = models.Skipped
+	c.entry.Reason = reason
+	c.logger.Warn(msgSkippedChange, append(fields, util.Reason(reason))...)
+}
+
+// skipSong logs that a song was left out of the change, and why.
+func (c *call) skipSong(songID, reason string) {
+	c.entry.SkippedSongs = append(c.entry.SkippedSongs, models.SkippedSong{SongID: songID, Reason: reason})
+	c.logger.Warn(msgSkippedSong, util.SongID(songID), util.Reason(reason))
+}
+
+// skipPosition logs that a position was left out of the change, and why.
+func (c *call) skipPosition(position int, reason string) {
+	c.entry.SkippedPositions = append(c.entry.SkippedPositions, models.SkippedPosition{Position: position, Reason: reason})
+	c.logger.Warn(msgSkippedPosition, util.Position(position), util.Reason(reason))
+}
+
+// skipCollaborator logs that a collaborator was left out of the change,
+// and why.
+func (c *call) skipCollaborator(userID, reason string) {
+	c.entry.SkippedCollaborators = append(c.entry.SkippedCollaborators, models.SkippedCollaborator{UserID: userID, Reason: reason})
+	c.logger.Warn(msgSkippedCollaborator, util.UserID(userID), util.Reason(reason))
+}
+
+// skipPlaylist logs that a source playlist was left out of the change, and
+// why.
+func (c *call) skipPlaylist(playlistID, reason string) {
+	c.entry.SkippedPlaylists = append(c.entry.SkippedPlaylists, models.SkippedPlaylist{PlaylistID: playlistID, Reason: reason})
+	c.logger.Warn(msgSkippedPlaylist, util.SourceID(playlistID), util.Reason(reason))
+}
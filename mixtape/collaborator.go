@@ -0,0 +1,251 @@
+package mixtape
+
+import (
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/util"
+)
+
+// Reasons a collaborator change, or a collaborator in it, is skipped
+const (
+	reasonRoleInvalid         = "role must be editor or viewer"
+	reasonUserOwnsPlaylist    = "user_id already owns the playlist"
+	reasonAlreadyCollaborator = "user_id already collaborator with role"
+	reasonNotCollaborator     = "user_id not a collaborator"
+)
+
+const (
+	msgSkippedCollaborator = "skipped collaborator"
+	msgAddedCollaborator   = "added collaborator"
+	msgRemovedCollaborator = "removed collaborator"
+	msgTransferredPlaylist = "transferred playlist"
+)
+
+// This method adds collaborators to an existing playlist, or changes the
+// role of existing ones. Collaborators are appended in the order given.
+// A collaborator is skipped if the user does not exist, already owns the
+// playlist or already has that role, or the role is not editor or viewer.
+
+// runtime: O(c*pc), c is the number of collaborators in the change and pc
+// the number of collaborators on the playlist, which is expected to be small
+func (m *Mixtape) addCollaborators(c *call, playlist models.Playlist) error {
+	i, ok := m.existingPlaylist(c, playlist)
+	if !ok {
+		return nil
+	}
+	existing := &m.mixtape.Playlists[i]
+
+	added := []models.Collaborator{}
+	for _, collaborator := range playlist.Collaborators {
+		userID := collaborator.UserID
+		if !m.validCollaborator(c, existing.UserID, collaborator) {
+			continue
+		}
+		j := findCollaborator(existing.Collaborators, userID)
+		if j >= 0 && existing.Collaborators[j].Role == collaborator.Role {
+			c.skipCollaborator(userID, reasonAlreadyCollaborator)
+			continue
+		}
+		m.setCollaborator(i, collaborator)
+		added = append(added, collaborator)
+		c.logger.Info(msgAddedCollaborator, util.UserID(userID), util.Role(string(collaborator.Role)))
+	}
+
+	if len(added) > 0 {
+		m.touch(i)
+		m.applied = append(m.applied, models.PlaylistChange{
+			ID:       models.AddCollaborators,
+			Playlist: models.Playlist{ID: existing.ID, Collaborators: added},
+		})
+	}
+	return nil
+}
+
+// This method removes collaborators from an existing playlist, whatever
+// their role. Roles in the change are ignored.
+
+// runtime: O(c*pc), see addCollaborators
+func (m *Mixtape) removeCollaborators(c *call, playlist models.Playlist) error {
+	i, ok := m.existingPlaylist(c, playlist)
+	if !ok {
+		return nil
+	}
+	existing := &m.mixtape.Playlists[i]
+
+	removed := []models.Collaborator{}
+	for _, collaborator := range playlist.Collaborators {
+		userID := collaborator.UserID
+		if findCollaborator(existing.Collaborators, userID) < 0 {
+			c.skipCollaborator(userID, reasonNotCollaborator)
+			continue
+		}
+		m.deleteCollaborator(i, userID)
+		removed = append(removed, models.Collaborator{UserID: userID})
+		c.logger.Info(msgRemovedCollaborator, util.UserID(userID))
+	}
+
+	if len(removed) > 0 {
+		m.touch(i)
+		m.applied = append(m.applied, models.PlaylistChange{
+			ID:       models.RemoveCollaborators,
+			Playlist: models.Playlist{ID: existing.ID, Collaborators: removed},
+		})
+	}
+	return nil
+}
+
+// This method makes another existing user the owner of a playlist. The new
+// owner stops being a collaborator, and the previous owner becomes an
+// editor so they do not lose access to their playlist.
+
+// runtime: O(pc), see addCollaborators
+func (m *Mixtape) transferOwnership(c *call, playlist models.Playlist) error {
+	i, ok := m.existingPlaylist(c, playlist)
+	if !ok {
+		return nil
+	}
+	existing := &m.mixtape.Playlists[i]
+
+	userID := playlist.UserID
+	if userID == "" {
+		c.skip(reasonUserIDMissing)
+		return nil
+	}
+	if _, exist := m.lookup.users[userID]; !exist {
+		c.skip(reasonUserNotInMixtape, util.UserID(userID))
+		return nil
+	}
+	if userID == existing.UserID {
+		c.skip(reasonUserOwnsPlaylist, util.UserID(userID))
+		return nil
+	}
+
+	m.transfer(i, userID)
+	m.touch(i)
+	m.applied = append(m.applied, models.PlaylistChange{
+		ID:       models.TransferOwnership,
+		Playlist: models.Playlist{ID: existing.ID, UserID: userID},
+	})
+	c.logger.Info(msgTransferredPlaylist, util.UserID(userID))
+	return nil
+}
+
+// validCollaborator skips a collaborator that is not an existing user
+// with a valid role, besides the owner.
+func (m *Mixtape) validCollaborator(c *call, ownerID string, collaborator models.Collaborator) bool {
+	userID := collaborator.UserID
+	if _, exist := m.lookup.users[userID]; !exist {
+		c.skipCollaborator(userID, reasonUserNotInMixtape)
+		return false
+	}
+	if collaborator.Role != models.Editor && collaborator.Role != models.Viewer {
+		c.skipCollaborator(userID, reasonRoleInvalid)
+		return false
+	}
+	if userID == ownerID {
+		c.skipCollaborator(userID, reasonUserOwnsPlaylist)
+		return false
+	}
+	return true
+}
+
+// existingPlaylist returns the index of the change's playlist, or skips
+// the change if there is none.
+func (m *Mixtape) existingPlaylist(c *call, playlist models.Playlist) (int, bool) {
+	if playlist.ID == "" {
+		c.skip(reasonPlaylistIDMissing)
+		return 0, false
+	}
+	i, exist := m.lookup.playlists[playlist.ID]
+	if !exist {
+		c.skip(reasonPlaylistNotFound)
+		return 0, false
+	}
+	return i, true
+}
+
+// PlaylistsEditableBy returns the ids of the playlists a user owns or is an
+// editor of, sorted.
+// runtime: O(r log r), r is the number of playlists returned
+func (m *Mixtape) PlaylistsEditableBy(userID string) []string {
+	return sortedKeys(m.lookup.editable[userID])
+}
+
+func findCollaborator(collaborators []models.Collaborator, userID string) int {
+	for j, collaborator := range collaborators {
+		if collaborator.UserID == userID {
+			return j
+		}
+	}
+	return -1
+}
+
+// The methods below change the collaborators of the playlist at index i
+// and keep the editable lookup hash map consistent with them.
+
+// setCollaborator adds a collaborator, or changes their role.
+// runtime: O(pc)
+func (m *Mixtape) setCollaborator(i int, collaborator models.Collaborator) {
+	playlist := &m.mixtape.Playlists[i]
+	j := findCollaborator(playlist.Collaborators, collaborator.UserID)
+	if j >= 0 {
+		playlist.Collaborators[j].Role = collaborator.Role
+	} else {
+		playlist.Collaborators = append(playlist.Collaborators, collaborator)
+	}
+
+	if collaborator.Role == models.Editor {
+		m.grant(collaborator.UserID, playlist.ID)
+	} else {
+		m.revoke(collaborator.UserID, playlist.ID)
+	}
+}
+
+// transfer makes userID the owner, and the previous owner an editor.
+// runtime: O(pc)
+func (m *Mixtape) transfer(i int, userID string) {
+	playlist := &m.mixtape.Playlists[i]
+	previous := playlist.UserID
+	if findCollaborator(playlist.Collaborators, userID) >= 0 {
+		m.deleteCollaborator(i, userID)
+	}
+	m.revoke(previous, playlist.ID)
+	removeFrom(m.lookup.owned, previous, playlist.ID)
+	playlist.UserID = userID
+	m.grant(userID, playlist.ID)
+	addTo(m.lookup.owned, userID, playlist.ID)
+	m.setCollaborator(i, models.Collaborator{UserID: previous, Role: models.Editor})
+}
+
+// deleteCollaborator keeps the order of the remaining collaborators.
+// runtime: O(pc)
+func (m *Mixtape) deleteCollaborator(i int, userID string) {
+	playlist := &m.mixtape.Playlists[i]
+	j := findCollaborator(playlist.Collaborators, userID)
+	playlist.Collaborators = append(playlist.Collaborators[:j], playlist.Collaborators[j+1:]...)
+	m.revoke(userID, playlist.ID)
+}
+
+// grantEditors indexes the owner and editors of a playlist.
+func (m *Mixtape) grantEditors(playlist models.Playlist) {
+	m.grant(playlist.UserID, playlist.ID)
+	for _, collaborator := range playlist.Collaborators {
+		if collaborator.Role == models.Editor {
+			m.grant(collaborator.UserID, playlist.ID)
+		}
+	}
+}
+
+func (m *Mixtape) revokeEditors(playlist models.Playlist) {
+	m.revoke(playlist.UserID, playlist.ID)
+	for _, collaborator := range playlist.Collaborators {
+		m.revoke(collaborator.UserID, playlist.ID)
+	}
+}
+
+func (m *Mixtape) grant(userID, playlistID string) {
+	addTo(m.lookup.editable, userID, playlistID)
+}
+
+func (m *Mixtape) revoke(userID, playlistID string) {
+	removeFrom(m.lookup.editable, userID, playlistID)
+}
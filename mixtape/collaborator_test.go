@@ -0,0 +1,165 @@
+package mixtape_test
+
+import (
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Collaborators", func() {
+	var (
+		mixtape *models.Mixtape
+		changes *models.Changes
+		logs    *gbytes.Buffer
+		m       *mixtape_pkg.Mixtape
+
+		applyChangesErr error
+	)
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{
+				{ID: "user_1", Name: "test_user_1"},
+				{ID: "user_2", Name: "test_user_2"},
+				{ID: "user_3", Name: "test_user_3"},
+			},
+			Playlists: []models.Playlist{
+				{
+					ID:            "playlist_1",
+					UserID:        "user_1",
+					SongIDs:       []string{"song_1"},
+					Collaborators: []models.Collaborator{{UserID: "user_2", Role: models.Viewer}},
+				},
+				{ID: "playlist_2", UserID: "user_2", SongIDs: []string{"song_1"}},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1"},
+			},
+		}
+		changes = &models.Changes{}
+		logs = gbytes.NewBuffer()
+		m = mixtape_pkg.New(mixtape, newTestLogger(logs))
+	})
+
+	JustBeforeEach(func() {
+		applyChangesErr = m.ApplyChanges(changes)
+	})
+
+	It("should index the playlists users own or edit", func() {
+		Expect(m.PlaylistsEditableBy("user_1")).To(Equal([]string{"playlist_1"}))
+		Expect(m.PlaylistsEditableBy("user_2")).To(Equal([]string{"playlist_2"}))
+		Expect(m.PlaylistsEditableBy("user_3")).To(BeEmpty())
+	})
+
+	Describe("addCollaborators", func() {
+		BeforeEach(func() {
+			changes.PlaylistChanges = []models.PlaylistChange{
+				{
+					ID: models.AddCollaborators,
+					Playlist: models.Playlist{ID: "playlist_1", Collaborators: []models.Collaborator{
+						{UserID: "user_3", Role: models.Editor},
+						{UserID: "user_2", Role: models.Editor},
+						{UserID: "user_1", Role: models.Editor},
+						{UserID: "user_x", Role: models.Editor},
+						{UserID: "user_3", Role: "owner"},
+						{UserID: "user_3", Role: models.Editor},
+					}},
+				},
+			}
+		})
+
+		It("should add valid collaborators, change roles, and skip the rest", func() {
+			Expect(applyChangesErr).ToNot(HaveOccurred())
+			Expect(mixtape.Playlists[0].Collaborators).To(Equal([]models.Collaborator{
+				{UserID: "user_2", Role: models.Editor},
+				{UserID: "user_3", Role: models.Editor},
+			}))
+			Expect(mixtape.Playlists[0].Version).To(Equal(int64(1)))
+			Expect(m.PlaylistsEditableBy("user_2")).To(Equal([]string{"playlist_1", "playlist_2"}))
+			Expect(m.PlaylistsEditableBy("user_3")).To(Equal([]string{"playlist_1"}))
+
+			Expect(m.Report().Entries[0].SkippedCollaborators).To(Equal([]models.SkippedCollaborator{
+				{UserID: "user_1", Reason: "user_id already owns the playlist"},
+				{UserID: "user_x", Reason: "user_id not in mixtape"},
+				{UserID: "user_3", Reason: "role must be editor or viewer"},
+				{UserID: "user_3", Reason: "user_id already collaborator with role"},
+			}))
+			Expect(logs).To(gbytes.Say(`msg="added collaborator" .* playlist_id=playlist_1 user_id=user_3 role=editor`))
+			Expect(logs).To(gbytes.Say(`msg="skipped collaborator" .* playlist_id=playlist_1 user_id=user_1 reason="user_id already owns the playlist"`))
+		})
+	})
+
+	Describe("removeCollaborators", func() {
+		BeforeEach(func() {
+			changes.PlaylistChanges = []models.PlaylistChange{
+				{
+					ID: models.RemoveCollaborators,
+					Playlist: models.Playlist{ID: "playlist_1", Collaborators: []models.Collaborator{
+						{UserID: "user_3"},
+						{UserID: "user_2"},
+					}},
+				},
+			}
+		})
+
+		It("should remove collaborators and skip users that are not one", func() {
+			Expect(applyChangesErr).ToNot(HaveOccurred())
+			Expect(mixtape.Playlists[0].Collaborators).To(BeEmpty())
+			Expect(m.Applied()).To(Equal([]models.PlaylistChange{
+				{ID: models.RemoveCollaborators, Playlist: models.Playlist{ID: "playlist_1", Collaborators: []models.Collaborator{{UserID: "user_2"}}}},
+			}))
+			Expect(logs).To(gbytes.Say(`playlist_id=playlist_1 user_id=user_3 reason="user_id not a collaborator"`))
+		})
+	})
+
+	Describe("transferOwnership", func() {
+		BeforeEach(func() {
+			changes.PlaylistChanges = []models.PlaylistChange{
+				{ID: models.TransferOwnership, Playlist: models.Playlist{ID: "playlist_1", UserID: "user_1"}},
+				{ID: models.TransferOwnership, Playlist: models.Playlist{ID: "playlist_1", UserID: "user_x"}},
+				{ID: models.TransferOwnership, Playlist: models.Playlist{ID: "playlist_x", UserID: "user_2"}},
+				{ID: models.TransferOwnership, Playlist: models.Playlist{ID: "playlist_1", UserID: "user_2"}},
+			}
+		})
+
+		It("should make the previous owner an editor", func() {
+			Expect(applyChangesErr).ToNot(HaveOccurred())
+			Expect(mixtape.Playlists[0].UserID).To(Equal("user_2"))
+			Expect(mixtape.Playlists[0].Collaborators).To(Equal([]models.Collaborator{{UserID: "user_1", Role: models.Editor}}))
+			Expect(m.PlaylistsEditableBy("user_1")).To(Equal([]string{"playlist_1"}))
+			Expect(m.PlaylistsEditableBy("user_2")).To(Equal([]string{"playlist_1", "playlist_2"}))
+
+			reasons := []string{}
+			for _, entry := range m.Report().Entries {
+				reasons = append(reasons, entry.Reason)
+			}
+			Expect(reasons).To(Equal([]string{"user_id already owns the playlist", "user_id not in mixtape", "playlist_id not found", ""}))
+		})
+	})
+
+	Describe("removing and adding playlists", func() {
+		BeforeEach(func() {
+			changes.PlaylistChanges = []models.PlaylistChange{
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_2"}},
+				{
+					ID: models.Add,
+					Playlist: models.Playlist{ID: "playlist_3", UserID: "user_3", SongIDs: []string{"song_1"}, Collaborators: []models.Collaborator{
+						{UserID: "user_2", Role: models.Editor},
+						{UserID: "user_2", Role: models.Viewer},
+						{UserID: "user_1", Role: "admin"},
+					}},
+				},
+			}
+		})
+
+		It("should keep the index up to date and leave out invalid collaborators", func() {
+			Expect(applyChangesErr).ToNot(HaveOccurred())
+			Expect(mixtape.Playlists[1].Collaborators).To(Equal([]models.Collaborator{{UserID: "user_2", Role: models.Editor}}))
+			Expect(m.PlaylistsEditableBy("user_2")).To(Equal([]string{"playlist_3"}))
+			Expect(m.PlaylistsEditableBy("user_3")).To(Equal([]string{"playlist_3"}))
+			Expect(m.Index()).To(Equal(mixtape_pkg.BuildIndex(mixtape)))
+		})
+	})
+})
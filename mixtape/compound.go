@@ -0,0 +1,289 @@
+package mixtape
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/selector"
+	"github.com/n4wei/highspot/util"
+)
+
+// These methods change several playlists at once. They are built on the
+// primitives in playlist.go, and are recorded as applied as the adds,
+// removes, add_songs and remove_songs they are made of, so they replay, and
+// commit, like any other change.
+
+// Reasons a clone_playlist, merge_playlists or split_playlist change, or a
+// source playlist in it, is skipped
+const (
+	reasonFromMissing     = "from missing"
+	reasonFromNotFound    = "from not found"
+	reasonSourcesMissing  = "sources missing"
+	reasonSourceIsTarget  = "source is the target playlist"
+	reasonSourceRepeated  = "source already merged"
+	reasonSourceNotMerged = "source has songs that were not merged"
+	reasonNoSources       = "no sources to merge"
+	reasonSplitInvalid    = "split by a size above 0 or by where, not both"
+	reasonNothingToSplit  = "no songs to move"
+	reasonSplitAll        = "where matches every song"
+)
+
+const (
+	msgSkippedPlaylist = "skipped playlist"
+	msgMovedSongs      = "moved songs"
+)
+
+// This method copies an existing playlist, From, to a new one with the id
+// in the change, for the user in the change or else the same owner. The
+// name, description, visibility and songs are copied, and the name can be
+// replaced in the change. Collaborators are not, they were invited to the
+// original. The copy is validated, logged and recorded as applied as an
+// add, so it must fit within the limits, and under a policy the actor must
+// be the new owner, and the owner or an editor of From if it is private,
+// see Mixtape.authorize.
+
+// See tests in compound_test.go for all invalid cases.
+
+// runtime: O(s), s is the number of songs in the playlist
+func (m *Mixtape) clonePlaylist(c *call, change models.PlaylistChange) error {
+	if change.From == "" {
+		c.skip(reasonFromMissing)
+		return nil
+	}
+	j, exist := m.lookup.playlists[change.From]
+	if !exist {
+		c.skip(reasonFromNotFound, util.SourceID(change.From))
+		return nil
+	}
+
+	source := m.mixtape.Playlists[j]
+	clone := models.Playlist{
+		ID:              change.Playlist.ID,
+		UserID:          change.Playlist.UserID,
+		Name:            source.Name,
+		Description:     source.Description,
+		Visibility:      source.Visibility,
+		SongIDs:         append([]string{}, source.SongIDs...),
+		AllowDuplicates: source.AllowDuplicates,
+	}
+	if clone.UserID == "" {
+		clone.UserID = source.UserID
+	}
+	if change.Playlist.Name != "" {
+		clone.Name = change.Playlist.Name
+	}
+	if err := m.authorize(models.PlaylistChange{ID: models.Add, Actor: change.Actor, Playlist: clone}); err != nil {
+		c.skip(err.Error())
+		return nil
+	}
+	return m.addPlaylist(c, clone)
+}
+
+// This method appends the songs of the Sources playlists to an existing
+// playlist, in order, leaving out songs that are already in it or were
+// appended from an earlier source, whether or not it allows duplicates.
+// Songs are added as with add_songs, so songs that would take the playlist
+// over a limit are skipped. With DeleteSources, each source is then
+// removed, unless some of its songs did not make it into the playlist or,
+// under a policy, the actor may not remove it. Sources that do not exist,
+// are given twice or, under a policy, are private and the actor may not
+// read, are skipped.
+
+// See tests in compound_test.go for all invalid cases.
+
+// runtime: O(s), s is the number of songs in the sources
+func (m *Mixtape) mergePlaylists(c *call, change models.PlaylistChange) error {
+	i, ok := m.existingPlaylist(c, change.Playlist)
+	if !ok {
+		return nil
+	}
+	if len(change.Sources) == 0 {
+		c.skip(reasonSourcesMissing)
+		return nil
+	}
+
+	id := m.mixtape.Playlists[i].ID
+	seen := map[string]bool{}
+	for songID := range m.lookup.playlistSongs[id] {
+		seen[songID] = true
+	}
+	sources := []string{}
+	merged := map[string]bool{}
+	songIDs := []string{}
+	for _, sourceID := range change.Sources {
+		j, exist := m.lookup.playlists[sourceID]
+		switch {
+		case sourceID == id:
+			c.skipPlaylist(sourceID, reasonSourceIsTarget)
+		case merged[sourceID]:
+			c.skipPlaylist(sourceID, reasonSourceRepeated)
+		case !exist:
+			c.skipPlaylist(sourceID, reasonPlaylistNotFound)
+		default:
+			if err := m.authorizeRead(change.Actor, &m.mixtape.Playlists[j]); err != nil {
+				c.skipPlaylist(sourceID, err.Error())
+				continue
+			}
+			merged[sourceID] = true
+			sources = append(sources, sourceID)
+			for _, songID := range m.mixtape.Playlists[j].SongIDs {
+				if !seen[songID] {
+					seen[songID] = true
+					songIDs = append(songIDs, songID)
+				}
+			}
+		}
+	}
+	if len(sources) == 0 {
+		c.skip(reasonNoSources)
+		return nil
+	}
+
+	err := m.addSongsToPlaylist(c, models.Playlist{ID: id, SongIDs: songIDs})
+	if err != nil || !change.DeleteSources {
+		return err
+	}
+	for _, sourceID := range sources {
+		if !m.contains(id, m.mixtape.Playlists[m.lookup.playlists[sourceID]].SongIDs) {
+			c.skipPlaylist(sourceID, reasonSourceNotMerged)
+			continue
+		}
+		if err := m.authorize(models.PlaylistChange{ID: models.Remove, Actor: change.Actor, Playlist: models.Playlist{ID: sourceID}}); err != nil {
+			c.skipPlaylist(sourceID, err.Error())
+			continue
+		}
+		m.deletePlaylist(m.lookup.playlists[sourceID])
+		m.applied = append(m.applied, models.PlaylistChange{ID: models.Remove, Playlist: models.Playlist{ID: sourceID}})
+		c.logger.Info(msgRemovedPlaylist, util.SourceID(sourceID))
+	}
+	return nil
+}
+
+// contains tells whether every one of the songs is in the playlist.
+// runtime: O(s), s is the number of songs
+func (m *Mixtape) contains(playlistID string, songIDs []string) bool {
+	for _, songID := range songIDs {
+		if m.lookup.playlistSongs[playlistID][songID] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// This method moves songs out of an existing playlist into new ones,
+// owned by the same user, with the same name, description and visibility.
+// By Size, the playlist keeps its first Size songs, and every next Size
+// songs go to a new playlist. By Where, the songs that the selector
+// matches, each as a playlist of just that song, eg. `song.artist =
+// "Drake"`, go to one new playlist, unless it matches them all. The new
+// playlists get the ids in Targets, in order, or the playlist's id
+// followed by -2, -3 and so on for those missing. The change is skipped
+// if any of them exists, or is given twice.
+// Songs keep their order, and since they are only moved, no limit is
+// checked. It is recorded as applied as the remove_songs of the moved
+// positions, and then the add of each new playlist.
+
+// See tests in compound_test.go for all invalid cases.
+
+// runtime: O(s*e), s is the number of songs in the playlist and e the cost
+// of matching a song, 1 when splitting by size
+func (m *Mixtape) splitPlaylist(c *call, change models.PlaylistChange) error {
+	i, ok := m.existingPlaylist(c, change.Playlist)
+	if !ok {
+		return nil
+	}
+	if change.Size < 0 || (change.Size > 0) == (change.Where != "") {
+		c.skip(reasonSplitInvalid)
+		return nil
+	}
+
+	source := m.mixtape.Playlists[i]
+	// indices into the songs of the playlist, of each new playlist
+	parts := [][]int{}
+	if change.Size > 0 {
+		for j := change.Size; j < len(source.SongIDs); j += change.Size {
+			part := []int{}
+			for k := j; k < j+change.Size && k < len(source.SongIDs); k++ {
+				part = append(part, k)
+			}
+			parts = append(parts, part)
+		}
+	} else {
+		s, err := selector.Parse(change.Where)
+		if err != nil {
+			c.skip(err.Error())
+			return nil
+		}
+		part := []int{}
+		for j, songID := range source.SongIDs {
+			song := source
+			song.SongIDs = []string{songID}
+			if s.Match(song, m) {
+				part = append(part, j)
+			}
+		}
+		// the playlist must keep at least one song
+		if len(part) > 0 && len(part) == len(source.SongIDs) {
+			c.skip(reasonSplitAll)
+			return nil
+		}
+		if len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	if len(parts) == 0 {
+		c.skip(reasonNothingToSplit)
+		return nil
+	}
+
+	ids := []string{}
+	seen := map[string]bool{}
+	for k := range parts {
+		id := fmt.Sprintf("%s-%d", source.ID, k+2)
+		if k < len(change.Targets) && change.Targets[k] != "" {
+			id = change.Targets[k]
+		}
+		if _, exist := m.lookup.playlists[id]; exist || seen[id] {
+			c.skip(reasonPlaylistExists, util.TargetID(id))
+			return nil
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	removed := map[int]bool{}
+	playlists := []models.Playlist{}
+	for k, part := range parts {
+		playlist := models.Playlist{
+			ID:              ids[k],
+			UserID:          source.UserID,
+			Name:            source.Name,
+			Description:     source.Description,
+			Visibility:      source.Visibility,
+			SongIDs:         []string{},
+			AllowDuplicates: source.AllowDuplicates,
+		}
+		for _, j := range part {
+			playlist.SongIDs = append(playlist.SongIDs, source.SongIDs[j])
+			removed[j] = true
+		}
+		playlists = append(playlists, playlist)
+	}
+	positions := []int{}
+	for j := range removed {
+		positions = append(positions, j+1)
+	}
+	sort.Ints(positions)
+
+	m.removeAt(i, removed)
+	m.touch(i)
+	m.applied = append(m.applied, models.PlaylistChange{ID: models.RemoveSongs, Mode: models.RemoveAt, Playlist: models.Playlist{ID: source.ID}, Positions: positions})
+	for _, playlist := range playlists {
+		m.applied = append(m.applied, models.PlaylistChange{ID: models.Add, Playlist: copyPlaylist(playlist)})
+		m.stamp(&playlist)
+		m.insertPlaylist(playlist)
+		c.logger.Info(msgMovedSongs, util.TargetID(playlist.ID))
+	}
+	return nil
+}
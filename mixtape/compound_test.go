@@ -0,0 +1,289 @@
+package mixtape_test
+
+import (
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/policy"
+	"github.com/n4wei/highspot/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Clone, Merge and Split", func() {
+	var (
+		mixtape *models.Mixtape
+		logs    *gbytes.Buffer
+		m       *mixtape_pkg.Mixtape
+	)
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{
+				{ID: "user_1", Name: "test_user_1"},
+				{ID: "user_2", Name: "test_user_2"},
+			},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", Name: "Mix", Visibility: models.Private, SongIDs: []string{"song_1", "song_2"},
+					Collaborators: []models.Collaborator{{UserID: "user_2", Role: models.Editor}}},
+				{ID: "playlist_2", UserID: "user_1", SongIDs: []string{"song_2", "song_3"}},
+				{ID: "playlist_3", UserID: "user_2", SongIDs: []string{"song_3", "song_4", "song_1"}},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1"},
+				{ID: "song_2", Artist: "some_other_artist", Title: "test_song_2"},
+				{ID: "song_3", Artist: "another_artist", Title: "test_song_3"},
+				{ID: "song_4", Artist: "another_artist", Title: "test_song_4"},
+			},
+		}
+		logs = gbytes.NewBuffer()
+		m = mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithClock(testClock))
+	})
+
+	apply := func(changes ...models.PlaylistChange) {
+		Expect(m.ApplyChanges(&models.Changes{PlaylistChanges: changes})).To(Succeed())
+		Expect(m.Index()).To(Equal(mixtape_pkg.BuildIndex(mixtape)))
+	}
+
+	playlist := func(id string) models.Playlist {
+		p, exist := m.Playlist(id)
+		Expect(exist).To(BeTrue(), id)
+		return p
+	}
+
+	clone := func(from, id, userID string) models.PlaylistChange {
+		return models.PlaylistChange{ID: models.ClonePlaylist, From: from, Playlist: models.Playlist{ID: id, UserID: userID}}
+	}
+
+	merge := func(id string, deleteSources bool, sources ...string) models.PlaylistChange {
+		return models.PlaylistChange{ID: models.MergePlaylists, Playlist: models.Playlist{ID: id}, Sources: sources, DeleteSources: deleteSources}
+	}
+
+	split := func(id string, size int, where string, targets ...string) models.PlaylistChange {
+		return models.PlaylistChange{ID: models.SplitPlaylist, Playlist: models.Playlist{ID: id}, Size: size, Where: where, Targets: targets}
+	}
+
+	It("should record every change as the primitives it is made of, which replay to the same playlists", func() {
+		changes := []models.PlaylistChange{
+			clone("playlist_1", "playlist_4", "user_2"),
+			merge("playlist_2", true, "playlist_3"),
+			split("playlist_2", 2, "", "playlist_5"),
+		}
+		replayed := copyMixtape(mixtape)
+		apply(changes...)
+
+		r := mixtape_pkg.New(replayed, util.Discard, mixtape_pkg.WithClock(testClock))
+		Expect(r.ApplyChanges(&models.Changes{PlaylistChanges: m.Applied()})).To(Succeed())
+		Expect(replayed.Playlists).To(HaveLen(len(mixtape.Playlists)))
+		for _, p := range mixtape.Playlists {
+			other, exist := r.Playlist(p.ID)
+			Expect(exist).To(BeTrue(), p.ID)
+			Expect(other.SongIDs).To(Equal(p.SongIDs), p.ID)
+			Expect(other.UserID).To(Equal(p.UserID), p.ID)
+		}
+		for _, change := range m.Applied() {
+			Expect(change.ID).To(BeElementOf(models.Add, models.Remove, models.AddSongs, models.RemoveSongs))
+		}
+	})
+
+	Describe("clone_playlist", func() {
+		It("should copy the playlist, but not its collaborators, to a new playlist for another user", func() {
+			apply(clone("playlist_1", "playlist_4", "user_2"))
+
+			Expect(logs).To(gbytes.Say(`msg="added playlist" change=clone_playlist change_index=0 playlist_id=playlist_4`))
+			Expect(playlist("playlist_4")).To(Equal(models.Playlist{
+				ID: "playlist_4", UserID: "user_2", Name: "Mix", Visibility: models.Private, SongIDs: []string{"song_1", "song_2"},
+				Version: 1, CreatedAt: testClock(), UpdatedAt: testClock(),
+			}))
+			Expect(m.PlaylistsOwnedBy("user_2")).To(Equal([]string{"playlist_3", "playlist_4"}))
+			Expect(m.Applied()[0].ID).To(Equal(models.Add))
+		})
+
+		It("should keep the owner unless one is given, and replace the name if one is given", func() {
+			change := clone("playlist_2", "playlist_4", "")
+			change.Playlist.Name = "Copy"
+			apply(change)
+
+			Expect(playlist("playlist_4").UserID).To(Equal("user_1"))
+			Expect(playlist("playlist_4").Name).To(Equal("Copy"))
+		})
+
+		Context("when the change is invalid", func() {
+			It("should not add a playlist, output a log, and continue", func() {
+				apply(
+					clone("", "playlist_4", ""),
+					clone("playlist_x", "playlist_4", ""),
+					clone("playlist_1", "playlist_2", ""),
+					clone("playlist_1", "playlist_4", "user_x"),
+				)
+
+				Expect(logs).To(gbytes.Say(`reason="from missing"`))
+				Expect(logs).To(gbytes.Say(`playlist_id=playlist_4 source_id=playlist_x reason="from not found"`))
+				Expect(logs).To(gbytes.Say(`reason="playlist_id already exists"`))
+				Expect(logs).To(gbytes.Say(`reason="user_id not in mixtape"`))
+				Expect(mixtape.Playlists).To(HaveLen(3))
+			})
+		})
+
+		Context("with a policy", func() {
+			It("should only let the actor clone a playlist for themselves", func() {
+				m = mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithPolicy(&policy.Policy{}))
+				change := clone("playlist_1", "playlist_4", "user_2")
+				change.Actor = "user_1"
+				apply(change)
+
+				Expect(m.Report().Entries[0].Reason).To(Equal(policy.ErrNotOwner.Error()))
+			})
+
+			It("should only let the owner and editors of a private playlist clone it", func() {
+				mixtape.Playlists[2].Visibility = models.Private
+				m = mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithPolicy(&policy.Policy{}))
+				editor := clone("playlist_1", "playlist_4", "user_2")
+				editor.Actor = "user_2"
+				stranger := clone("playlist_3", "playlist_5", "user_1")
+				stranger.Actor = "user_1"
+				apply(editor, stranger)
+
+				Expect(m.Report().Entries[0].Status).To(Equal(models.Applied))
+				Expect(m.Report().Entries[1].Reason).To(Equal(policy.ErrNotCollaborator.Error()))
+				Expect(mixtape.Playlists).To(HaveLen(4))
+			})
+		})
+	})
+
+	Describe("merge_playlists", func() {
+		It("should append the sources' songs in order, once each, leaving out songs already in the playlist", func() {
+			apply(merge("playlist_1", false, "playlist_3", "playlist_2"))
+
+			Expect(playlist("playlist_1").SongIDs).To(Equal([]string{"song_1", "song_2", "song_3", "song_4"}))
+			Expect(playlist("playlist_1").Version).To(Equal(int64(1)))
+			Expect(mixtape.Playlists).To(HaveLen(3))
+			Expect(m.Applied()).To(Equal([]models.PlaylistChange{
+				{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_3", "song_4"}}},
+			}))
+		})
+
+		It("should delete the sources once merged", func() {
+			apply(merge("playlist_1", true, "playlist_2", "playlist_3"))
+
+			Expect(logs).To(gbytes.Say(`msg="removed playlist" change=merge_playlists change_index=0 playlist_id=playlist_1 source_id=playlist_2`))
+			Expect(mixtape.Playlists).To(HaveLen(1))
+			Expect(m.PlaylistsWithSong("song_4")).To(Equal([]string{"playlist_1"}))
+			Expect(m.Applied()[1:]).To(Equal([]models.PlaylistChange{
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_2"}},
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_3"}},
+			}))
+		})
+
+		It("should keep sources with songs that did not fit within the limits", func() {
+			m = mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithLimits(mixtape_pkg.Limits{MaxSongs: 3}))
+			apply(merge("playlist_1", true, "playlist_2", "playlist_3"))
+
+			Expect(playlist("playlist_1").SongIDs).To(Equal([]string{"song_1", "song_2", "song_3"}))
+			entry := m.Report().Entries[0]
+			Expect(entry.SkippedSongs).To(Equal([]models.SkippedSong{{SongID: "song_4", Reason: "playlist would exceed max songs"}}))
+			Expect(entry.SkippedPlaylists).To(Equal([]models.SkippedPlaylist{{PlaylistID: "playlist_3", Reason: "source has songs that were not merged"}}))
+			_, exist := m.Playlist("playlist_2")
+			Expect(exist).To(BeFalse())
+		})
+
+		It("should only delete the sources the actor may remove, under a policy", func() {
+			m = mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithPolicy(&policy.Policy{}))
+			change := merge("playlist_1", true, "playlist_2", "playlist_3")
+			change.Actor = "user_2"
+			apply(change)
+
+			Expect(playlist("playlist_1").SongIDs).To(HaveLen(4))
+			Expect(m.Report().Entries[0].SkippedPlaylists).To(Equal([]models.SkippedPlaylist{{PlaylistID: "playlist_2", Reason: policy.ErrNotOwner.Error()}}))
+			_, exist := m.Playlist("playlist_3")
+			Expect(exist).To(BeFalse())
+		})
+
+		It("should skip private sources the actor may not read, under a policy", func() {
+			mixtape.Playlists[1].Visibility = models.Private
+			m = mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithPolicy(&policy.Policy{}))
+			change := merge("playlist_3", false, "playlist_2", "playlist_1")
+			change.Actor = "user_2"
+			apply(change)
+
+			Expect(m.Report().Entries[0].SkippedPlaylists).To(Equal([]models.SkippedPlaylist{{PlaylistID: "playlist_2", Reason: policy.ErrNotCollaborator.Error()}}))
+			Expect(playlist("playlist_3").SongIDs).To(Equal([]string{"song_3", "song_4", "song_1", "song_2"}))
+		})
+
+		Context("when sources are invalid", func() {
+			It("should skip them, output a log, and merge the rest", func() {
+				apply(
+					merge("playlist_1", false, "playlist_1", "playlist_x", "playlist_2", "playlist_2"),
+					merge("playlist_1", false),
+					merge("playlist_1", false, "playlist_x"),
+					merge("playlist_x", false, "playlist_2"),
+				)
+
+				Expect(logs).To(gbytes.Say(`msg="skipped playlist" .* source_id=playlist_1 reason="source is the target playlist"`))
+				Expect(m.Report().Entries[0].SkippedPlaylists).To(Equal([]models.SkippedPlaylist{
+					{PlaylistID: "playlist_1", Reason: "source is the target playlist"},
+					{PlaylistID: "playlist_x", Reason: "playlist_id not found"},
+					{PlaylistID: "playlist_2", Reason: "source already merged"},
+				}))
+				Expect(playlist("playlist_1").SongIDs).To(Equal([]string{"song_1", "song_2", "song_3"}))
+				Expect(logs).To(gbytes.Say(`reason="sources missing"`))
+				Expect(logs).To(gbytes.Say(`reason="no sources to merge"`))
+				Expect(logs).To(gbytes.Say(`reason="playlist_id not found"`))
+			})
+		})
+	})
+
+	Describe("split_playlist", func() {
+		It("should move every next size songs to a new playlist, named after the targets or the playlist", func() {
+			apply(split("playlist_3", 1, "", "playlist_a"))
+
+			Expect(logs).To(gbytes.Say(`msg="moved songs" change=split_playlist change_index=0 playlist_id=playlist_3 target_id=playlist_a`))
+			Expect(playlist("playlist_3").SongIDs).To(Equal([]string{"song_3"}))
+			Expect(playlist("playlist_3").Version).To(Equal(int64(1)))
+			Expect(playlist("playlist_a")).To(Equal(models.Playlist{
+				ID: "playlist_a", UserID: "user_2", SongIDs: []string{"song_4"}, Version: 1, CreatedAt: testClock(), UpdatedAt: testClock(),
+			}))
+			Expect(playlist("playlist_3-3").SongIDs).To(Equal([]string{"song_1"}))
+			Expect(m.Applied()[0]).To(Equal(models.PlaylistChange{
+				ID: models.RemoveSongs, Mode: models.RemoveAt, Playlist: models.Playlist{ID: "playlist_3"}, Positions: []int{2, 3},
+			}))
+		})
+
+		It("should move the songs matching where to a new playlist, keeping their order", func() {
+			apply(split("playlist_3", 0, `song.artist = "another_artist"`))
+
+			Expect(playlist("playlist_3").SongIDs).To(Equal([]string{"song_1"}))
+			Expect(playlist("playlist_3-2").SongIDs).To(Equal([]string{"song_3", "song_4"}))
+		})
+
+		Context("when the change is invalid", func() {
+			It("should not move any songs, output a log, and continue", func() {
+				apply(
+					split("playlist_3", 0, ""),
+					split("playlist_3", 1, `songs = 1`),
+					split("playlist_3", -1, ""),
+					split("playlist_3", 3, ""),
+					split("playlist_3", 0, `song.id = "song_x"`),
+					split("playlist_3", 0, `song.id =`),
+					split("playlist_3", 0, `songs = 1`),
+					split("playlist_3", 1, "", "playlist_1"),
+					split("playlist_3", 1, "", "playlist_a", "playlist_a"),
+					split("playlist_x", 1, ""),
+				)
+
+				Expect(logs).To(gbytes.Say(`reason="split by a size above 0 or by where, not both"`))
+				Expect(logs).To(gbytes.Say(`reason="split by a size above 0 or by where, not both"`))
+				Expect(logs).To(gbytes.Say(`reason="split by a size above 0 or by where, not both"`))
+				Expect(logs).To(gbytes.Say(`reason="no songs to move"`))
+				Expect(logs).To(gbytes.Say(`reason="no songs to move"`))
+				Expect(logs).To(gbytes.Say(`reason="invalid selector: unexpected end of expression at 10"`))
+				Expect(logs).To(gbytes.Say(`reason="where matches every song"`))
+				Expect(logs).To(gbytes.Say(`target_id=playlist_1 reason="playlist_id already exists"`))
+				Expect(logs).To(gbytes.Say(`target_id=playlist_a reason="playlist_id already exists"`))
+				Expect(logs).To(gbytes.Say(`reason="playlist_id not found"`))
+				Expect(playlist("playlist_3").SongIDs).To(HaveLen(3))
+				Expect(m.Applied()).To(BeEmpty())
+			})
+		})
+	})
+})
@@ -0,0 +1,132 @@
+package mixtape_test
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/n4wei/highspot/dedupe"
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/policy"
+	"github.com/n4wei/highspot/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Idempotency Keys", func() {
+	var (
+		mixtape *models.Mixtape
+		changes *models.Changes
+		store   *dedupe.Store
+	)
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{{ID: "user_1", Name: "test_user_1"}},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_1"}},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1"},
+				{ID: "song_2", Artist: "some_other_artist", Title: "test_song_2"},
+			},
+		}
+		// remove then add again, which is not a no-op when replayed
+		// without keys
+		changes = &models.Changes{PlaylistChanges: []models.PlaylistChange{
+			{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_1"}, IdempotencyKey: "key_1"},
+			{ID: models.Add, Playlist: models.Playlist{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_2"}}, IdempotencyKey: "key_2"},
+			{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_1"}}, IdempotencyKey: "key_3"},
+			{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_x"}, IdempotencyKey: "key_4"},
+		}}
+		store = dedupe.New()
+	})
+
+	It("should make replaying a batch a no-op", func() {
+		first := mixtape_pkg.New(mixtape, util.Discard, mixtape_pkg.WithDedupe(store), mixtape_pkg.WithClock(testClock))
+		Expect(first.ApplyChanges(changes)).To(Succeed())
+		Expect(first.Applied()).To(HaveLen(3))
+		Expect(store.Keys()).To(Equal([]string{"key_1", "key_2", "key_3", "key_4"}))
+
+		expected := &models.Mixtape{
+			Users:     mixtape.Users,
+			Playlists: []models.Playlist{{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_2", "song_1"}, Version: 2, CreatedAt: testTime, UpdatedAt: testTime}},
+			Songs:     mixtape.Songs,
+			Version:   3,
+		}
+		Expect(mixtape).To(Equal(expected))
+
+		// a retried run, starting from the output and the saved store
+		buf := &bytes.Buffer{}
+		Expect(dedupe.Write(buf, store)).To(Succeed())
+		saved, err := dedupe.Read(buf)
+		Expect(err).ToNot(HaveOccurred())
+
+		logs := gbytes.NewBuffer()
+		second := mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithDedupe(saved), mixtape_pkg.WithClock(time.Now))
+		Expect(second.ApplyChanges(changes)).To(Succeed())
+
+		Expect(mixtape).To(Equal(expected))
+		Expect(second.Applied()).To(BeEmpty())
+		for _, entry := range second.Report().Entries {
+			Expect(entry.Status).To(Equal(models.Skipped))
+			Expect(entry.Reason).To(Equal("idempotency_key already processed"))
+		}
+		Expect(logs).To(gbytes.Say(`playlist_id=playlist_1 idempotency_key=key_1 reason="idempotency_key already processed"`))
+	})
+
+	It("should skip a key repeated within a batch", func() {
+		changes.PlaylistChanges[1].IdempotencyKey = "key_1"
+
+		m := mixtape_pkg.New(mixtape, util.Discard, mixtape_pkg.WithDedupe(store))
+		Expect(m.ApplyChanges(changes)).To(Succeed())
+
+		Expect(mixtape.Playlists).To(BeEmpty())
+		Expect(m.Report().Entries[1].Reason).To(Equal("idempotency_key already processed"))
+	})
+
+	Context("when changes are skipped for a reason that can be fixed", func() {
+		BeforeEach(func() {
+			stale := int64(5)
+			for i := range changes.PlaylistChanges {
+				changes.PlaylistChanges[i].Actor = "user_1"
+			}
+			changes.PlaylistChanges[2].Actor = "user_2"
+			changes.PlaylistChanges[3].IfMatch = &stale
+		})
+
+		expectUnrecorded := func(m *mixtape_pkg.Mixtape) {
+			Expect(m.Report().Entries[2].Reason).To(Equal(policy.ErrNotCollaborator.Error()))
+			Expect(m.Report().Entries[3].Reason).To(Equal("if_match does not match playlist version"))
+			Expect(store.Keys()).To(Equal([]string{"key_1", "key_2"}))
+		}
+
+		It("should not record their keys", func() {
+			m := mixtape_pkg.New(mixtape, util.Discard, mixtape_pkg.WithDedupe(store), mixtape_pkg.WithPolicy(&policy.Policy{}))
+			Expect(m.ApplyChanges(changes)).To(Succeed())
+			expectUnrecorded(m)
+
+			// retried once the actor is fixed
+			changes.PlaylistChanges[2].Actor = "user_1"
+			m = mixtape_pkg.New(mixtape, util.Discard, mixtape_pkg.WithDedupe(store), mixtape_pkg.WithPolicy(&policy.Policy{}))
+			Expect(m.ApplyChanges(changes)).To(Succeed())
+			Expect(m.Applied()).To(HaveLen(1))
+			Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"song_2", "song_1"}))
+		})
+
+		It("should not record their keys in parallel either", func() {
+			m := mixtape_pkg.New(mixtape, util.Discard, mixtape_pkg.WithDedupe(store), mixtape_pkg.WithPolicy(&policy.Policy{}))
+			Expect(m.ApplyChangesParallel(changes, 4)).To(Succeed())
+			expectUnrecorded(m)
+		})
+	})
+
+	It("should ignore keys without a store", func() {
+		m := mixtape_pkg.New(mixtape, util.Discard)
+		Expect(m.ApplyChanges(changes)).To(Succeed())
+		Expect(m.ApplyChanges(changes)).To(Succeed())
+
+		Expect(m.Applied()).To(HaveLen(6))
+	})
+})
@@ -0,0 +1,105 @@
+package mixtape_test
+
+import (
+	"time"
+
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Limits", func() {
+	var (
+		mixtape *models.Mixtape
+		logs    *gbytes.Buffer
+	)
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{{ID: "user_1", Name: "test_user_1"}},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_1", "song_2"}},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1", DurationMs: 180000},
+				{ID: "song_2", Artist: "some_other_artist", Title: "test_song_2", DurationMs: 240000},
+				{ID: "song_3", Artist: "another_artist", Title: "test_song_3", DurationMs: 300000},
+				// no duration, counts as 0
+				{ID: "song_4", Artist: "another_artist", Title: "test_song_4"},
+			},
+		}
+		logs = gbytes.NewBuffer()
+	})
+
+	duration := func(m *mixtape_pkg.Mixtape, id string) time.Duration {
+		d, exist := m.Duration(id)
+		Expect(exist).To(BeTrue())
+		return d
+	}
+
+	apply := func(m *mixtape_pkg.Mixtape, changes ...models.PlaylistChange) {
+		Expect(m.ApplyChanges(&models.Changes{PlaylistChanges: changes})).To(Succeed())
+	}
+
+	Describe("Duration", func() {
+		It("should keep the total duration of every playlist as songs are added and playlists removed", func() {
+			m := mixtape_pkg.New(mixtape, newTestLogger(logs))
+			Expect(duration(m, "playlist_1")).To(Equal(7 * time.Minute))
+
+			apply(m,
+				models.PlaylistChange{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_3", "song_4"}}},
+				models.PlaylistChange{ID: models.Add, Playlist: models.Playlist{ID: "playlist_2", UserID: "user_1", SongIDs: []string{"song_2", "song_4"}}},
+				models.PlaylistChange{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_1"}},
+			)
+			_, exist := m.Duration("playlist_1")
+			Expect(exist).To(BeFalse())
+			Expect(duration(m, "playlist_2")).To(Equal(4 * time.Minute))
+			Expect(m.Index()).To(Equal(mixtape_pkg.BuildIndex(mixtape)))
+		})
+	})
+
+	Context("with a maximum number of songs", func() {
+		It("should not add songs past it, and report why", func() {
+			m := mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithLimits(mixtape_pkg.Limits{MaxSongs: 3}))
+			apply(m,
+				models.PlaylistChange{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_3", "song_4"}}},
+				models.PlaylistChange{ID: models.Add, Playlist: models.Playlist{ID: "playlist_2", UserID: "user_1", SongIDs: []string{"song_1", "song_2", "song_3", "song_4"}}},
+			)
+
+			Expect(logs).To(gbytes.Say(`msg="skipped song" .* playlist_id=playlist_1 song_id=song_4 reason="playlist would exceed max songs"`))
+			Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"song_1", "song_2", "song_3"}))
+			Expect(mixtape.Playlists[1].SongIDs).To(Equal([]string{"song_1", "song_2", "song_3"}))
+			Expect(m.Report().Entries[1].SkippedSongs).To(Equal([]models.SkippedSong{
+				{SongID: "song_4", Reason: "playlist would exceed max songs"},
+			}))
+		})
+	})
+
+	Context("with a maximum duration", func() {
+		It("should not add songs that would take a playlist past it, but still add shorter ones after them", func() {
+			m := mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithLimits(mixtape_pkg.Limits{MaxDuration: 10 * time.Minute}))
+			apply(m,
+				models.PlaylistChange{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_3", "song_4"}}},
+				models.PlaylistChange{ID: models.Add, Playlist: models.Playlist{ID: "playlist_2", UserID: "user_1", SongIDs: []string{"song_3", "song_2", "song_1"}}},
+			)
+
+			Expect(logs).To(gbytes.Say(`msg="skipped song" .* playlist_id=playlist_1 song_id=song_3 reason="playlist would exceed max duration"`))
+			Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"song_1", "song_2", "song_4"}))
+			Expect(mixtape.Playlists[1].SongIDs).To(Equal([]string{"song_3", "song_2"}))
+			Expect(duration(m, "playlist_2")).To(Equal(9 * time.Minute))
+			Expect(m.Report().Entries[1].SkippedSongs).To(Equal([]models.SkippedSong{
+				{SongID: "song_1", Reason: "playlist would exceed max duration"},
+			}))
+		})
+
+		It("should not add a playlist none of whose songs fit", func() {
+			m := mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithLimits(mixtape_pkg.Limits{MaxDuration: 2 * time.Minute}))
+			apply(m, models.PlaylistChange{ID: models.Add, Playlist: models.Playlist{ID: "playlist_2", UserID: "user_1", SongIDs: []string{"song_1", "song_x"}}})
+
+			Expect(logs).To(gbytes.Say(`msg="skipped change" .* playlist_id=playlist_2 reason="playlist has no songs within limits"`))
+			Expect(mixtape.Playlists).To(HaveLen(1))
+		})
+	})
+})
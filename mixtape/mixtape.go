@@ -1,7 +1,13 @@
 package mixtape
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/n4wei/highspot/dedupe"
 	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/policy"
 	"github.com/n4wei/highspot/util"
 )
 
@@ -13,41 +19,182 @@ type lookup struct {
 	songs map[string]int
 	// map of playlist id to corresponding index in Mixtape.Playlists
 	playlists map[string]int
-	// map of playlist id to a second map of song ids belonging to this playlist
-	playlistSongs map[string]map[string]bool
+	// map of playlist id to a second map of song ids belonging to this
+	// playlist, and how many times they appear in it
+	playlistSongs map[string]map[string]int
+	// map of user id to a second map of ids of the playlists they own or
+	// are an editor of
+	editable map[string]map[string]bool
+	// map of playlist id to the total duration of its songs
+	durations map[string]time.Duration
+	// map of user id to a second map of ids of the playlists they own
+	owned map[string]map[string]bool
+	// map of song id to a second map of ids of the playlists it is in
+	songPlaylists map[string]map[string]bool
 }
 
 type Mixtape struct {
 	mixtape *models.Mixtape
 	lookup  *lookup
+	// changes in the order they were applied, trimmed to what took effect
+	applied []models.PlaylistChange
+	// one entry per change, applied or not
+	report []models.ReportEntry
 
 	logger util.Logger
+	// nil unless WithDedupe is given
+	dedupe *dedupe.Store
+	// nil unless WithPolicy is given
+	policy *policy.Policy
+	// no limits unless WithLimits is given
+	limits Limits
+	// what happens when a precondition fails, changes are skipped unless
+	// WithPreconditionFailure is given
+	onFailure models.FailureAction
+	// time.Now unless WithClock is given, read once per batch into now so
+	// that every change in a batch has the same timestamps
+	clock func() time.Time
+	now   time.Time
+}
+
+// Option configures optional behavior of a Mixtape.
+type Option func(*Mixtape)
+
+// WithDedupe skips changes whose idempotency key is in the store, and
+// records the keys of the changes it processes in it.
+func WithDedupe(store *dedupe.Store) Option {
+	return func(m *Mixtape) {
+		m.dedupe = store
+	}
+}
+
+// WithClock sets the clock that timestamps playlists, eg. to a fixed time
+// for reproducible output.
+func WithClock(clock func() time.Time) Option {
+	return func(m *Mixtape) {
+		m.clock = clock
+	}
+}
+
+// WithPolicy skips changes that the policy does not authorize.
+func WithPolicy(p *policy.Policy) Option {
+	return func(m *Mixtape) {
+		m.policy = p
+	}
 }
 
-func New(mixtape *models.Mixtape, logger util.Logger) *Mixtape {
+// Limits bound the size of playlists, a zero limit is no limit. Songs that
+// would take a playlist over a limit are not added to it. Playlists that
+// are already over a limit are kept as they are.
+type Limits struct {
+	MaxSongs    int
+	MaxDuration time.Duration
+}
+
+// WithLimits enforces limits on the playlists that changes add songs to.
+func WithLimits(limits Limits) Option {
+	return func(m *Mixtape) {
+		m.limits = limits
+	}
+}
+
+// Index is the exported form of the lookup hash maps. It lets a snapshot
+// persist the maps next to the mixtape and hand them back to NewWithIndex,
+// instead of rebuilding them from the mixtape on every start.
+type Index struct {
+	Users         map[string]int
+	Songs         map[string]int
+	Playlists     map[string]int
+	PlaylistSongs map[string]map[string]int
+	Editable      map[string]map[string]bool
+	Durations     map[string]time.Duration
+	Owned         map[string]map[string]bool
+	SongPlaylists map[string]map[string]bool
+}
+
+func New(mixtape *models.Mixtape, logger util.Logger, opts ...Option) *Mixtape {
 	mt := &Mixtape{
 		mixtape: mixtape,
 		logger:  logger,
+		clock:   time.Now,
+	}
+	for _, opt := range opts {
+		opt(mt)
 	}
 	mt.buildLookup()
 	return mt
 }
 
+// NewWithIndex skips building the lookup hash maps and uses the given index,
+// which must have been built from this exact mixtape. The index is used
+// as is, not copied, and is updated as changes are applied.
+func NewWithIndex(mixtape *models.Mixtape, index *Index, logger util.Logger, opts ...Option) *Mixtape {
+	mt := &Mixtape{
+		mixtape: mixtape,
+		lookup: &lookup{
+			users:         index.Users,
+			songs:         index.Songs,
+			playlists:     index.Playlists,
+			playlistSongs: index.PlaylistSongs,
+			editable:      index.Editable,
+			durations:     index.Durations,
+			owned:         index.Owned,
+			songPlaylists: index.SongPlaylists,
+		},
+		logger: logger,
+		clock:  time.Now,
+	}
+	for _, opt := range opts {
+		opt(mt)
+	}
+	return mt
+}
+
+// BuildIndex builds the lookup hash maps of a mixtape without creating a
+// Mixtape object, for callers that only need to persist them.
+func BuildIndex(mixtape *models.Mixtape) *Index {
+	return (&Mixtape{mixtape: mixtape}).Index()
+}
+
+// Index returns the current lookup hash maps. They are shared with the
+// Mixtape object, not copied.
+func (m *Mixtape) Index() *Index {
+	if m.lookup == nil {
+		m.buildLookup()
+	}
+	return &Index{
+		Users:         m.lookup.users,
+		Songs:         m.lookup.songs,
+		Playlists:     m.lookup.playlists,
+		PlaylistSongs: m.lookup.playlistSongs,
+		Editable:      m.lookup.editable,
+		Durations:     m.lookup.durations,
+		Owned:         m.lookup.owned,
+		SongPlaylists: m.lookup.songPlaylists,
+	}
+}
+
 // This method builds the lookup hash maps for the mixtape.
 // It is created once in the constructor of the Mixtape object.
-// runtime: O(u + s + p + p*ps)
-// space: O(u + s + p + p*ps) since we create a hash map for each
+// runtime: O(u + s + p + p*ps + p*pc)
+// space: O(u + s + p + p*ps + p*pc) since we create a hash map for each
 // - u is the number of users
 // - s is the number of songs
 // - p is the number of playlists
 // - ps is the most number of songs in any playlist
+// - pc is the most number of collaborators on any playlist
 func (m *Mixtape) buildLookup() {
 	lookup := &lookup{
 		users:         map[string]int{},
 		songs:         map[string]int{},
 		playlists:     map[string]int{},
-		playlistSongs: map[string]map[string]bool{},
+		playlistSongs: map[string]map[string]int{},
+		editable:      map[string]map[string]bool{},
+		durations:     map[string]time.Duration{},
+		owned:         map[string]map[string]bool{},
+		songPlaylists: map[string]map[string]bool{},
 	}
+	m.lookup = lookup
 
 	for i, user := range m.mixtape.Users {
 		lookup.users[user.ID] = i
@@ -57,43 +204,245 @@ func (m *Mixtape) buildLookup() {
 	}
 	for i, playlist := range m.mixtape.Playlists {
 		lookup.playlists[playlist.ID] = i
+		// every playlist has entries, like after insertPlaylist, even
+		// without songs
+		lookup.playlistSongs[playlist.ID] = map[string]int{}
+		lookup.durations[playlist.ID] = 0
+		addTo(lookup.owned, playlist.UserID, playlist.ID)
 		for _, songID := range playlist.SongIDs {
-			if lookup.playlistSongs[playlist.ID] == nil {
-				lookup.playlistSongs[playlist.ID] = map[string]bool{}
-			}
-			lookup.playlistSongs[playlist.ID][songID] = true
+			lookup.playlistSongs[playlist.ID][songID]++
+			lookup.durations[playlist.ID] += m.songDuration(songID)
+			addTo(lookup.songPlaylists, songID, playlist.ID)
 		}
+		m.grantEditors(playlist)
 	}
+}
 
-	m.lookup = lookup
+// Applied returns the changes that took effect so far, in the order they
+// were applied. Skipped changes are left out, and so are the invalid parts
+// of applied changes, eg. songs that were not in mixtape. Replaying them on
+// the original mixtape gives the same result without any skips.
+func (m *Mixtape) Applied() []models.PlaylistChange {
+	return m.applied
+}
+
+// Report returns an entry for every change processed so far, in order.
+func (m *Mixtape) Report() *models.Report {
+	return &models.Report{Entries: m.report}
+}
+
+// Playlist returns a copy of the playlist with the given id, so the caller
+// can not modify the mixtape behind the lookup hash maps.
+// runtime: O(s), s is the number of songs in the playlist
+func (m *Mixtape) Playlist(id string) (models.Playlist, bool) {
+	i, exist := m.lookup.playlists[id]
+	if !exist {
+		return models.Playlist{}, false
+	}
+	return copyPlaylist(m.mixtape.Playlists[i]), true
+}
+
+// Duration returns the total duration of the songs in the playlist with
+// the given id.
+// runtime: O(1)
+func (m *Mixtape) Duration(id string) (time.Duration, bool) {
+	if _, exist := m.lookup.playlists[id]; !exist {
+		return 0, false
+	}
+	return m.lookup.durations[id], true
+}
+
+// rejected skips a change before it is validated: a duplicate, one the
+// policy does not authorize, or one based on a stale version of its
+// playlist. The last two can be fixed, so their keys are not recorded.
+func (m *Mixtape) rejected(c *call, change models.PlaylistChange) bool {
+	if m.duplicate(change) {
+		c.skip(reasonDuplicateKey, util.IdempotencyKey(change.IdempotencyKey))
+		return true
+	}
+
+	if err := m.authorize(change); err != nil {
+		c.skip(err.Error())
+		c.retryable = true
+		return true
+	}
+
+	if change.IfMatch != nil {
+		version := m.playlistVersion(change.Playlist.ID)
+		// playlists loaded without a version are at 0 too, but 0 means
+		// the playlist did not exist
+		_, exist := m.lookup.playlists[change.Playlist.ID]
+		if *change.IfMatch != version || (*change.IfMatch == 0 && exist) {
+			c.skip(reasonVersionMismatch, util.IfMatch(*change.IfMatch), util.Version(version))
+			c.retryable = true
+			return true
+		}
+	}
+	return false
+}
+
+// authorize checks the change against the policy, if there is one. Changes
+// to several playlists also check the changes they are made of, eg. the
+// remove of each playlist merge_playlists deletes. A clone_playlist is
+// checked against the playlist it copies, and its add on its own.
+func (m *Mixtape) authorize(change models.PlaylistChange) error {
+	if m.policy == nil {
+		return nil
+	}
+	id := change.Playlist.ID
+	if change.ID == models.ClonePlaylist {
+		id = change.From
+	}
+	var existing *models.Playlist
+	if i, exist := m.lookup.playlists[id]; exist {
+		existing = &m.mixtape.Playlists[i]
+	}
+	return m.policy.Authorize(change, existing)
+}
+
+// authorizeRead checks that the actor may read a playlist besides the one
+// the change is to, eg. a merge_playlists source, if there is a policy.
+func (m *Mixtape) authorizeRead(actor string, playlist *models.Playlist) error {
+	if m.policy == nil {
+		return nil
+	}
+	return m.policy.AuthorizeRead(actor, playlist)
+}
+
+// playlistVersion is 0 for a playlist that does not exist, and for one
+// loaded from a document written before versions existed.
+func (m *Mixtape) playlistVersion(id string) int64 {
+	i, exist := m.lookup.playlists[id]
+	if !exist {
+		return 0
+	}
+	return m.mixtape.Playlists[i].Version
+}
+
+func copyPlaylist(playlist models.Playlist) models.Playlist {
+	playlist.SongIDs = append([]string{}, playlist.SongIDs...)
+	if playlist.Collaborators != nil {
+		playlist.Collaborators = append([]models.Collaborator{}, playlist.Collaborators...)
+	}
+	return playlist
 }
 
 // This method takes all the changes and applies them to mixtape in the
 // order they were provided in the changes JSON file (order in an array).
 func (m *Mixtape) ApplyChanges(changes *models.Changes) error {
+	err := m.checkVersion(changes)
+	if err != nil {
+		return err
+	}
+	m.now = m.clock()
+
 	// I chose to go with the UX design of skipping invalid changes,
 	// logging them, and keep applying further changes.
 	// It's straightforward to make any of these methods return intentional
 	// types of errors to stop applying changes partially through.
-	var err error
-	for _, change := range changes.PlaylistChanges {
-		switch change.ID {
-		case models.Add:
-			err = m.addPlaylist(change.Playlist)
-			if err != nil {
-				return err
-			}
-		case models.Remove:
-			err = m.removePlaylist(change.Playlist)
-			if err != nil {
-				return err
-			}
-		case models.AddSongs:
-			err = m.addSongsToPlaylist(change.Playlist)
-			if err != nil {
-				return err
-			}
+	for i, change := range changes.PlaylistChanges {
+		err = m.applyChange(i, change)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
+
+// ErrVersionMismatch is returned, before applying anything, for changes
+// based on another version of the mixtape than the current one.
+var ErrVersionMismatch = errors.New("if_match does not match the mixtape version")
+
+func (m *Mixtape) checkVersion(changes *models.Changes) error {
+	if changes.IfMatch != nil && *changes.IfMatch != m.mixtape.Version {
+		return fmt.Errorf("%w: if_match %d, version %d", ErrVersionMismatch, *changes.IfMatch, m.mixtape.Version)
+	}
+	return nil
+}
+
+// duplicate tells whether the change's idempotency key was recorded
+// before, in this batch or a previous run.
+func (m *Mixtape) duplicate(change models.PlaylistChange) bool {
+	return m.dedupe != nil && change.IdempotencyKey != "" && m.dedupe.Seen(change.IdempotencyKey)
+}
+
+// recordKey records the key of a change that was applied, or skipped for
+// a reason that holds whatever the mixtape, eg. a song that does not
+// exist. The keys of changes skipped for a reason that can be fixed, like
+// an unauthorized actor or a stale if_match, are not recorded, so they can
+// be retried with the same key once it is.
+func (m *Mixtape) recordKey(change models.PlaylistChange) {
+	if m.dedupe != nil && change.IdempotencyKey != "" {
+		m.dedupe.Record(change.IdempotencyKey)
+	}
+}
+
+// applyChange applies the change at index i of a batch, adds its entry to
+// the report and records its key.
+func (m *Mixtape) applyChange(i int, change models.PlaylistChange) error {
+	c := m.newCall(i, change)
+	err := m.apply(c, i, change)
+	m.report = append(m.report, *c.entry)
+	if !c.retryable {
+		m.recordKey(change)
+	}
+	return err
+}
+
+func (m *Mixtape) apply(c *call, i int, change models.PlaylistChange) error {
+	if m.rejected(c, change) {
+		return nil
+	}
+	if skipped, err := m.preconditions(c, i, change); skipped {
+		return err
+	}
+	// its operations are versioned and recorded as applied on their own
+	if change.ID == models.Bulk {
+		return m.bulk(c, i, change)
+	}
+	applied := len(m.applied)
+
+	var err error
+	switch change.ID {
+	case models.Add:
+		err = m.addPlaylist(c, change.Playlist)
+	case models.Remove:
+		err = m.removePlaylist(c, change.Playlist)
+	case models.AddSongs:
+		err = m.addSongsToPlaylist(c, change.Playlist)
+	case models.AddCollaborators:
+		err = m.addCollaborators(c, change.Playlist)
+	case models.RemoveCollaborators:
+		err = m.removeCollaborators(c, change.Playlist)
+	case models.TransferOwnership:
+		err = m.transferOwnership(c, change.Playlist)
+	case models.UpdatePlaylist:
+		err = m.updatePlaylist(c, change.Playlist)
+	case models.RemoveSongs:
+		err = m.removeSongs(c, change)
+	case models.AutoFill:
+		err = m.autoFill(c, change)
+	case models.ClonePlaylist:
+		err = m.clonePlaylist(c, change)
+	case models.MergePlaylists:
+		err = m.mergePlaylists(c, change)
+	case models.SplitPlaylist:
+		err = m.splitPlaylist(c, change)
+	case models.UpsertPlaylist:
+		err = m.upsertPlaylist(c, change.Playlist)
+	case models.SetSongs:
+		err = m.setSongs(c, change.Playlist)
+	default:
+		c.skip(reasonUnknownChange)
+	}
+
+	if len(m.applied) > applied {
+		m.mixtape.Version++
+	} else if c.entry.Status == models.Applied {
+		// eg. add_songs where every song was skipped
+		c.entry.Status = models.Skipped
+		c.entry.Reason = reasonNothingApplied
+	}
+	c.entry.Version = m.playlistVersion(change.Playlist.ID)
+	return err
+}
@@ -3,6 +3,7 @@ package mixtape_test
 import (
 	"testing"
 
+	"github.com/n4wei/highspot/models"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -11,3 +12,28 @@ func TestMixtape(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Mixtape Suite")
 }
+
+// copyMixtape returns a deep copy of a fixture, so that a test can apply
+// changes to several mixtapes that start out the same, and compare them.
+func copyMixtape(mixtape *models.Mixtape) *models.Mixtape {
+	copied := *mixtape
+	if mixtape.Users != nil {
+		copied.Users = append([]models.User{}, mixtape.Users...)
+	}
+	if mixtape.Songs != nil {
+		copied.Songs = append([]models.Song{}, mixtape.Songs...)
+	}
+	if mixtape.Playlists != nil {
+		copied.Playlists = make([]models.Playlist, len(mixtape.Playlists))
+		for i, playlist := range mixtape.Playlists {
+			if playlist.SongIDs != nil {
+				playlist.SongIDs = append([]string{}, playlist.SongIDs...)
+			}
+			if playlist.Collaborators != nil {
+				playlist.Collaborators = append([]models.Collaborator{}, playlist.Collaborators...)
+			}
+			copied.Playlists[i] = playlist
+		}
+	}
+	return &copied
+}
@@ -0,0 +1,356 @@
+package mixtape
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/n4wei/highspot/dedupe"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/util"
+)
+
+// Every change reads and writes a single playlist, the one with the ID in
+// the change. Users and songs are only ever read. So changes to different
+// playlist IDs are independent of each other, and only the order of changes
+// to the same ID matters, including an add after a remove of that ID.
+// Grouping changes by playlist ID therefore captures every dependency in a
+// batch. The exceptions are the changes that read or write other
+// playlists too, see SpansPlaylists, so a batch with any of them is
+// applied sequentially.
+
+// Groups returns the indices of changes grouped by playlist ID. Groups are
+// ordered by the first change in them, and indices within a group keep the
+// order of the batch.
+// runtime: O(c), c is the number of changes
+func Groups(changes *models.Changes) [][]int {
+	groups := [][]int{}
+	groupOf := map[string]int{}
+	for i, change := range changes.PlaylistChanges {
+		id := change.Playlist.ID
+		g, exist := groupOf[id]
+		if !exist {
+			g = len(groups)
+			groupOf[id] = g
+			groups = append(groups, []int{})
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+// SpansPlaylists tells whether a change reads or writes playlists besides
+// the one with its ID: auto_fill reads every playlist to recommend songs,
+// bulk matches every playlist, and clone_playlist, merge_playlists and
+// split_playlist read or write the playlists they name. Such changes are
+// applied sequentially, and can not be sharded, except for bulk, see
+// shard.Split.
+func SpansPlaylists(change models.PlaylistChange) bool {
+	switch change.ID {
+	case models.AutoFill, models.Bulk, models.ClonePlaylist, models.MergePlaylists, models.SplitPlaylist:
+		return true
+	}
+	return false
+}
+
+// result is the outcome of one change, computed in isolation.
+type result struct {
+	logs    []entry
+	applied *models.PlaylistChange
+	report  models.ReportEntry
+	// whether the change's key is recorded, see recordKey
+	recorded bool
+	err      error
+}
+
+// ApplyChangesParallel has the same outcome as ApplyChanges, including the
+// order of the playlist array, the applied changes, the report and the
+// logs, but validates independent groups of changes in parallel on a pool
+// of workers.
+//
+// It runs in two phases. First, each group is applied by a worker to a
+// private Mixtape holding only that group's playlist and sharing the read
+// only users and songs. This is where the validation cost is. The logs and
+// the trimmed applied change of every change are recorded. Then the applied
+// changes are committed to this mixtape in the original order, which is
+// cheap since they are known to be valid, and the logs are replayed in that
+// same order. Committing in order is what keeps the playlist array in the
+// same order as a sequential apply, since removes swap playlists around.
+func (m *Mixtape) ApplyChangesParallel(changes *models.Changes, workers int) error {
+	if workers < 1 {
+		return fmt.Errorf("workers must be at least 1, got %d", workers)
+	}
+	// whether a key repeated in another group is a duplicate depends on
+	// whether the first one was recorded
+	keys := map[string]string{}
+	for _, change := range changes.PlaylistChanges {
+		if SpansPlaylists(change) {
+			return m.ApplyChanges(changes)
+		}
+		if key := change.IdempotencyKey; key != "" {
+			if id, exist := keys[key]; exist && id != change.Playlist.ID {
+				return m.ApplyChanges(changes)
+			}
+			keys[key] = change.Playlist.ID
+		}
+	}
+	err := m.checkVersion(changes)
+	if err != nil {
+		return err
+	}
+	m.now = m.clock()
+
+	results := make([]result, len(changes.PlaylistChanges))
+	groups := make(chan []int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for group := range groups {
+				m.applyGroup(changes.PlaylistChanges, group, results)
+			}
+		}()
+	}
+	for _, group := range Groups(changes) {
+		groups <- group
+	}
+	close(groups)
+	wg.Wait()
+
+	for i, r := range results {
+		for _, e := range r.logs {
+			e.replay(m.logger)
+		}
+		m.report = append(m.report, r.report)
+		if r.recorded {
+			m.recordKey(changes.PlaylistChanges[i])
+		}
+		if r.err != nil {
+			return r.err
+		}
+		if r.applied != nil {
+			m.commit(*r.applied)
+		}
+	}
+	return nil
+}
+
+// applyGroup applies a group of changes to the same playlist ID on a
+// private Mixtape, and records the outcome of each change in results. It
+// only reads from this mixtape, so groups can run concurrently.
+func (m *Mixtape) applyGroup(changes []models.PlaylistChange, group []int, results []result) {
+	id := changes[group[0]].Playlist.ID
+	private := &Mixtape{
+		mixtape: &models.Mixtape{
+			Users:     m.mixtape.Users,
+			Playlists: []models.Playlist{},
+			Songs:     m.mixtape.Songs,
+			// read by allowsDuplicates
+			AllowDuplicates: m.mixtape.AllowDuplicates,
+		},
+		lookup: &lookup{
+			users:         m.lookup.users,
+			songs:         m.lookup.songs,
+			playlists:     map[string]int{},
+			playlistSongs: map[string]map[string]int{},
+			// editors of other playlists are not needed to validate
+			// this group
+			editable:  map[string]map[string]bool{},
+			durations: map[string]time.Duration{},
+			// nor are the reverse indexes, which only queries read
+			owned:         map[string]map[string]bool{},
+			songPlaylists: map[string]map[string]bool{},
+		},
+		policy:    m.policy,
+		limits:    m.limits,
+		onFailure: m.onFailure,
+		now:       m.now,
+	}
+	if i, exist := m.lookup.playlists[id]; exist {
+		private.insertPlaylist(copyPlaylist(m.mixtape.Playlists[i]))
+	}
+	// keys only repeat within a group, so a store of the group's keys seen
+	// before tells duplicates apart like this mixtape's store would
+	if m.dedupe != nil {
+		private.dedupe = dedupe.New()
+		for _, i := range group {
+			if m.duplicate(changes[i]) {
+				private.dedupe.Record(changes[i].IdempotencyKey)
+			}
+		}
+	}
+
+	for _, i := range group {
+		logs := &recorder{}
+		private.logger = logs
+		applied := len(private.applied)
+
+		err := private.applyChange(i, changes[i])
+
+		results[i] = result{logs: logs.entries, report: private.report[len(private.report)-1], err: err}
+		results[i].recorded = private.dedupe != nil && changes[i].IdempotencyKey != "" && private.dedupe.Seen(changes[i].IdempotencyKey)
+		if len(private.applied) > applied {
+			change := private.applied[applied]
+			results[i].applied = &change
+		}
+		if err != nil {
+			// a sequential apply stops here, the rest of the group is
+			// never committed
+			return
+		}
+	}
+}
+
+// commit applies a change that is already known to be valid, ie. one
+// returned by Applied.
+func (m *Mixtape) commit(change models.PlaylistChange) {
+	switch change.ID {
+	case models.Add:
+		// the private Mixtape may still append to the songs it added with
+		playlist := copyPlaylist(change.Playlist)
+		m.stamp(&playlist)
+		m.insertPlaylist(playlist)
+	case models.Remove:
+		m.deletePlaylist(m.lookup.playlists[change.Playlist.ID])
+	case models.AddSongs:
+		i := m.lookup.playlists[change.Playlist.ID]
+		for _, songID := range change.Playlist.SongIDs {
+			m.appendSong(i, songID)
+		}
+		m.touch(i)
+	case models.AddCollaborators:
+		i := m.lookup.playlists[change.Playlist.ID]
+		for _, collaborator := range change.Playlist.Collaborators {
+			m.setCollaborator(i, collaborator)
+		}
+		m.touch(i)
+	case models.RemoveCollaborators:
+		i := m.lookup.playlists[change.Playlist.ID]
+		for _, collaborator := range change.Playlist.Collaborators {
+			m.deleteCollaborator(i, collaborator.UserID)
+		}
+		m.touch(i)
+	case models.TransferOwnership:
+		i := m.lookup.playlists[change.Playlist.ID]
+		m.transfer(i, change.Playlist.UserID)
+		m.touch(i)
+	case models.UpdatePlaylist:
+		i := m.lookup.playlists[change.Playlist.ID]
+		playlist := &m.mixtape.Playlists[i]
+		if change.Playlist.Name != "" {
+			playlist.Name = change.Playlist.Name
+		}
+		if change.Playlist.Description != "" {
+			playlist.Description = change.Playlist.Description
+		}
+		if change.Playlist.Visibility != "" {
+			playlist.Visibility = change.Playlist.Visibility
+		}
+		m.touch(i)
+	case models.RemoveSongs:
+		i := m.lookup.playlists[change.Playlist.ID]
+		songIDs := m.mixtape.Playlists[i].SongIDs
+		removed := map[int]bool{}
+		for _, position := range change.Positions {
+			removed[position-1] = true
+		}
+		for _, songID := range change.Playlist.SongIDs {
+			for _, j := range occurrences(songIDs, songID, change.Mode == models.RemoveAll, removed) {
+				removed[j] = true
+			}
+		}
+		m.removeAt(i, removed)
+		m.touch(i)
+	case models.UpsertPlaylist:
+		i := m.lookup.playlists[change.Playlist.ID]
+		if change.Playlist.UserID != m.mixtape.Playlists[i].UserID {
+			m.transfer(i, change.Playlist.UserID)
+		}
+		m.replaceSongs(i, change.Playlist.SongIDs)
+		m.touch(i)
+	case models.SetSongs:
+		i := m.lookup.playlists[change.Playlist.ID]
+		m.replaceSongs(i, change.Playlist.SongIDs)
+		m.touch(i)
+	}
+	m.mixtape.Version++
+	m.applied = append(m.applied, change)
+}
+
+// recorder is a logger that keeps logs to replay them later, in order.
+// Fields added with With are flattened into each entry, which a slog
+// handler formats the same way.
+type recorder struct {
+	entries []entry
+}
+
+type entry struct {
+	level  slog.Level
+	msg    string
+	fields []util.Field
+}
+
+func (e entry) replay(logger util.Logger) {
+	switch e.level {
+	case slog.LevelDebug:
+		logger.Debug(e.msg, e.fields...)
+	case slog.LevelInfo:
+		logger.Info(e.msg, e.fields...)
+	case slog.LevelWarn:
+		logger.Warn(e.msg, e.fields...)
+	default:
+		logger.Error(e.msg, e.fields...)
+	}
+}
+
+func (r *recorder) Debug(msg string, fields ...util.Field) {
+	r.record(nil, slog.LevelDebug, msg, fields)
+}
+
+func (r *recorder) Info(msg string, fields ...util.Field) {
+	r.record(nil, slog.LevelInfo, msg, fields)
+}
+
+func (r *recorder) Warn(msg string, fields ...util.Field) {
+	r.record(nil, slog.LevelWarn, msg, fields)
+}
+
+func (r *recorder) Error(msg string, fields ...util.Field) {
+	r.record(nil, slog.LevelError, msg, fields)
+}
+
+func (r *recorder) With(fields ...util.Field) util.Logger {
+	return &recorderWith{recorder: r, fields: fields}
+}
+
+func (r *recorder) record(with []util.Field, level slog.Level, msg string, fields []util.Field) {
+	all := append(append([]util.Field{}, with...), fields...)
+	r.entries = append(r.entries, entry{level: level, msg: msg, fields: all})
+}
+
+type recorderWith struct {
+	recorder *recorder
+	fields   []util.Field
+}
+
+func (r *recorderWith) Debug(msg string, fields ...util.Field) {
+	r.recorder.record(r.fields, slog.LevelDebug, msg, fields)
+}
+
+func (r *recorderWith) Info(msg string, fields ...util.Field) {
+	r.recorder.record(r.fields, slog.LevelInfo, msg, fields)
+}
+
+func (r *recorderWith) Warn(msg string, fields ...util.Field) {
+	r.recorder.record(r.fields, slog.LevelWarn, msg, fields)
+}
+
+func (r *recorderWith) Error(msg string, fields ...util.Field) {
+	r.recorder.record(r.fields, slog.LevelError, msg, fields)
+}
+
+func (r *recorderWith) With(fields ...util.Field) util.Logger {
+	return &recorderWith{recorder: r.recorder, fields: append(append([]util.Field{}, r.fields...), fields...)}
+}
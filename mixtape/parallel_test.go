@@ -0,0 +1,193 @@
+package mixtape_test
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/n4wei/highspot/dedupe"
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/policy"
+	"github.com/n4wei/highspot/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Parallel Changes", func() {
+	var mixtape *models.Mixtape
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{
+				{ID: "user_1", Name: "test_user_1"},
+				{ID: "user_2", Name: "test_user_2"},
+			},
+			Playlists: []models.Playlist{
+				{ID: "playlist_0", UserID: "user_1", SongIDs: []string{"song_1", "song_2"}},
+				{ID: "playlist_1", UserID: "user_2", SongIDs: []string{"song_3"}},
+				{ID: "playlist_2", UserID: "user_2", SongIDs: []string{}, AllowDuplicates: true},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1", DurationMs: 180000},
+				{ID: "song_2", Artist: "some_other_artist", Title: "test_song_2", DurationMs: 240000},
+				{ID: "song_3", Artist: "another_artist", Title: "test_song_3", DurationMs: 300000},
+				{ID: "song_4", Artist: "another_artist", Title: "test_song_4"},
+			},
+		}
+	})
+
+	// randomChanges draws IDs from small pools, including ones that do not
+	// exist, so batches are full of dependent and invalid changes
+	randomChanges := func(r *rand.Rand, n int) *models.Changes {
+		pick := func(pool ...string) string {
+			return pool[r.Intn(len(pool))]
+		}
+		songs := func() []string {
+			songIDs := []string{}
+			for i := r.Intn(4); i > 0; i-- {
+				songIDs = append(songIDs, pick("song_1", "song_2", "song_3", "song_4", "song_x"))
+			}
+			return songIDs
+		}
+
+		changes := &models.Changes{}
+		for i := 0; i < n; i++ {
+			playlistID := fmt.Sprintf("playlist_%d", r.Intn(8))
+			if r.Intn(20) == 0 {
+				playlistID = ""
+			}
+			change := models.PlaylistChange{
+				ID: models.PlaylistChangeID(pick(string(models.Add), string(models.Remove), string(models.AddSongs),
+					string(models.AddCollaborators), string(models.RemoveCollaborators), string(models.TransferOwnership),
+					string(models.UpdatePlaylist), string(models.RemoveSongs), string(models.UpsertPlaylist), string(models.SetSongs))),
+				Playlist: models.Playlist{ID: playlistID},
+			}
+			if r.Intn(2) == 0 {
+				change.Actor = pick("user_1", "user_2", "admin", "")
+			}
+			// versions of the playlists only go up to a few in a batch
+			if r.Intn(4) == 0 {
+				version := int64(r.Intn(3))
+				change.IfMatch = &version
+			}
+			// keys repeat within a playlist, and some were seen in a previous
+			// run. Keys repeated across playlists are applied sequentially.
+			if r.Intn(2) == 0 {
+				change.IdempotencyKey = fmt.Sprintf("%s_key_%d", playlistID, r.Intn(4))
+			}
+			switch change.ID {
+			case models.Add, models.UpsertPlaylist:
+				change.Playlist.UserID = pick("user_1", "user_2", "user_x", "")
+				change.Playlist.SongIDs = songs()
+				change.Playlist.AllowDuplicates = r.Intn(2) == 0
+			case models.AddSongs, models.SetSongs:
+				change.Playlist.SongIDs = songs()
+			case models.AddCollaborators, models.RemoveCollaborators:
+				for i := r.Intn(3); i > 0; i-- {
+					change.Playlist.Collaborators = append(change.Playlist.Collaborators, models.Collaborator{
+						UserID: pick("user_1", "user_2", "user_x"),
+						Role:   models.Role(pick(string(models.Editor), string(models.Viewer), "")),
+					})
+				}
+			case models.TransferOwnership:
+				change.Playlist.UserID = pick("user_1", "user_2", "user_x", "")
+			case models.RemoveSongs:
+				change.Mode = models.RemoveMode(pick(string(models.RemoveFirst), string(models.RemoveAll), string(models.RemoveAt), ""))
+				if change.Mode == models.RemoveAt {
+					for i := r.Intn(3); i > 0; i-- {
+						change.Positions = append(change.Positions, r.Intn(5))
+					}
+				} else {
+					change.Playlist.SongIDs = songs()
+				}
+			case models.UpdatePlaylist:
+				change.Playlist.Name = pick("name_1", "name_2", "")
+				change.Playlist.Visibility = models.Visibility(pick(string(models.Public), string(models.Private), "hidden", ""))
+			}
+			changes.PlaylistChanges = append(changes.PlaylistChanges, change)
+		}
+		return changes
+	}
+
+	permissions := &policy.Policy{
+		Admins:        []string{"admin"},
+		Collaborators: map[string][]string{"playlist_1": {"user_1"}},
+	}
+
+	// small enough for batches to hit both
+	limits := mixtape_pkg.Limits{MaxSongs: 4, MaxDuration: 12 * time.Minute}
+
+	// a store as left by a previous run
+	previousRun := func() *dedupe.Store {
+		store := dedupe.New()
+		store.Record("playlist_0_key_0")
+		store.Record("playlist_1_key_1")
+		return store
+	}
+
+	Describe("Groups", func() {
+		It("should group changes by playlist ID in order of first appearance", func() {
+			changes := &models.Changes{PlaylistChanges: []models.PlaylistChange{
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_1"}},
+				{ID: models.Add, Playlist: models.Playlist{ID: "playlist_2"}},
+				{ID: models.Add, Playlist: models.Playlist{ID: "playlist_1"}},
+				{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_3"}},
+				{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_2"}},
+			}}
+			Expect(mixtape_pkg.Groups(changes)).To(Equal([][]int{{0, 2}, {1, 4}, {3}}))
+		})
+	})
+
+	Describe("ApplyChangesParallel", func() {
+		It("should reject less than one worker", func() {
+			m := mixtape_pkg.New(mixtape, util.Discard)
+			Expect(m.ApplyChangesParallel(&models.Changes{}, 0)).ToNot(Succeed())
+		})
+
+		It("should produce exactly the same state, applied changes, report, keys and logs as ApplyChanges", func() {
+			for seed := int64(0); seed < 100; seed++ {
+				changes := randomChanges(rand.New(rand.NewSource(seed)), 60)
+
+				sequentialMixtape, sequentialLogs := copyMixtape(mixtape), &bytes.Buffer{}
+				sequentialKeys := previousRun()
+				sequential := mixtape_pkg.New(sequentialMixtape, newTestLogger(sequentialLogs), mixtape_pkg.WithDedupe(sequentialKeys), mixtape_pkg.WithPolicy(permissions), mixtape_pkg.WithClock(testClock), mixtape_pkg.WithLimits(limits))
+				Expect(sequential.ApplyChanges(changes)).To(Succeed())
+
+				parallelMixtape, parallelLogs := copyMixtape(mixtape), &bytes.Buffer{}
+				parallelKeys := previousRun()
+				parallel := mixtape_pkg.New(parallelMixtape, newTestLogger(parallelLogs), mixtape_pkg.WithDedupe(parallelKeys), mixtape_pkg.WithPolicy(permissions), mixtape_pkg.WithClock(testClock), mixtape_pkg.WithLimits(limits))
+				Expect(parallel.ApplyChangesParallel(changes, 4)).To(Succeed())
+
+				Expect(parallelMixtape).To(Equal(sequentialMixtape), "seed %d", seed)
+				Expect(parallel.Index()).To(Equal(sequential.Index()), "seed %d", seed)
+				Expect(parallel.Index()).To(Equal(mixtape_pkg.BuildIndex(parallelMixtape)), "seed %d", seed)
+				Expect(parallel.Applied()).To(Equal(sequential.Applied()), "seed %d", seed)
+				Expect(parallel.Report()).To(Equal(sequential.Report()), "seed %d", seed)
+				Expect(parallelKeys.Keys()).To(Equal(sequentialKeys.Keys()), "seed %d", seed)
+				Expect(parallelLogs.String()).To(Equal(sequentialLogs.String()), "seed %d", seed)
+			}
+		})
+
+		It("should apply a batch with an auto_fill change sequentially", func() {
+			changes := &models.Changes{PlaylistChanges: []models.PlaylistChange{
+				{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_1", "song_4"}}},
+				{ID: models.AutoFill, Playlist: models.Playlist{ID: "playlist_0"}, Count: 2},
+			}}
+
+			sequentialMixtape := copyMixtape(mixtape)
+			sequential := mixtape_pkg.New(sequentialMixtape, util.Discard, mixtape_pkg.WithClock(testClock))
+			Expect(sequential.ApplyChanges(changes)).To(Succeed())
+
+			parallelMixtape := copyMixtape(mixtape)
+			parallel := mixtape_pkg.New(parallelMixtape, util.Discard, mixtape_pkg.WithClock(testClock))
+			Expect(parallel.ApplyChangesParallel(changes, 4)).To(Succeed())
+
+			// the recommendations depend on the songs added to playlist_1
+			Expect(parallelMixtape.Playlists[0].SongIDs).To(Equal([]string{"song_1", "song_2", "song_3", "song_4"}))
+			Expect(parallelMixtape).To(Equal(sequentialMixtape))
+			Expect(parallel.Applied()).To(Equal(sequential.Applied()))
+		})
+	})
+})
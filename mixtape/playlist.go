@@ -1,71 +1,125 @@
 package mixtape
 
-import "github.com/n4wei/highspot/models"
+import (
+	"time"
+
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/util"
+)
 
 // These methods intentionally always return nil (instead of an error)
 // because the UX design I chose is to skip invalid changes, log them,
-// and keep going.
+// and keep going. Skips are logged as warnings with the reason in a field.
+
+// Reasons a change, or a song in a change, is skipped
+const (
+	reasonPlaylistIDMissing     = "playlist_id missing"
+	reasonPlaylistExists        = "playlist_id already exists"
+	reasonPlaylistNotFound      = "playlist_id not found"
+	reasonUserIDMissing         = "user_id missing"
+	reasonUserNotInMixtape      = "user_id not in mixtape"
+	reasonNoSongs               = "playlist has no songs"
+	reasonNoSongsFromMixtape    = "playlist has no songs from mixtape"
+	reasonSongNotInMixtape      = "song_id not in mixtape"
+	reasonSongAlreadyInPlaylist = "song_id already in playlist"
+	reasonUnknownChange         = "unknown change id"
+	reasonNothingApplied        = "nothing to apply"
+	reasonDuplicateKey          = "idempotency_key already processed"
+	reasonVersionMismatch       = "if_match does not match playlist version"
+	reasonVisibilityInvalid     = "visibility must be public or private"
+	reasonMaxSongs              = "playlist would exceed max songs"
+	reasonMaxDuration           = "playlist would exceed max duration"
+	reasonNoSongsWithinLimits   = "playlist has no songs within limits"
+)
+
+// Messages logged by these methods, what varies goes in fields
+const (
+	msgSkippedChange   = "skipped change"
+	msgSkippedSong     = "skipped song"
+	msgAddedPlaylist   = "added playlist"
+	msgRemovedPlaylist = "removed playlist"
+	msgAddedSong       = "added song"
+	msgUpdatedPlaylist = "updated playlist"
+)
 
 // This method adds a new playlist to the playlist array.
 // It appends the new playlist to the end of the playlist array. A hash
 // map is used to store the index of the playlist in the array for constant
-// time access. If the new playlist has a user id that is not in mixtape,
-// the playlist is not added. Only songs that exist in the mixtape are
-// added with the new playlist. A playlist with 0 valid songs is not added.
+// time access. The new playlist starts at version 1 and is created now,
+// whatever version and timestamps it was given. If the new playlist has a
+// user id that is not in mixtape, the playlist is not added. Only songs that
+// exist in the mixtape, and fit within the limits, are added with the new
+// playlist, once each unless it allows duplicates. A playlist with 0 valid
+// songs is not added.
+// Collaborators that are not valid, see validCollaborator, are left out.
 
 // See tests in playlist_test.go for all invalid cases.
 
 // runtime: O(s), s is the number of songs in the added playlist
 // space: O(s), creates a map to store which songs belong to this playlist for
 // constant time access
-func (m *Mixtape) addPlaylist(playlist models.Playlist) error {
-	m.logger.SetPrefix("[AddPlaylist] ")
-
+func (m *Mixtape) addPlaylist(c *call, playlist models.Playlist) error {
 	id := playlist.ID
 	if id == "" {
-		m.logger.Printf("playlist_id missing, skipping\n")
+		c.skip(reasonPlaylistIDMissing)
 		return nil
 	}
 	if _, exist := m.lookup.playlists[id]; exist {
-		m.logger.Printf("playlist_id %s already exists, skipping\n", id)
+		c.skip(reasonPlaylistExists)
 		return nil
 	}
 	if playlist.UserID == "" {
-		m.logger.Printf("user_id missing, from playlist_id %s, skipping\n", id)
+		c.skip(reasonUserIDMissing)
 		return nil
 	}
 	if _, exist := m.lookup.users[playlist.UserID]; !exist {
-		m.logger.Printf("user_id %s not in mixtape, from playlist_id %s, skipping\n", playlist.UserID, id)
+		c.skip(reasonUserNotInMixtape, util.UserID(playlist.UserID))
 		return nil
 	}
 	if len(playlist.SongIDs) == 0 {
-		m.logger.Printf("playlist_id %s does not contain any songs, skipping\n", id)
+		c.skip(reasonNoSongs)
 		return nil
 	}
-
-	validSongIDs := []string{}
-	for _, songID := range playlist.SongIDs {
-		if _, exist := m.lookup.songs[songID]; exist {
-			if m.lookup.playlistSongs[id] == nil {
-				m.lookup.playlistSongs[id] = map[string]bool{}
-			}
-			m.lookup.playlistSongs[id][songID] = true
-			validSongIDs = append(validSongIDs, songID)
-		} else {
-			m.logger.Printf("song_id %s not in mixtape, from playlist_id %s, skipping\n", songID, id)
-		}
+	if !validVisibility(playlist.Visibility) {
+		c.skip(reasonVisibilityInvalid)
+		return nil
 	}
 
-	if len(validSongIDs) == 0 {
-		m.logger.Printf("playlist_id %s does not contain any songs from mixtape, skipping\n", id)
+	validSongIDs, ok := m.validSongs(c, playlist)
+	if !ok {
 		return nil
 	}
-
 	playlist.SongIDs = validSongIDs
-	m.mixtape.Playlists = append(m.mixtape.Playlists, playlist)
-	m.lookup.playlists[id] = len(m.mixtape.Playlists) - 1
 
-	m.logger.Printf("added playlist_id %s\n", id)
+	// collaborators are optional, invalid ones are left out
+	collaborators := []models.Collaborator{}
+	seen := map[string]bool{}
+	for _, collaborator := range playlist.Collaborators {
+		if !m.validCollaborator(c, playlist.UserID, collaborator) {
+			continue
+		}
+		if seen[collaborator.UserID] {
+			c.skipCollaborator(collaborator.UserID, reasonAlreadyCollaborator)
+			continue
+		}
+		seen[collaborator.UserID] = true
+		collaborators = append(collaborators, collaborator)
+	}
+	playlist.Collaborators = nil
+	if len(collaborators) > 0 {
+		playlist.Collaborators = collaborators
+	}
+	// the version and timestamps in the change are ignored, and kept out
+	// of the applied change
+	playlist.Version = 0
+	playlist.CreatedAt = time.Time{}
+	playlist.UpdatedAt = time.Time{}
+	// copied, since collaborators are changed in place
+	m.applied = append(m.applied, models.PlaylistChange{ID: models.Add, Playlist: copyPlaylist(playlist)})
+	m.stamp(&playlist)
+	m.insertPlaylist(playlist)
+
+	c.logger.Info(msgAddedPlaylist)
 	return nil
 }
 
@@ -78,77 +132,264 @@ func (m *Mixtape) addPlaylist(playlist models.Playlist) error {
 
 // See tests in playlist_test.go for all invalid cases.
 
-// runtime: O(1)
+// runtime: O(s), s is the number of songs in the playlist
 // space: no additional space
-func (m *Mixtape) removePlaylist(playlist models.Playlist) error {
-	m.logger.SetPrefix("[RemovePlaylist] ")
-
+func (m *Mixtape) removePlaylist(c *call, playlist models.Playlist) error {
 	id := playlist.ID
 	if id == "" {
-		m.logger.Printf("playlist_id missing, skipping\n")
+		c.skip(reasonPlaylistIDMissing)
 		return nil
 	}
 
 	i, exist := m.lookup.playlists[id]
 	if !exist {
-		m.logger.Printf("playlist_id %s not found, skipping\n", id)
+		c.skip(reasonPlaylistNotFound)
 		return nil
 	}
 
-	playlists := m.mixtape.Playlists
-	l := len(playlists)
-	if i != l-1 {
-		playlists[i], playlists[l-1] = playlists[l-1], playlists[i]
-		m.lookup.playlists[playlists[i].ID] = i
-	}
+	m.deletePlaylist(i)
+	m.applied = append(m.applied, models.PlaylistChange{ID: models.Remove, Playlist: models.Playlist{ID: id}})
 
-	m.mixtape.Playlists = playlists[:l-1]
-	delete(m.lookup.playlists, id)
-	delete(m.lookup.playlistSongs, id)
-
-	m.logger.Printf("removed playlist_id %s\n", id)
+	c.logger.Info(msgRemovedPlaylist)
 	return nil
 }
 
 // This method adds one or more existing songs in mixtape to an existing
 // playlist in mixtape.
 // Songs are appended to the end of the playlist's list of songs. If a song
-// does not exist in the mixtape or is already in the playlist, it is not added.
+// does not exist in the mixtape, is already in a playlist that does not
+// allow duplicates, or would take the playlist over a limit, it is not
+// added. Later songs of the change may still fit, eg. shorter ones.
 
 // See tests in playlist_test.go for all invalid cases.
 
 // runtime: O(s), s is the number of songs being added
 // space: creates a new entry for each valid song in the lookup hash map
 // for songs belonging to this playlist
-func (m *Mixtape) addSongsToPlaylist(playlist models.Playlist) error {
-	m.logger.SetPrefix("[AddSongToPlaylist] ")
-
+func (m *Mixtape) addSongsToPlaylist(c *call, playlist models.Playlist) error {
 	id := playlist.ID
 	if id == "" {
-		m.logger.Printf("playlist_id missing, skipping\n")
+		c.skip(reasonPlaylistIDMissing)
 		return nil
 	}
 
 	i, exist := m.lookup.playlists[id]
 	if !exist {
-		m.logger.Printf("playlist_id %s not found, skipping\n", id)
+		c.skip(reasonPlaylistNotFound)
 		return nil
 	}
 
+	added := []string{}
 	for _, songID := range playlist.SongIDs {
 		if _, exist = m.lookup.songs[songID]; !exist {
-			m.logger.Printf("song_id %s not in mixtape, not added to playlist_id %s, skipping\n", songID, id)
+			c.skipSong(songID, reasonSongNotInMixtape)
+			continue
+		}
+		if m.lookup.playlistSongs[id][songID] > 0 && !m.allowsDuplicates(m.mixtape.Playlists[i]) {
+			c.skipSong(songID, reasonSongAlreadyInPlaylist)
 			continue
 		}
-		if _, exist = m.lookup.playlistSongs[id][songID]; exist {
-			m.logger.Printf("song_id %s already in playlist_id %s, skipping\n", songID, id)
+		songs := len(m.mixtape.Playlists[i].SongIDs) + 1
+		if reason := m.limits.exceeded(songs, m.lookup.durations[id]+m.songDuration(songID)); reason != "" {
+			c.skipSong(songID, reason)
 			continue
 		}
 
-		m.mixtape.Playlists[i].SongIDs = append(m.mixtape.Playlists[i].SongIDs, songID)
-		m.lookup.playlistSongs[id][songID] = true
-		m.logger.Printf("added song_id %s to playlist_id %s\n", songID, id)
+		m.appendSong(i, songID)
+		added = append(added, songID)
+		c.logger.Info(msgAddedSong, util.SongID(songID))
 	}
 
+	if len(added) > 0 {
+		m.touch(i)
+		m.applied = append(m.applied, models.PlaylistChange{
+			ID:       models.AddSongs,
+			Playlist: models.Playlist{ID: id, SongIDs: added},
+		})
+	}
 	return nil
 }
+
+// This method changes the name, description and visibility of an existing
+// playlist. Fields that are empty in the change, or already have the given
+// value, are left unchanged, and only the changed ones are recorded as
+// applied.
+// runtime: O(1)
+func (m *Mixtape) updatePlaylist(c *call, playlist models.Playlist) error {
+	i, ok := m.existingPlaylist(c, playlist)
+	if !ok {
+		return nil
+	}
+	if !validVisibility(playlist.Visibility) {
+		c.skip(reasonVisibilityInvalid)
+		return nil
+	}
+
+	existing := &m.mixtape.Playlists[i]
+	updated := models.Playlist{ID: existing.ID}
+	if playlist.Name != "" && playlist.Name != existing.Name {
+		existing.Name = playlist.Name
+		updated.Name = playlist.Name
+	}
+	if playlist.Description != "" && playlist.Description != existing.Description {
+		existing.Description = playlist.Description
+		updated.Description = playlist.Description
+	}
+	// a playlist without a visibility is public
+	if playlist.Visibility != "" && playlist.IsPublic() != existing.IsPublic() {
+		existing.Visibility = playlist.Visibility
+		updated.Visibility = playlist.Visibility
+	}
+
+	if updated.Name != "" || updated.Description != "" || updated.Visibility != "" {
+		m.touch(i)
+		m.applied = append(m.applied, models.PlaylistChange{ID: models.UpdatePlaylist, Playlist: updated})
+		c.logger.Info(msgUpdatedPlaylist)
+	}
+	return nil
+}
+
+// validSongs returns the songs of the playlist that exist in the mixtape,
+// once each unless it allows duplicates, and that fit within the limits
+// counted from no songs, since they are all of the playlist's songs. The
+// change is skipped if none are left.
+// runtime: O(s), s is the number of songs in the playlist
+func (m *Mixtape) validSongs(c *call, playlist models.Playlist) ([]string, bool) {
+	validSongIDs := []string{}
+	valid := map[string]bool{}
+	var duration time.Duration
+	limited := false
+	for _, songID := range playlist.SongIDs {
+		if _, exist := m.lookup.songs[songID]; !exist {
+			c.skipSong(songID, reasonSongNotInMixtape)
+			continue
+		}
+		if valid[songID] && !m.allowsDuplicates(playlist) {
+			c.skipSong(songID, reasonSongAlreadyInPlaylist)
+			continue
+		}
+		songDuration := m.songDuration(songID)
+		if reason := m.limits.exceeded(len(validSongIDs)+1, duration+songDuration); reason != "" {
+			c.skipSong(songID, reason)
+			limited = true
+			continue
+		}
+		validSongIDs = append(validSongIDs, songID)
+		valid[songID] = true
+		duration += songDuration
+	}
+
+	if len(validSongIDs) == 0 {
+		if limited {
+			c.skip(reasonNoSongsWithinLimits)
+		} else {
+			c.skip(reasonNoSongsFromMixtape)
+		}
+		return nil, false
+	}
+	return validSongIDs, true
+}
+
+// exceeded returns why a playlist with the given number of songs and
+// duration would be over the limits, or "" if it is within them.
+func (l Limits) exceeded(songs int, duration time.Duration) string {
+	if l.MaxSongs > 0 && songs > l.MaxSongs {
+		return reasonMaxSongs
+	}
+	if l.MaxDuration > 0 && duration > l.MaxDuration {
+		return reasonMaxDuration
+	}
+	return ""
+}
+
+// allowsDuplicates tells whether the same song can appear more than once
+// in the playlist.
+func (m *Mixtape) allowsDuplicates(playlist models.Playlist) bool {
+	return m.mixtape.AllowDuplicates || playlist.AllowDuplicates
+}
+
+func validVisibility(visibility models.Visibility) bool {
+	return visibility == "" || visibility == models.Public || visibility == models.Private
+}
+
+// The methods below change the playlist array and keep the lookup hash
+// maps consistent with it. They do not validate anything, callers do.
+
+// stamp sets the version and timestamps of a new playlist.
+func (m *Mixtape) stamp(playlist *models.Playlist) {
+	playlist.Version = 1
+	playlist.CreatedAt = m.now
+	playlist.UpdatedAt = m.now
+}
+
+// touch bumps the version and update time of the playlist at index i,
+// once per change to it.
+func (m *Mixtape) touch(i int) {
+	m.mixtape.Playlists[i].Version++
+	m.mixtape.Playlists[i].UpdatedAt = m.now
+}
+
+// runtime: O(s), s is the number of songs in the playlist
+func (m *Mixtape) insertPlaylist(playlist models.Playlist) {
+	m.mixtape.Playlists = append(m.mixtape.Playlists, playlist)
+	m.lookup.playlists[playlist.ID] = len(m.mixtape.Playlists) - 1
+
+	songs := map[string]int{}
+	for _, songID := range playlist.SongIDs {
+		songs[songID]++
+	}
+	m.lookup.playlistSongs[playlist.ID] = songs
+	var duration time.Duration
+	for _, songID := range playlist.SongIDs {
+		duration += m.songDuration(songID)
+		addTo(m.lookup.songPlaylists, songID, playlist.ID)
+	}
+	m.lookup.durations[playlist.ID] = duration
+	addTo(m.lookup.owned, playlist.UserID, playlist.ID)
+	m.grantEditors(playlist)
+}
+
+// Swaps the playlist at index i with the last one and reslices, see
+// removePlaylist.
+// runtime: O(s), s is the number of songs in the playlist, to unindex them
+func (m *Mixtape) deletePlaylist(i int) {
+	playlists := m.mixtape.Playlists
+	id := playlists[i].ID
+	m.revokeEditors(playlists[i])
+	removeFrom(m.lookup.owned, playlists[i].UserID, id)
+	for songID := range m.lookup.playlistSongs[id] {
+		removeFrom(m.lookup.songPlaylists, songID, id)
+	}
+	l := len(playlists)
+	if i != l-1 {
+		playlists[i], playlists[l-1] = playlists[l-1], playlists[i]
+		m.lookup.playlists[playlists[i].ID] = i
+	}
+
+	m.mixtape.Playlists = playlists[:l-1]
+	delete(m.lookup.playlists, id)
+	delete(m.lookup.playlistSongs, id)
+	delete(m.lookup.durations, id)
+}
+
+// runtime: O(1)
+func (m *Mixtape) appendSong(i int, songID string) {
+	playlist := &m.mixtape.Playlists[i]
+	playlist.SongIDs = append(playlist.SongIDs, songID)
+	if m.lookup.playlistSongs[playlist.ID] == nil {
+		m.lookup.playlistSongs[playlist.ID] = map[string]int{}
+	}
+	m.lookup.playlistSongs[playlist.ID][songID]++
+	m.lookup.durations[playlist.ID] += m.songDuration(songID)
+	addTo(m.lookup.songPlaylists, songID, playlist.ID)
+}
+
+// songDuration is 0 for songs without a duration, or not in mixtape.
+// runtime: O(1)
+func (m *Mixtape) songDuration(songID string) time.Duration {
+	j, exist := m.lookup.songs[songID]
+	if !exist {
+		return 0
+	}
+	return m.mixtape.Songs[j].Duration()
+}
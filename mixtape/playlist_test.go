@@ -2,15 +2,38 @@ package mixtape_test
 
 import (
 	"io"
-	"log"
+	"log/slog"
+	"time"
 
 	mixtape_pkg "github.com/n4wei/highspot/mixtape"
 	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/util"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/onsi/gomega/gbytes"
 )
 
+// testClock stamps playlists with testTime, see mixtape_pkg.WithClock
+var testTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func testClock() time.Time {
+	return testTime
+}
+
+// newTestLogger logs text without timestamps, at every level, eg.
+// level=WARN msg="skipped change" change=add change_index=0 playlist_id=p reason="..."
+func newTestLogger(w io.Writer) util.Logger {
+	return util.NewSlogLogger(slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	})))
+}
+
 var _ = Describe("Playlist Changes", func() {
 	var (
 		mixtape *models.Mixtape
@@ -77,7 +100,7 @@ var _ = Describe("Playlist Changes", func() {
 		changes = &models.Changes{}
 
 		testOutput = gbytes.NewBuffer()
-		testMixtape = mixtape_pkg.New(mixtape, log.New(testOutput, "", 0))
+		testMixtape = mixtape_pkg.New(mixtape, newTestLogger(testOutput), mixtape_pkg.WithClock(testClock))
 	})
 
 	JustBeforeEach(func() {
@@ -101,7 +124,7 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should not add the playlist, output a log, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("playlist_id missing, skipping"))
+				Expect(testOutput).To(gbytes.Say(`playlist_id="" reason="playlist_id missing"`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 			})
@@ -123,7 +146,7 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should not add the playlist, output a log, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("playlist_id playlist_1 already exists, skipping"))
+				Expect(testOutput).To(gbytes.Say(`playlist_id=playlist_1 reason="playlist_id already exists"`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 			})
@@ -145,7 +168,7 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should not add the playlist, output a log, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("user_id missing, from playlist_id playlist_x, skipping"))
+				Expect(testOutput).To(gbytes.Say(`playlist_id=playlist_x reason="user_id missing"`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 			})
@@ -167,7 +190,7 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should not add the playlist, output a log, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("user_id user_x not in mixtape, from playlist_id playlist_x, skipping"))
+				Expect(testOutput).To(gbytes.Say(`playlist_id=playlist_x user_id=user_x reason="user_id not in mixtape"`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 			})
@@ -189,7 +212,7 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should not add the playlist, output a log, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("playlist_id playlist_x does not contain any songs, skipping"))
+				Expect(testOutput).To(gbytes.Say(`playlist_id=playlist_x reason="playlist has no songs"`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 			})
@@ -211,14 +234,17 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should not add non-existant song, add the playlist, output logs, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("song_id song_x not in mixtape, from playlist_id playlist_x, skipping"))
-				Expect(testOutput).To(gbytes.Say("added playlist_id playlist_x"))
+				Expect(testOutput).To(gbytes.Say(`msg="skipped song" .* playlist_id=playlist_x song_id=song_x reason="song_id not in mixtape"`))
+				Expect(testOutput).To(gbytes.Say(`msg="added playlist" .* playlist_id=playlist_x`))
 
 				Expect(mixtape.Playlists).To(HaveLen(3))
 				Expect(mixtape.Playlists[2]).To(BeEquivalentTo(models.Playlist{
-					ID:      "playlist_x",
-					UserID:  "user_1",
-					SongIDs: []string{"song_1"},
+					ID:        "playlist_x",
+					UserID:    "user_1",
+					SongIDs:   []string{"song_1"},
+					Version:   1,
+					CreatedAt: testTime,
+					UpdatedAt: testTime,
 				}))
 			})
 		})
@@ -239,14 +265,36 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should not add the playlist, output logs, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("song_id song_x not in mixtape, from playlist_id playlist_x, skipping"))
-				Expect(testOutput).To(gbytes.Say("song_id song_y not in mixtape, from playlist_id playlist_x, skipping"))
-				Expect(testOutput).To(gbytes.Say("playlist_id playlist_x does not contain any songs from mixtape, skipping"))
+				Expect(testOutput).To(gbytes.Say(`msg="skipped song" .* playlist_id=playlist_x song_id=song_x reason="song_id not in mixtape"`))
+				Expect(testOutput).To(gbytes.Say(`msg="skipped song" .* playlist_id=playlist_x song_id=song_y reason="song_id not in mixtape"`))
+				Expect(testOutput).To(gbytes.Say(`playlist_id=playlist_x reason="playlist has no songs from mixtape"`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 			})
 		})
 
+		Context("when the new playlist has a visibility other than public or private", func() {
+			BeforeEach(func() {
+				changes.PlaylistChanges = []models.PlaylistChange{
+					{
+						ID: models.Add,
+						Playlist: models.Playlist{
+							ID:         "playlist_x",
+							UserID:     "user_1",
+							SongIDs:    []string{"song_1"},
+							Visibility: "hidden",
+						},
+					},
+				}
+			})
+
+			It("should not add the playlist, output a log, and continue", func() {
+				Expect(applyChangesErr).ToNot(HaveOccurred())
+				Expect(testOutput).To(gbytes.Say(`msg="skipped change" .* playlist_id=playlist_x reason="visibility must be public or private"`))
+				Expect(mixtape.Playlists).To(HaveLen(2))
+			})
+		})
+
 		Context("when adding a valid playlist", func() {
 			BeforeEach(func() {
 				changes.PlaylistChanges = []models.PlaylistChange{
@@ -263,13 +311,16 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should add the playlist, output a log, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("added playlist_id playlist_x"))
+				Expect(testOutput).To(gbytes.Say(`msg="added playlist" .* playlist_id=playlist_x`))
 
 				Expect(mixtape.Playlists).To(HaveLen(3))
 				Expect(mixtape.Playlists[2]).To(BeEquivalentTo(models.Playlist{
-					ID:      "playlist_x",
-					UserID:  "user_1",
-					SongIDs: []string{"song_1", "song_2"},
+					ID:        "playlist_x",
+					UserID:    "user_1",
+					SongIDs:   []string{"song_1", "song_2"},
+					Version:   1,
+					CreatedAt: testTime,
+					UpdatedAt: testTime,
 				}))
 			})
 		})
@@ -290,7 +341,7 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should not remove any playlist, output a log, and continues", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("playlist_id missing, skipping"))
+				Expect(testOutput).To(gbytes.Say(`playlist_id="" reason="playlist_id missing"`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 			})
@@ -310,7 +361,7 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should not remove any playlist, output a log, and continues", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("playlist_id playlist_x not found, skipping"))
+				Expect(testOutput).To(gbytes.Say(`playlist_id=playlist_x reason="playlist_id not found"`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 			})
@@ -330,7 +381,7 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should remove the playlist, output a log, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("removed playlist_id playlist_2"))
+				Expect(testOutput).To(gbytes.Say(`msg="removed playlist" .* playlist_id=playlist_2`))
 
 				Expect(mixtape.Playlists).To(HaveLen(1))
 				Expect(mixtape.Playlists[0].ID).To(Equal("playlist_1"))
@@ -354,7 +405,7 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should not add any songs, output a log, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("playlist_id missing, skipping"))
+				Expect(testOutput).To(gbytes.Say(`playlist_id="" reason="playlist_id missing"`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 				Expect(mixtape.Playlists[0].SongIDs).To(HaveLen(2))
@@ -377,7 +428,7 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should not add any songs, output a log, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("playlist_id playlist_x not found, skipping"))
+				Expect(testOutput).To(gbytes.Say(`playlist_id=playlist_x reason="playlist_id not found"`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 				Expect(mixtape.Playlists[0].SongIDs).To(HaveLen(2))
@@ -400,7 +451,7 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should not add any songs, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).ToNot(gbytes.Say("added song_id"))
+				Expect(testOutput).ToNot(gbytes.Say(`msg="added song"`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 				Expect(mixtape.Playlists[1].SongIDs).To(HaveLen(1))
@@ -422,7 +473,7 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should not add these songs, output logs, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("song_id song_x not in mixtape, not added to playlist_id playlist_2, skipping"))
+				Expect(testOutput).To(gbytes.Say(`playlist_id=playlist_2 song_id=song_x reason="song_id not in mixtape"`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 				Expect(mixtape.Playlists[1].SongIDs).To(HaveLen(1))
@@ -444,7 +495,7 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should not add these songs, output logs, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("song_id song_3 already in playlist_id playlist_2, skipping"))
+				Expect(testOutput).To(gbytes.Say(`playlist_id=playlist_2 song_id=song_3 reason="song_id already in playlist"`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 				Expect(mixtape.Playlists[1].SongIDs).To(HaveLen(1))
@@ -466,8 +517,8 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should add them to the playlist, output logs, and continue", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("added song_id song_2 to playlist_id playlist_2"))
-				Expect(testOutput).To(gbytes.Say("added song_id song_1 to playlist_id playlist_2"))
+				Expect(testOutput).To(gbytes.Say(`msg="added song" .* playlist_id=playlist_2 song_id=song_2`))
+				Expect(testOutput).To(gbytes.Say(`msg="added song" .* playlist_id=playlist_2 song_id=song_1`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 				Expect(mixtape.Playlists[1].ID).To(Equal("playlist_2"))
@@ -492,7 +543,7 @@ var _ = Describe("Playlist Changes", func() {
 
 			It("should ignored user ID", func() {
 				Expect(applyChangesErr).ToNot(HaveOccurred())
-				Expect(testOutput).To(gbytes.Say("added song_id song_3 to playlist_id playlist_1"))
+				Expect(testOutput).To(gbytes.Say(`msg="added song" .* playlist_id=playlist_1 song_id=song_3`))
 
 				Expect(mixtape.Playlists).To(HaveLen(2))
 				Expect(mixtape.Playlists[0].ID).To(Equal("playlist_1"))
@@ -501,6 +552,109 @@ var _ = Describe("Playlist Changes", func() {
 		})
 	})
 
+	Describe("updatePlaylist", func() {
+		Context("when the playlist ID does not exist in mixtape", func() {
+			BeforeEach(func() {
+				changes.PlaylistChanges = []models.PlaylistChange{
+					{
+						ID:       models.UpdatePlaylist,
+						Playlist: models.Playlist{ID: "playlist_x", Name: "new name"},
+					},
+				}
+			})
+
+			It("should not update any playlist, output a log, and continue", func() {
+				Expect(applyChangesErr).ToNot(HaveOccurred())
+				Expect(testOutput).To(gbytes.Say(`msg="skipped change" .* playlist_id=playlist_x`))
+				Expect(testMixtape.Applied()).To(BeEmpty())
+			})
+		})
+
+		Context("when the visibility is not public or private", func() {
+			BeforeEach(func() {
+				changes.PlaylistChanges = []models.PlaylistChange{
+					{
+						ID:       models.UpdatePlaylist,
+						Playlist: models.Playlist{ID: "playlist_1", Name: "new name", Visibility: "hidden"},
+					},
+				}
+			})
+
+			It("should not update the playlist, output a log, and continue", func() {
+				Expect(applyChangesErr).ToNot(HaveOccurred())
+				Expect(testOutput).To(gbytes.Say(`msg="skipped change" .* reason="visibility must be public or private"`))
+				Expect(mixtape.Playlists[0].Name).To(BeEmpty())
+				Expect(mixtape.Playlists[0].Version).To(BeZero())
+			})
+		})
+
+		Context("when the fields are empty or already have the given values", func() {
+			BeforeEach(func() {
+				changes.PlaylistChanges = []models.PlaylistChange{
+					{
+						ID:       models.UpdatePlaylist,
+						Playlist: models.Playlist{ID: "playlist_1", Visibility: models.Public},
+					},
+				}
+			})
+
+			It("should leave the playlist unchanged, and report nothing to apply", func() {
+				Expect(applyChangesErr).ToNot(HaveOccurred())
+				Expect(mixtape.Playlists[0].Version).To(BeZero())
+				Expect(mixtape.Playlists[0].UpdatedAt).To(BeZero())
+				Expect(testMixtape.Report().Entries[0].Reason).To(Equal("nothing to apply"))
+			})
+		})
+
+		Context("when the playlist is given new values", func() {
+			BeforeEach(func() {
+				changes.PlaylistChanges = []models.PlaylistChange{
+					{
+						ID: models.UpdatePlaylist,
+						Playlist: models.Playlist{
+							ID:          "playlist_1",
+							UserID:      "user_2",
+							Name:        "new name",
+							Description: "new description",
+							Visibility:  models.Private,
+							SongIDs:     []string{"song_3"},
+						},
+					},
+					{
+						ID:       models.UpdatePlaylist,
+						Playlist: models.Playlist{ID: "playlist_1", Name: "new name", Visibility: models.Public},
+					},
+				}
+			})
+
+			It("should update only the editable fields, bump the version and update time, and record what changed", func() {
+				Expect(applyChangesErr).ToNot(HaveOccurred())
+				Expect(testOutput).To(gbytes.Say(`msg="updated playlist" .* playlist_id=playlist_1`))
+
+				Expect(mixtape.Playlists[0]).To(Equal(models.Playlist{
+					ID:          "playlist_1",
+					UserID:      "user_1",
+					SongIDs:     []string{"song_1", "song_2"},
+					Name:        "new name",
+					Description: "new description",
+					Visibility:  models.Public,
+					Version:     2,
+					UpdatedAt:   testTime,
+				}))
+				Expect(testMixtape.Applied()).To(Equal([]models.PlaylistChange{
+					{
+						ID:       models.UpdatePlaylist,
+						Playlist: models.Playlist{ID: "playlist_1", Name: "new name", Description: "new description", Visibility: models.Private},
+					},
+					{
+						ID:       models.UpdatePlaylist,
+						Playlist: models.Playlist{ID: "playlist_1", Visibility: models.Public},
+					},
+				}))
+			})
+		})
+	})
+
 	Describe("composite changes", func() {
 		Context("add, update", func() {
 			BeforeEach(func() {
@@ -528,9 +682,12 @@ var _ = Describe("Playlist Changes", func() {
 
 				Expect(mixtape.Playlists).To(HaveLen(3))
 				Expect(mixtape.Playlists[2]).To(BeEquivalentTo(models.Playlist{
-					ID:      "playlist_x",
-					UserID:  "user_1",
-					SongIDs: []string{"song_3", "song_1"},
+					ID:        "playlist_x",
+					UserID:    "user_1",
+					SongIDs:   []string{"song_3", "song_1"},
+					Version:   2,
+					CreatedAt: testTime,
+					UpdatedAt: testTime,
 				}))
 			})
 		})
@@ -654,6 +811,9 @@ var _ = Describe("Playlist Changes", func() {
 					SongIDs: []string{
 						"song_3",
 					},
+					Version:   1,
+					CreatedAt: testTime,
+					UpdatedAt: testTime,
 				}))
 				Expect(mixtape.Playlists[1]).To(BeEquivalentTo(models.Playlist{
 					ID:     "playlist_2",
@@ -662,23 +822,93 @@ var _ = Describe("Playlist Changes", func() {
 						"song_3",
 						"song_1",
 					},
+					Version:   1,
+					UpdatedAt: testTime,
 				}))
 				Expect(mixtape.Playlists[2]).To(BeEquivalentTo(models.Playlist{
-					ID:      "playlist_x",
-					UserID:  "user_1",
-					SongIDs: []string{"song_1"},
+					ID:        "playlist_x",
+					UserID:    "user_1",
+					SongIDs:   []string{"song_1"},
+					Version:   1,
+					CreatedAt: testTime,
+					UpdatedAt: testTime,
 				}))
 				Expect(mixtape.Playlists[3]).To(BeEquivalentTo(models.Playlist{
-					ID:      "playlist_y",
-					UserID:  "user_2",
-					SongIDs: []string{"song_2"},
+					ID:        "playlist_y",
+					UserID:    "user_2",
+					SongIDs:   []string{"song_2"},
+					Version:   1,
+					CreatedAt: testTime,
+					UpdatedAt: testTime,
 				}))
 				Expect(mixtape.Playlists[4]).To(BeEquivalentTo(models.Playlist{
-					ID:      "playlist_e",
-					UserID:  "user_1",
-					SongIDs: []string{"song_3"},
+					ID:        "playlist_e",
+					UserID:    "user_1",
+					SongIDs:   []string{"song_3"},
+					Version:   1,
+					CreatedAt: testTime,
+					UpdatedAt: testTime,
 				}))
 			})
 		})
 	})
+
+	Describe("report", func() {
+		BeforeEach(func() {
+			changes.PlaylistChanges = []models.PlaylistChange{
+				{
+					ID:            models.AddSongs,
+					Playlist:      models.Playlist{ID: "playlist_2", SongIDs: []string{"song_x", "song_1"}},
+					CorrelationID: "request-1",
+				},
+				{
+					ID:       models.Remove,
+					Playlist: models.Playlist{ID: "playlist_x"},
+					Source:   "changes.json:12",
+				},
+				{
+					ID:       models.AddSongs,
+					Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_1"}},
+				},
+			}
+		})
+
+		It("should have an entry per change with its correlation ID", func() {
+			Expect(applyChangesErr).ToNot(HaveOccurred())
+			Expect(testMixtape.Report().Entries).To(Equal([]models.ReportEntry{
+				{
+					ChangeIndex:   0,
+					CorrelationID: "request-1",
+					Change:        models.AddSongs,
+					PlaylistID:    "playlist_2",
+					Status:        models.Applied,
+					Version:       1,
+					SkippedSongs:  []models.SkippedSong{{SongID: "song_x", Reason: "song_id not in mixtape"}},
+				},
+				{
+					ChangeIndex:   1,
+					CorrelationID: "changes.json:12",
+					Source:        "changes.json:12",
+					Change:        models.Remove,
+					PlaylistID:    "playlist_x",
+					Status:        models.Skipped,
+					Reason:        "playlist_id not found",
+				},
+				{
+					ChangeIndex:  2,
+					Change:       models.AddSongs,
+					PlaylistID:   "playlist_1",
+					Status:       models.Skipped,
+					Reason:       "nothing to apply",
+					SkippedSongs: []models.SkippedSong{{SongID: "song_1", Reason: "song_id already in playlist"}},
+				},
+			}))
+		})
+
+		It("should log the correlation ID with every line of a change", func() {
+			Expect(testOutput).To(gbytes.Say(`change_index=0 correlation_id=request-1 playlist_id=playlist_2 song_id=song_x`))
+			Expect(testOutput).To(gbytes.Say(`change_index=0 correlation_id=request-1 playlist_id=playlist_2 song_id=song_1`))
+			Expect(testOutput).To(gbytes.Say(`change_index=1 correlation_id=changes.json:12 playlist_id=playlist_x`))
+		})
+	})
 })
@@ -0,0 +1,53 @@
+package mixtape_test
+
+import (
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/policy"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Authorization", func() {
+	var (
+		mixtape *models.Mixtape
+		logs    *gbytes.Buffer
+		m       *mixtape_pkg.Mixtape
+	)
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{
+				{ID: "user_1", Name: "test_user_1"},
+				{ID: "user_2", Name: "test_user_2"},
+			},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_1"}},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1"},
+				{ID: "song_2", Artist: "some_other_artist", Title: "test_song_2"},
+			},
+		}
+		logs = gbytes.NewBuffer()
+		m = mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithPolicy(&policy.Policy{}))
+	})
+
+	It("should only let users change their own playlists", func() {
+		err := m.ApplyChanges(&models.Changes{PlaylistChanges: []models.PlaylistChange{
+			{ID: models.AddSongs, Actor: "user_2", Playlist: models.Playlist{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_2"}}},
+			{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_1"}},
+			{ID: models.AddSongs, Actor: "user_1", Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_2"}}},
+		}})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"song_1", "song_2"}))
+		entries := m.Report().Entries
+		Expect(entries[0].Actor).To(Equal("user_2"))
+		Expect(entries[0].Reason).To(Equal(policy.ErrNotCollaborator.Error()))
+		Expect(entries[1].Reason).To(Equal(policy.ErrActorMissing.Error()))
+		Expect(entries[2].Status).To(Equal(models.Applied))
+		Expect(logs).To(gbytes.Say(`actor=user_2 playlist_id=playlist_1 reason="unauthorized: actor neither owns nor edits the playlist"`))
+	})
+})
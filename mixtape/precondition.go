@@ -0,0 +1,88 @@
+package mixtape
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/util"
+)
+
+const reasonPreconditionInvalid = "preconditions must have max_songs of at least 0 and on_failure skip or fail"
+
+// ErrPreconditionFailed is wrapped by the errors of the preconditions that
+// fail, whose messages are used as skip reasons, see failedPrecondition.
+// When the failure action is models.FailBatch, the batch stops with the
+// error after applying the changes before it.
+var (
+	ErrPreconditionFailed = errors.New("precondition failed")
+	errPlaylistNotExists  = fmt.Errorf("%w: playlist does not exist", ErrPreconditionFailed)
+	errPlaylistExists     = fmt.Errorf("%w: playlist exists", ErrPreconditionFailed)
+	errNotOwnedBy         = fmt.Errorf("%w: playlist not owned by user_id", ErrPreconditionFailed)
+	errSongMissing        = fmt.Errorf("%w: song_id not in playlist", ErrPreconditionFailed)
+	errTooManySongs       = fmt.Errorf("%w: playlist has more than max_songs", ErrPreconditionFailed)
+)
+
+// WithPreconditionFailure sets what happens when a change's precondition
+// fails, unless the change sets it itself. Changes are skipped without it.
+func WithPreconditionFailure(action models.FailureAction) Option {
+	return func(m *Mixtape) {
+		m.onFailure = action
+	}
+}
+
+// preconditions skips a change whose preconditions do not hold, and returns
+// ErrPreconditionFailed if the batch must stop because of it. It is checked
+// after the change is authorized, so that preconditions do not tell an
+// actor about playlists they may not change.
+// runtime: O(c), c is the number of songs in Contains
+func (m *Mixtape) preconditions(c *call, i int, change models.PlaylistChange) (bool, error) {
+	p := change.Preconditions
+	if p == nil {
+		return false, nil
+	}
+	action := p.OnFailure
+	if action == "" {
+		action = m.onFailure
+	}
+	if (p.MaxSongs != nil && *p.MaxSongs < 0) || (action != "" && action != models.SkipChange && action != models.FailBatch) {
+		c.skip(reasonPreconditionInvalid)
+		return true, nil
+	}
+
+	fields, err := m.failedPrecondition(change.Playlist.ID, p)
+	if err == nil {
+		return false, nil
+	}
+	c.skip(err.Error(), fields...)
+	// the playlist may be as expected in a later run
+	c.retryable = true
+	if action == models.FailBatch {
+		return true, fmt.Errorf("change %d: %w", i, err)
+	}
+	return true, nil
+}
+
+// failedPrecondition returns the error of the first precondition that does
+// not hold, with the fields to log with it, or nil if they all hold.
+func (m *Mixtape) failedPrecondition(id string, p *models.Preconditions) ([]util.Field, error) {
+	j, exist := m.lookup.playlists[id]
+	if p.Exists != nil && *p.Exists != exist {
+		if exist {
+			return nil, errPlaylistExists
+		}
+		return nil, errPlaylistNotExists
+	}
+	if p.OwnedBy != "" && (!exist || m.mixtape.Playlists[j].UserID != p.OwnedBy) {
+		return []util.Field{util.UserID(p.OwnedBy)}, errNotOwnedBy
+	}
+	for _, songID := range p.Contains {
+		if m.lookup.playlistSongs[id][songID] == 0 {
+			return []util.Field{util.SongID(songID)}, errSongMissing
+		}
+	}
+	if p.MaxSongs != nil && exist && len(m.mixtape.Playlists[j].SongIDs) > *p.MaxSongs {
+		return nil, errTooManySongs
+	}
+	return nil, nil
+}
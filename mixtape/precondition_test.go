@@ -0,0 +1,141 @@
+package mixtape_test
+
+import (
+	"errors"
+
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/policy"
+	"github.com/n4wei/highspot/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Preconditions", func() {
+	var (
+		mixtape *models.Mixtape
+		logs    *gbytes.Buffer
+		m       *mixtape_pkg.Mixtape
+	)
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{
+				{ID: "user_1", Name: "test_user_1"},
+				{ID: "user_2", Name: "test_user_2"},
+			},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_1", "song_2"}},
+				{ID: "playlist_2", UserID: "user_2", SongIDs: []string{"song_2"}},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1"},
+				{ID: "song_2", Artist: "some_other_artist", Title: "test_song_2"},
+				{ID: "song_3", Artist: "another_artist", Title: "test_song_3"},
+			},
+		}
+		logs = gbytes.NewBuffer()
+		m = mixtape_pkg.New(mixtape, newTestLogger(logs))
+	})
+
+	yes, no := true, false
+	one, two := 1, 2
+
+	addSongs := func(id string, p *models.Preconditions) models.PlaylistChange {
+		return models.PlaylistChange{ID: models.AddSongs, Playlist: models.Playlist{ID: id, SongIDs: []string{"song_3"}}, Preconditions: p}
+	}
+
+	It("should apply the change when every precondition holds", func() {
+		Expect(m.ApplyChanges(&models.Changes{PlaylistChanges: []models.PlaylistChange{
+			addSongs("playlist_1", &models.Preconditions{Exists: &yes, OwnedBy: "user_1", Contains: []string{"song_2", "song_1"}, MaxSongs: &two}),
+			{ID: models.Add, Playlist: models.Playlist{ID: "playlist_3", UserID: "user_1", SongIDs: []string{"song_1"}}, Preconditions: &models.Preconditions{Exists: &no, MaxSongs: &one}},
+		}})).To(Succeed())
+
+		Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"song_1", "song_2", "song_3"}))
+		Expect(mixtape.Playlists).To(HaveLen(3))
+	})
+
+	It("should skip the change when a precondition fails, and continue", func() {
+		Expect(m.ApplyChanges(&models.Changes{PlaylistChanges: []models.PlaylistChange{
+			addSongs("playlist_x", &models.Preconditions{Exists: &yes}),
+			addSongs("playlist_1", &models.Preconditions{Exists: &no}),
+			addSongs("playlist_1", &models.Preconditions{OwnedBy: "user_2"}),
+			addSongs("playlist_2", &models.Preconditions{Contains: []string{"song_2", "song_1"}}),
+			addSongs("playlist_1", &models.Preconditions{MaxSongs: &one}),
+			addSongs("playlist_1", &models.Preconditions{MaxSongs: new(int)}),
+			addSongs("playlist_1", &models.Preconditions{OnFailure: "retry"}),
+			addSongs("playlist_2", &models.Preconditions{}),
+		}})).To(Succeed())
+
+		Expect(logs).To(gbytes.Say(`playlist_id=playlist_x reason="precondition failed: playlist does not exist"`))
+		Expect(logs).To(gbytes.Say(`playlist_id=playlist_1 reason="precondition failed: playlist exists"`))
+		Expect(logs).To(gbytes.Say(`playlist_id=playlist_1 user_id=user_2 reason="precondition failed: playlist not owned by user_id"`))
+		Expect(logs).To(gbytes.Say(`playlist_id=playlist_2 song_id=song_1 reason="precondition failed: song_id not in playlist"`))
+		Expect(logs).To(gbytes.Say(`reason="precondition failed: playlist has more than max_songs"`))
+		Expect(logs).To(gbytes.Say(`reason="precondition failed: playlist has more than max_songs"`))
+		Expect(logs).To(gbytes.Say(`reason="preconditions must have max_songs of at least 0 and on_failure skip or fail"`))
+		Expect(mixtape.Playlists[0].SongIDs).To(HaveLen(2))
+		Expect(mixtape.Playlists[1].SongIDs).To(Equal([]string{"song_2", "song_3"}))
+	})
+
+	Context("when a failed precondition fails the batch", func() {
+		changes := func(onFailure models.FailureAction) *models.Changes {
+			return &models.Changes{PlaylistChanges: []models.PlaylistChange{
+				addSongs("playlist_2", nil),
+				addSongs("playlist_1", &models.Preconditions{OwnedBy: "user_2", OnFailure: onFailure}),
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_2"}},
+			}}
+		}
+
+		It("should stop with an error after applying the changes before it", func() {
+			err := m.ApplyChanges(changes(models.FailBatch))
+
+			Expect(errors.Is(err, mixtape_pkg.ErrPreconditionFailed)).To(BeTrue())
+			Expect(err).To(MatchError("change 1: precondition failed: playlist not owned by user_id"))
+			Expect(mixtape.Playlists).To(HaveLen(2))
+			Expect(mixtape.Playlists[1].SongIDs).To(Equal([]string{"song_2", "song_3"}))
+			Expect(m.Report().Entries).To(HaveLen(2))
+			Expect(m.Report().Entries[1].Status).To(Equal(models.Skipped))
+		})
+
+		It("should fail by default with WithPreconditionFailure, unless the change says to skip", func() {
+			original := copyMixtape(mixtape)
+			m = mixtape_pkg.New(mixtape, util.Discard, mixtape_pkg.WithPreconditionFailure(models.FailBatch))
+			Expect(m.ApplyChanges(changes(""))).To(MatchError(mixtape_pkg.ErrPreconditionFailed))
+
+			mixtape = original
+			m = mixtape_pkg.New(mixtape, util.Discard, mixtape_pkg.WithPreconditionFailure(models.FailBatch))
+			Expect(m.ApplyChanges(changes(models.SkipChange))).To(Succeed())
+			Expect(mixtape.Playlists).To(HaveLen(1))
+		})
+
+		It("should stop at the same change in parallel", func() {
+			p := mixtape_pkg.New(mixtape, util.Discard)
+			err := p.ApplyChangesParallel(changes(models.FailBatch), 3)
+
+			Expect(err).To(MatchError("change 1: precondition failed: playlist not owned by user_id"))
+			Expect(p.Applied()).To(HaveLen(1))
+		})
+	})
+
+	It("should check preconditions after the policy", func() {
+		m = mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithPolicy(&policy.Policy{}))
+		change := addSongs("playlist_2", &models.Preconditions{Contains: []string{"song_1"}, OnFailure: models.FailBatch})
+		change.Actor = "user_1"
+		Expect(m.ApplyChanges(&models.Changes{PlaylistChanges: []models.PlaylistChange{change}})).To(Succeed())
+
+		Expect(m.Report().Entries[0].Reason).To(Equal(policy.ErrNotCollaborator.Error()))
+	})
+
+	It("should check the preconditions of a bulk operation on each playlist it matches", func() {
+		Expect(m.ApplyChanges(&models.Changes{PlaylistChanges: []models.PlaylistChange{{
+			ID:        models.Bulk,
+			Where:     `song.id = "song_2"`,
+			Operation: &models.PlaylistChange{ID: models.AddSongs, Playlist: models.Playlist{SongIDs: []string{"song_3"}}, Preconditions: &models.Preconditions{Contains: []string{"song_1"}}},
+		}}})).To(Succeed())
+
+		Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"song_1", "song_2", "song_3"}))
+		Expect(mixtape.Playlists[1].SongIDs).To(Equal([]string{"song_2"}))
+	})
+})
@@ -0,0 +1,102 @@
+package mixtape
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/selector"
+)
+
+// These methods only read the mixtape. They answer the queries of the
+// query command from the lookup hash maps, including the reverse indexes
+// of playlists by owner and by song, which are kept up to date as changes
+// are applied so a query never scans every playlist.
+
+// User returns the user with the given id.
+// runtime: O(1)
+func (m *Mixtape) User(id string) (models.User, bool) {
+	i, exist := m.lookup.users[id]
+	if !exist {
+		return models.User{}, false
+	}
+	return m.mixtape.Users[i], true
+}
+
+// Song returns the song with the given id.
+// runtime: O(1)
+func (m *Mixtape) Song(id string) (models.Song, bool) {
+	i, exist := m.lookup.songs[id]
+	if !exist {
+		return models.Song{}, false
+	}
+	return m.mixtape.Songs[i], true
+}
+
+// PlaylistsOwnedBy returns the ids of the playlists a user owns, sorted.
+// runtime: O(r log r), r is the number of playlists returned
+func (m *Mixtape) PlaylistsOwnedBy(userID string) []string {
+	return sortedKeys(m.lookup.owned[userID])
+}
+
+// PlaylistsWithSong returns the ids of the playlists a song is in, sorted.
+// runtime: O(r log r), r is the number of playlists returned
+func (m *Mixtape) PlaylistsWithSong(songID string) []string {
+	return sortedKeys(m.lookup.songPlaylists[songID])
+}
+
+// PlaylistsMatching returns the ids of the playlists the selector matches,
+// sorted, or of every playlist for a nil selector.
+// runtime: O(p*e + r log r), e is the cost of matching a playlist, see
+// selector.Selector.Match, since a selector can not be looked up in a hash
+// map
+func (m *Mixtape) PlaylistsMatching(s *selector.Selector) []string {
+	ids := map[string]bool{}
+	for _, playlist := range m.mixtape.Playlists {
+		if s == nil || s.Match(playlist, m) {
+			ids[playlist.ID] = true
+		}
+	}
+	return sortedKeys(ids)
+}
+
+// SearchSongs returns the songs whose artist and title contain the given
+// substrings, ignoring case, in mixtape order. An empty substring matches
+// any song.
+// runtime: O(s), s is the number of songs, since substrings can not be
+// looked up in a hash map
+func (m *Mixtape) SearchSongs(artist, title string) []models.Song {
+	artist, title = strings.ToLower(artist), strings.ToLower(title)
+	songs := []models.Song{}
+	for _, song := range m.mixtape.Songs {
+		if strings.Contains(strings.ToLower(song.Artist), artist) && strings.Contains(strings.ToLower(song.Title), title) {
+			songs = append(songs, song)
+		}
+	}
+	return songs
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// addTo and removeFrom maintain a map of sets, such as a reverse index,
+// without keeping empty sets around.
+func addTo(sets map[string]map[string]bool, key, value string) {
+	if sets[key] == nil {
+		sets[key] = map[string]bool{}
+	}
+	sets[key][value] = true
+}
+
+func removeFrom(sets map[string]map[string]bool, key, value string) {
+	delete(sets[key], value)
+	if len(sets[key]) == 0 {
+		delete(sets, key)
+	}
+}
@@ -0,0 +1,100 @@
+package mixtape_test
+
+import (
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/selector"
+	"github.com/n4wei/highspot/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Queries", func() {
+	var (
+		mixtape *models.Mixtape
+		m       *mixtape_pkg.Mixtape
+	)
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{
+				{ID: "user_1", Name: "test_user_1"},
+				{ID: "user_2", Name: "test_user_2"},
+			},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_1", "song_2"}},
+				{ID: "playlist_2", UserID: "user_2", SongIDs: []string{"song_2"}},
+				{ID: "playlist_3", UserID: "user_1", SongIDs: []string{"song_2", "song_2"}, AllowDuplicates: true},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "Some Artist", Title: "Test Song 1"},
+				{ID: "song_2", Artist: "Another Artist", Title: "Another Song"},
+				{ID: "song_3", Artist: "another_artist", Title: "test_song_3"},
+			},
+		}
+		m = mixtape_pkg.New(mixtape, util.Discard)
+	})
+
+	apply := func(changes ...models.PlaylistChange) {
+		Expect(m.ApplyChanges(&models.Changes{PlaylistChanges: changes})).To(Succeed())
+		Expect(m.Index()).To(Equal(mixtape_pkg.BuildIndex(mixtape)))
+	}
+
+	It("should look up users and songs by ID", func() {
+		user, exist := m.User("user_2")
+		Expect(exist).To(BeTrue())
+		Expect(user).To(Equal(models.User{ID: "user_2", Name: "test_user_2"}))
+		song, exist := m.Song("song_3")
+		Expect(exist).To(BeTrue())
+		Expect(song).To(Equal(mixtape.Songs[2]))
+		_, exist = m.Song("song_x")
+		Expect(exist).To(BeFalse())
+	})
+
+	Describe("PlaylistsOwnedBy", func() {
+		It("should follow adds, removes and transfers", func() {
+			Expect(m.PlaylistsOwnedBy("user_1")).To(Equal([]string{"playlist_1", "playlist_3"}))
+
+			apply(
+				models.PlaylistChange{ID: models.Add, Playlist: models.Playlist{ID: "playlist_4", UserID: "user_2", SongIDs: []string{"song_3"}}},
+				models.PlaylistChange{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_3"}},
+				models.PlaylistChange{ID: models.TransferOwnership, Playlist: models.Playlist{ID: "playlist_1", UserID: "user_2"}},
+			)
+			Expect(m.PlaylistsOwnedBy("user_1")).To(BeEmpty())
+			Expect(m.PlaylistsOwnedBy("user_2")).To(Equal([]string{"playlist_1", "playlist_2", "playlist_4"}))
+		})
+	})
+
+	Describe("PlaylistsWithSong", func() {
+		It("should follow songs being added and removed", func() {
+			Expect(m.PlaylistsWithSong("song_2")).To(Equal([]string{"playlist_1", "playlist_2", "playlist_3"}))
+
+			apply(
+				models.PlaylistChange{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_2", SongIDs: []string{"song_3"}}},
+				// one of the two occurrences, so playlist_3 still has it
+				models.PlaylistChange{ID: models.RemoveSongs, Playlist: models.Playlist{ID: "playlist_3", SongIDs: []string{"song_2"}}},
+				models.PlaylistChange{ID: models.RemoveSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_2"}}},
+				models.PlaylistChange{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_2"}},
+			)
+			Expect(m.PlaylistsWithSong("song_2")).To(Equal([]string{"playlist_3"}))
+			Expect(m.PlaylistsWithSong("song_3")).To(BeEmpty())
+		})
+	})
+
+	Describe("PlaylistsMatching", func() {
+		It("should match the owner and songs of every playlist, or match all without a selector", func() {
+			s, err := selector.Parse(`owner.name = "test_user_1" and song.artist ~ "another"`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.PlaylistsMatching(s)).To(Equal([]string{"playlist_1", "playlist_3"}))
+			Expect(m.PlaylistsMatching(nil)).To(Equal([]string{"playlist_1", "playlist_2", "playlist_3"}))
+		})
+	})
+
+	Describe("SearchSongs", func() {
+		It("should match substrings of the artist and title, ignoring case", func() {
+			Expect(m.SearchSongs("another", "")).To(Equal([]models.Song{mixtape.Songs[1], mixtape.Songs[2]}))
+			Expect(m.SearchSongs("ANOTHER", "song 3")).To(BeEmpty())
+			Expect(m.SearchSongs("", "test")).To(Equal([]models.Song{mixtape.Songs[0], mixtape.Songs[2]}))
+		})
+	})
+})
@@ -0,0 +1,176 @@
+package mixtape
+
+import (
+	"time"
+
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/util"
+)
+
+const msgDroppedSong = "dropped song"
+
+// This method adds the playlist if there is none with its id, exactly like
+// add, and otherwise replaces the existing playlist's owner and songs with
+// the change's. A new owner takes over like with transfer_ownership, so the
+// previous one becomes an editor. The songs are validated like those of a
+// new playlist, see validSongs, and the rest of the playlist, eg. its name
+// and collaborators, is left unchanged. The songs added and dropped are
+// reported, see replaceSongs. A playlist that is replaced is recorded as
+// applied as this same change, with its new owner and songs, since no
+// other change replaces both.
+
+// See tests in replace_test.go for all invalid cases.
+
+// runtime: O(s + ps + pc), s is the number of songs in the change, ps the
+// number of songs in the playlist and pc its number of collaborators
+func (m *Mixtape) upsertPlaylist(c *call, playlist models.Playlist) error {
+	i, exist := m.lookup.playlists[playlist.ID]
+	if playlist.ID == "" || !exist {
+		applied := len(m.applied)
+		err := m.addPlaylist(c, playlist)
+		if len(m.applied) > applied {
+			c.entry.AddedSongs = append([]string{}, m.applied[applied].Playlist.SongIDs...)
+		}
+		return err
+	}
+	existing := &m.mixtape.Playlists[i]
+
+	userID := playlist.UserID
+	if userID == "" {
+		c.skip(reasonUserIDMissing)
+		return nil
+	}
+	if _, exist := m.lookup.users[userID]; !exist {
+		c.skip(reasonUserNotInMixtape, util.UserID(userID))
+		return nil
+	}
+	if len(playlist.SongIDs) == 0 {
+		c.skip(reasonNoSongs)
+		return nil
+	}
+	// duplicates are allowed as the existing playlist allows them
+	songIDs, ok := m.validSongs(c, models.Playlist{AllowDuplicates: existing.AllowDuplicates, SongIDs: playlist.SongIDs})
+	if !ok {
+		return nil
+	}
+
+	changed := false
+	if userID != existing.UserID {
+		m.transfer(i, userID)
+		c.logger.Info(msgTransferredPlaylist, util.UserID(userID))
+		changed = true
+	}
+	if !models.EqualSongIDs(existing.SongIDs, songIDs) {
+		m.replace(c, i, songIDs)
+		changed = true
+	}
+
+	if changed {
+		m.touch(i)
+		m.applied = append(m.applied, models.PlaylistChange{
+			ID:       models.UpsertPlaylist,
+			Playlist: models.Playlist{ID: existing.ID, UserID: userID, SongIDs: songIDs},
+		})
+	}
+	return nil
+}
+
+// This method replaces the songs of an existing playlist with the change's,
+// in their order. The songs are validated like those of a new playlist,
+// see validSongs, and the change is skipped if none of them are valid, so
+// that a sync from a source with unknown songs does not empty the
+// playlist. Without any songs, the playlist is emptied. The songs added and
+// dropped are reported, see replaceSongs, and the change is recorded as
+// applied with the valid songs.
+
+// runtime: O(s + ps), s is the number of songs in the change and ps the
+// number of songs in the playlist
+func (m *Mixtape) setSongs(c *call, playlist models.Playlist) error {
+	i, ok := m.existingPlaylist(c, playlist)
+	if !ok {
+		return nil
+	}
+	existing := &m.mixtape.Playlists[i]
+
+	songIDs := []string{}
+	if len(playlist.SongIDs) > 0 {
+		songIDs, ok = m.validSongs(c, models.Playlist{AllowDuplicates: existing.AllowDuplicates, SongIDs: playlist.SongIDs})
+		if !ok {
+			return nil
+		}
+	}
+	if models.EqualSongIDs(existing.SongIDs, songIDs) {
+		return nil
+	}
+
+	m.replace(c, i, songIDs)
+	m.touch(i)
+	m.applied = append(m.applied, models.PlaylistChange{
+		ID:       models.SetSongs,
+		Playlist: models.Playlist{ID: existing.ID, SongIDs: songIDs},
+	})
+	return nil
+}
+
+// replace reports and logs the songs that replacing the songs of the
+// playlist at index i adds and drops, then replaces them.
+func (m *Mixtape) replace(c *call, i int, songIDs []string) {
+	added, dropped := diffSongs(m.mixtape.Playlists[i].SongIDs, songIDs)
+	for _, songID := range added {
+		c.logger.Info(msgAddedSong, util.SongID(songID))
+	}
+	for _, songID := range dropped {
+		c.logger.Info(msgDroppedSong, util.SongID(songID))
+	}
+	c.entry.AddedSongs = added
+	c.entry.DroppedSongs = dropped
+	m.replaceSongs(i, songIDs)
+}
+
+// diffSongs returns the songs in after that are not in before, in the
+// order of after, and the songs in before that are not in after, in the
+// order of before. A song that appears more often in one than the other
+// is in the difference as many more times.
+// runtime: O(b + a), b and a are the number of songs before and after
+func diffSongs(before, after []string) (added, dropped []string) {
+	count := map[string]int{}
+	for _, songID := range before {
+		count[songID]++
+	}
+	for _, songID := range after {
+		if count[songID] > 0 {
+			count[songID]--
+			continue
+		}
+		added = append(added, songID)
+	}
+	// what is left in count are the songs dropped
+	for _, songID := range before {
+		if count[songID] > 0 {
+			count[songID]--
+			dropped = append(dropped, songID)
+		}
+	}
+	return added, dropped
+}
+
+// replaceSongs replaces the songs of the playlist at index i and keeps the
+// lookup hash maps consistent with them.
+// runtime: O(ps + s), ps is the number of songs in the playlist and s the
+// number of songs replacing them
+func (m *Mixtape) replaceSongs(i int, songIDs []string) {
+	playlist := &m.mixtape.Playlists[i]
+	for songID := range m.lookup.playlistSongs[playlist.ID] {
+		removeFrom(m.lookup.songPlaylists, songID, playlist.ID)
+	}
+	songs := map[string]int{}
+	var duration time.Duration
+	for _, songID := range songIDs {
+		songs[songID]++
+		duration += m.songDuration(songID)
+		addTo(m.lookup.songPlaylists, songID, playlist.ID)
+	}
+	m.lookup.playlistSongs[playlist.ID] = songs
+	m.lookup.durations[playlist.ID] = duration
+	playlist.SongIDs = append([]string{}, songIDs...)
+}
@@ -0,0 +1,193 @@
+package mixtape_test
+
+import (
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Upsert and Set Songs", func() {
+	var (
+		mixtape *models.Mixtape
+		logs    *gbytes.Buffer
+		m       *mixtape_pkg.Mixtape
+	)
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{
+				{ID: "user_1", Name: "test_user_1"},
+				{ID: "user_2", Name: "test_user_2"},
+			},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", Name: "Mix", SongIDs: []string{"song_1", "song_2", "song_3"},
+					Collaborators: []models.Collaborator{{UserID: "user_2", Role: models.Viewer}}},
+				{ID: "playlist_2", UserID: "user_1", SongIDs: []string{"song_1", "song_1", "song_2"}, AllowDuplicates: true},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1", DurationMs: 180000},
+				{ID: "song_2", Artist: "some_other_artist", Title: "test_song_2", DurationMs: 240000},
+				{ID: "song_3", Artist: "another_artist", Title: "test_song_3", DurationMs: 300000},
+				{ID: "song_4", Artist: "another_artist", Title: "test_song_4", DurationMs: 120000},
+			},
+		}
+		logs = gbytes.NewBuffer()
+		m = mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithClock(testClock))
+	})
+
+	apply := func(changes ...models.PlaylistChange) {
+		Expect(m.ApplyChanges(&models.Changes{PlaylistChanges: changes})).To(Succeed())
+		Expect(m.Index()).To(Equal(mixtape_pkg.BuildIndex(mixtape)))
+	}
+
+	upsert := func(id, userID string, songIDs ...string) models.PlaylistChange {
+		return models.PlaylistChange{ID: models.UpsertPlaylist, Playlist: models.Playlist{ID: id, UserID: userID, SongIDs: songIDs}}
+	}
+
+	setSongs := func(id string, songIDs ...string) models.PlaylistChange {
+		return models.PlaylistChange{ID: models.SetSongs, Playlist: models.Playlist{ID: id, SongIDs: songIDs}}
+	}
+
+	It("should record changes that replay to the same playlists, in parallel too", func() {
+		changes := []models.PlaylistChange{
+			upsert("playlist_1", "user_2", "song_4", "song_1"),
+			upsert("playlist_3", "user_1", "song_2"),
+			setSongs("playlist_2", "song_2", "song_2"),
+			setSongs("playlist_3", "song_3", "song_x"),
+		}
+		parallel, replayed := copyMixtape(mixtape), copyMixtape(mixtape)
+		apply(changes...)
+
+		p := mixtape_pkg.New(parallel, util.Discard, mixtape_pkg.WithClock(testClock))
+		Expect(p.ApplyChangesParallel(&models.Changes{PlaylistChanges: changes}, 2)).To(Succeed())
+		Expect(parallel).To(Equal(mixtape))
+		Expect(p.Applied()).To(Equal(m.Applied()))
+
+		r := mixtape_pkg.New(replayed, util.Discard, mixtape_pkg.WithClock(testClock))
+		Expect(r.ApplyChanges(&models.Changes{PlaylistChanges: m.Applied()})).To(Succeed())
+		Expect(replayed).To(Equal(mixtape))
+	})
+
+	Describe("upsert_playlist", func() {
+		It("should add the playlist if there is none with its id, reporting every song as added", func() {
+			apply(upsert("playlist_3", "user_2", "song_2", "song_x", "song_1"))
+
+			Expect(logs).To(gbytes.Say(`msg="added playlist" change=upsert_playlist change_index=0 playlist_id=playlist_3`))
+			Expect(mixtape.Playlists[2].SongIDs).To(Equal([]string{"song_2", "song_1"}))
+			entry := m.Report().Entries[0]
+			Expect(entry.AddedSongs).To(Equal([]string{"song_2", "song_1"}))
+			Expect(entry.DroppedSongs).To(BeEmpty())
+			Expect(m.Applied()[0].ID).To(Equal(models.Add))
+		})
+
+		It("should replace the owner and songs of an existing playlist, and leave the rest of it", func() {
+			apply(upsert("playlist_1", "user_2", "song_3", "song_4", "song_1"))
+
+			Expect(logs).To(gbytes.Say(`msg="transferred playlist" change=upsert_playlist change_index=0 playlist_id=playlist_1 user_id=user_2`))
+			Expect(logs).To(gbytes.Say(`msg="added song" .* song_id=song_4`))
+			Expect(logs).To(gbytes.Say(`msg="dropped song" .* song_id=song_2`))
+			Expect(mixtape.Playlists[0]).To(Equal(models.Playlist{
+				ID: "playlist_1", UserID: "user_2", Name: "Mix", SongIDs: []string{"song_3", "song_4", "song_1"},
+				Collaborators: []models.Collaborator{{UserID: "user_1", Role: models.Editor}},
+				Version:       1, UpdatedAt: testClock(),
+			}))
+			entry := m.Report().Entries[0]
+			Expect(entry.AddedSongs).To(Equal([]string{"song_4"}))
+			Expect(entry.DroppedSongs).To(Equal([]string{"song_2"}))
+			Expect(m.PlaylistsOwnedBy("user_1")).To(Equal([]string{"playlist_2"}))
+			Expect(m.Applied()).To(Equal([]models.PlaylistChange{
+				{ID: models.UpsertPlaylist, Playlist: models.Playlist{ID: "playlist_1", UserID: "user_2", SongIDs: []string{"song_3", "song_4", "song_1"}}},
+			}))
+		})
+
+		It("should not apply anything when the playlist is already as given", func() {
+			apply(upsert("playlist_1", "user_1", "song_1", "song_2", "song_3", "song_3"))
+
+			Expect(m.Report().Entries[0].Reason).To(Equal("nothing to apply"))
+			Expect(mixtape.Playlists[0].Version).To(BeZero())
+		})
+
+		Context("when the change is invalid", func() {
+			It("should not replace the playlist, output a log, and continue", func() {
+				original := copyMixtape(mixtape)
+				apply(
+					upsert("", "user_1", "song_1"),
+					upsert("playlist_1", "", "song_1"),
+					upsert("playlist_1", "user_x", "song_1"),
+					upsert("playlist_1", "user_1"),
+					upsert("playlist_1", "user_1", "song_x"),
+				)
+
+				Expect(logs).To(gbytes.Say(`reason="playlist_id missing"`))
+				Expect(logs).To(gbytes.Say(`reason="user_id missing"`))
+				Expect(logs).To(gbytes.Say(`user_id=user_x reason="user_id not in mixtape"`))
+				Expect(logs).To(gbytes.Say(`reason="playlist has no songs"`))
+				Expect(logs).To(gbytes.Say(`reason="playlist has no songs from mixtape"`))
+				Expect(mixtape).To(Equal(original))
+			})
+		})
+	})
+
+	Describe("set_songs", func() {
+		It("should replace the songs with exactly the valid ones given, in order", func() {
+			apply(setSongs("playlist_1", "song_4", "song_2", "song_x", "song_4"))
+
+			Expect(logs).To(gbytes.Say(`msg="skipped song" .* song_id=song_x reason="song_id not in mixtape"`))
+			Expect(logs).To(gbytes.Say(`msg="skipped song" .* song_id=song_4 reason="song_id already in playlist"`))
+			Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"song_4", "song_2"}))
+			Expect(mixtape.Playlists[0].Version).To(Equal(int64(1)))
+			d, _ := m.Duration("playlist_1")
+			Expect(d.Milliseconds()).To(Equal(int64(360000)))
+			Expect(m.PlaylistsWithSong("song_1")).To(Equal([]string{"playlist_2"}))
+			entry := m.Report().Entries[0]
+			Expect(entry.AddedSongs).To(Equal([]string{"song_4"}))
+			Expect(entry.DroppedSongs).To(Equal([]string{"song_1", "song_3"}))
+			Expect(m.Applied()).To(Equal([]models.PlaylistChange{
+				{ID: models.SetSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_4", "song_2"}}},
+			}))
+		})
+
+		It("should count repeated songs in what was added and dropped", func() {
+			apply(setSongs("playlist_2", "song_2", "song_1", "song_2"))
+
+			Expect(mixtape.Playlists[1].SongIDs).To(Equal([]string{"song_2", "song_1", "song_2"}))
+			entry := m.Report().Entries[0]
+			Expect(entry.AddedSongs).To(Equal([]string{"song_2"}))
+			Expect(entry.DroppedSongs).To(Equal([]string{"song_1"}))
+		})
+
+		It("should empty the playlist when given no songs", func() {
+			apply(setSongs("playlist_1"))
+
+			Expect(mixtape.Playlists[0].SongIDs).To(BeEmpty())
+			Expect(m.Report().Entries[0].DroppedSongs).To(Equal([]string{"song_1", "song_2", "song_3"}))
+		})
+
+		It("should keep the songs within the limits", func() {
+			m = mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithLimits(mixtape_pkg.Limits{MaxSongs: 2}))
+			apply(setSongs("playlist_1", "song_4", "song_3", "song_2"))
+
+			Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"song_4", "song_3"}))
+			Expect(m.Report().Entries[0].SkippedSongs).To(Equal([]models.SkippedSong{{SongID: "song_2", Reason: "playlist would exceed max songs"}}))
+		})
+
+		Context("when the change is invalid", func() {
+			It("should not replace any songs, output a log, and continue", func() {
+				original := copyMixtape(mixtape)
+				apply(
+					setSongs("playlist_x", "song_1"),
+					setSongs("playlist_1", "song_x"),
+					setSongs("playlist_1", "song_1", "song_2", "song_3"),
+				)
+
+				Expect(logs).To(gbytes.Say(`reason="playlist_id not found"`))
+				Expect(logs).To(gbytes.Say(`reason="playlist has no songs from mixtape"`))
+				Expect(m.Report().Entries[2].Reason).To(Equal("nothing to apply"))
+				Expect(mixtape).To(Equal(original))
+			})
+		})
+	})
+})
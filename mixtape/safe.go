@@ -0,0 +1,126 @@
+package mixtape
+
+import (
+	"sync"
+	"time"
+
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/selector"
+	"github.com/n4wei/highspot/util"
+)
+
+// SafeMixtape is a Mixtape that can be shared by goroutines. Changes are
+// applied under a write lock, one batch at a time, so a batch is never
+// interleaved with another. Reads take a read lock and return copies, so
+// they run concurrently with each other and never see a half applied
+// change. A single RWMutex was chosen over sharding by playlist ID because
+// adding and removing playlists both reorder the one shared playlist array.
+// The logger must be safe for concurrent use.
+type SafeMixtape struct {
+	mu      sync.RWMutex
+	mixtape *Mixtape
+}
+
+func NewSafe(mixtape *models.Mixtape, logger util.Logger, opts ...Option) *SafeMixtape {
+	return &SafeMixtape{mixtape: New(mixtape, logger, opts...)}
+}
+
+func (s *SafeMixtape) ApplyChanges(changes *models.Changes) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mixtape.ApplyChanges(changes)
+}
+
+func (s *SafeMixtape) ApplyChangesParallel(changes *models.Changes, workers int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.mixtape.ApplyChangesParallel(changes, workers)
+}
+
+func (s *SafeMixtape) Applied() []models.PlaylistChange {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]models.PlaylistChange{}, s.mixtape.Applied()...)
+}
+
+func (s *SafeMixtape) Report() *models.Report {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &models.Report{Entries: append([]models.ReportEntry{}, s.mixtape.Report().Entries...)}
+}
+
+func (s *SafeMixtape) Playlist(id string) (models.Playlist, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mixtape.Playlist(id)
+}
+
+func (s *SafeMixtape) Duration(id string) (time.Duration, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mixtape.Duration(id)
+}
+
+func (s *SafeMixtape) PlaylistsEditableBy(userID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mixtape.PlaylistsEditableBy(userID)
+}
+
+func (s *SafeMixtape) User(id string) (models.User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mixtape.User(id)
+}
+
+func (s *SafeMixtape) Song(id string) (models.Song, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mixtape.Song(id)
+}
+
+func (s *SafeMixtape) PlaylistsOwnedBy(userID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mixtape.PlaylistsOwnedBy(userID)
+}
+
+func (s *SafeMixtape) PlaylistsWithSong(songID string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mixtape.PlaylistsWithSong(songID)
+}
+
+func (s *SafeMixtape) PlaylistsMatching(where *selector.Selector) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mixtape.PlaylistsMatching(where)
+}
+
+func (s *SafeMixtape) SearchSongs(artist, title string) []models.Song {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mixtape.SearchSongs(artist, title)
+}
+
+// Copy returns a deep copy of the mixtape, eg. to write it to a file while
+// changes keep being applied.
+// runtime: O(u + s + p*ps), see buildLookup for the variables
+func (s *SafeMixtape) Copy() *models.Mixtape {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	mixtape := s.mixtape.mixtape
+	playlists := make([]models.Playlist, len(mixtape.Playlists))
+	for i, playlist := range mixtape.Playlists {
+		playlists[i] = copyPlaylist(playlist)
+	}
+	return &models.Mixtape{
+		Users:     append([]models.User{}, mixtape.Users...),
+		Playlists: playlists,
+		Songs:     append([]models.Song{}, mixtape.Songs...),
+		Version:   mixtape.Version,
+
+		AllowDuplicates: mixtape.AllowDuplicates,
+	}
+}
@@ -0,0 +1,122 @@
+package mixtape_test
+
+import (
+	"fmt"
+	"sync"
+
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// Run with the race detector to get the most out of these tests:
+//
+//	go test -race ./mixtape
+var _ = Describe("SafeMixtape", func() {
+	const (
+		writers    = 16
+		readers    = 4
+		iterations = 100
+	)
+
+	var (
+		mixtape     *models.Mixtape
+		safeMixtape *mixtape_pkg.SafeMixtape
+	)
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{
+				{ID: "user_1", Name: "test_user_1"},
+			},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_1"}},
+				{ID: "playlist_2", UserID: "user_1", SongIDs: []string{"song_1"}},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1"},
+				{ID: "song_2", Artist: "some_other_artist", Title: "test_song_2"},
+				{ID: "song_3", Artist: "another_artist", Title: "test_song_3"},
+			},
+		}
+		safeMixtape = mixtape_pkg.NewSafe(mixtape, util.Discard)
+	})
+
+	It("should apply add, remove and add_songs from many goroutines consistently", func() {
+		done := make(chan struct{})
+		wg := sync.WaitGroup{}
+
+		for r := 0; r < readers; r++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					playlist, exist := safeMixtape.Playlist("playlist_1")
+					Expect(exist).To(BeTrue())
+					Expect(len(playlist.SongIDs)).To(BeNumerically("<=", 3))
+					Expect(safeMixtape.Copy().Playlists).ToNot(BeEmpty())
+				}
+			}()
+		}
+
+		writersWG := sync.WaitGroup{}
+		for w := 0; w < writers; w++ {
+			writersWG.Add(1)
+			go func(w int) {
+				defer GinkgoRecover()
+				defer writersWG.Done()
+				for i := 0; i < iterations; i++ {
+					id := fmt.Sprintf("playlist_%d_%d", w, i)
+					previous := fmt.Sprintf("playlist_%d_%d", w, i-1)
+					err := safeMixtape.ApplyChanges(&models.Changes{
+						PlaylistChanges: []models.PlaylistChange{
+							{ID: models.Add, Playlist: models.Playlist{ID: id, UserID: "user_1", SongIDs: []string{"song_1"}}},
+							{ID: models.AddSongs, Playlist: models.Playlist{ID: id, SongIDs: []string{"song_2", "song_3"}}},
+							{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_2", "song_3"}}},
+							{ID: models.Remove, Playlist: models.Playlist{ID: previous}},
+						},
+					})
+					Expect(err).ToNot(HaveOccurred())
+				}
+			}(w)
+		}
+
+		writersWG.Wait()
+		close(done)
+		wg.Wait()
+
+		// every writer leaves exactly its last playlist behind
+		result := safeMixtape.Copy()
+		Expect(result.Playlists).To(HaveLen(2 + writers))
+		for w := 0; w < writers; w++ {
+			playlist, exist := safeMixtape.Playlist(fmt.Sprintf("playlist_%d_%d", w, iterations-1))
+			Expect(exist).To(BeTrue())
+			Expect(playlist.SongIDs).To(Equal([]string{"song_1", "song_2", "song_3"}))
+		}
+
+		// the lookup hash maps still agree with the playlist array
+		for _, playlist := range result.Playlists {
+			found, exist := safeMixtape.Playlist(playlist.ID)
+			Expect(exist).To(BeTrue())
+			Expect(found).To(Equal(playlist))
+		}
+	})
+
+	It("should return copies that do not share memory with the mixtape", func() {
+		playlist, _ := safeMixtape.Playlist("playlist_1")
+		playlist.SongIDs[0] = "song_x"
+
+		copied := safeMixtape.Copy()
+		copied.Playlists[0].SongIDs[0] = "song_y"
+
+		Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"song_1"}))
+	})
+})
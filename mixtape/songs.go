@@ -0,0 +1,159 @@
+package mixtape
+
+import (
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/recommend"
+	"github.com/n4wei/highspot/util"
+)
+
+// Reasons a remove_songs or auto_fill change, or a song or position in it,
+// is skipped
+const (
+	reasonRemoveModeInvalid  = "mode must be first, all or position"
+	reasonRemoveMixed        = "songs are removed by song_ids or by positions, not both"
+	reasonSongNotInPlaylist  = "song_id not in playlist"
+	reasonPositionOutOfRange = "position out of range"
+	reasonPositionRepeated   = "position already removed"
+	reasonCountInvalid       = "count must be at least 1"
+	reasonNoRecommendations  = "no songs to recommend"
+)
+
+const (
+	msgSkippedPosition = "skipped position"
+	msgRemovedSong     = "removed song"
+)
+
+// This method removes songs from an existing playlist, keeping the order
+// of the songs that are left. The change's mode picks which occurrences of
+// a song are removed, see models.RemoveMode. Songs that are not in the
+// playlist, and positions past its end or given twice, are skipped. A
+// playlist can be left without songs.
+
+// See tests in songs_test.go for all invalid cases.
+
+// runtime: O(ps*s), ps is the number of songs in the playlist and s the
+// number of songs or positions being removed
+func (m *Mixtape) removeSongs(c *call, change models.PlaylistChange) error {
+	i, ok := m.existingPlaylist(c, change.Playlist)
+	if !ok {
+		return nil
+	}
+	mode := change.Mode
+	if mode == "" {
+		mode = models.RemoveFirst
+	}
+	if mode != models.RemoveFirst && mode != models.RemoveAll && mode != models.RemoveAt {
+		c.skip(reasonRemoveModeInvalid)
+		return nil
+	}
+	if (mode == models.RemoveAt && len(change.Playlist.SongIDs) > 0) || (mode != models.RemoveAt && len(change.Positions) > 0) {
+		c.skip(reasonRemoveMixed)
+		return nil
+	}
+
+	songIDs := m.mixtape.Playlists[i].SongIDs
+	applied := models.PlaylistChange{ID: models.RemoveSongs, Mode: change.Mode, Playlist: models.Playlist{ID: change.Playlist.ID}}
+	// indices into songIDs
+	removed := map[int]bool{}
+	if mode == models.RemoveAt {
+		for _, position := range change.Positions {
+			if position < 1 || position > len(songIDs) {
+				c.skipPosition(position, reasonPositionOutOfRange)
+				continue
+			}
+			if removed[position-1] {
+				c.skipPosition(position, reasonPositionRepeated)
+				continue
+			}
+			removed[position-1] = true
+			applied.Positions = append(applied.Positions, position)
+			c.logger.Info(msgRemovedSong, util.SongID(songIDs[position-1]), util.Position(position))
+		}
+	} else {
+		for _, songID := range change.Playlist.SongIDs {
+			indices := occurrences(songIDs, songID, mode == models.RemoveAll, removed)
+			if len(indices) == 0 {
+				c.skipSong(songID, reasonSongNotInPlaylist)
+				continue
+			}
+			for _, j := range indices {
+				removed[j] = true
+				c.logger.Info(msgRemovedSong, util.SongID(songID), util.Position(j+1))
+			}
+			applied.Playlist.SongIDs = append(applied.Playlist.SongIDs, songID)
+		}
+	}
+
+	if len(removed) > 0 {
+		m.removeAt(i, removed)
+		m.touch(i)
+		m.applied = append(m.applied, applied)
+	}
+	return nil
+}
+
+// occurrences returns the indices of the song in songIDs that are not
+// removed yet: the first one, or all of them.
+// runtime: O(ps), ps is the number of songs in the playlist
+func occurrences(songIDs []string, songID string, all bool, removed map[int]bool) []int {
+	indices := []int{}
+	for j, id := range songIDs {
+		if id != songID || removed[j] {
+			continue
+		}
+		indices = append(indices, j)
+		if !all {
+			break
+		}
+	}
+	return indices
+}
+
+// removeAt removes the songs at the given indices of the playlist at index
+// i, keeping the order of the rest.
+// runtime: O(ps), ps is the number of songs in the playlist
+func (m *Mixtape) removeAt(i int, removed map[int]bool) {
+	playlist := &m.mixtape.Playlists[i]
+	songs := m.lookup.playlistSongs[playlist.ID]
+	kept := make([]string, 0, len(playlist.SongIDs)-len(removed))
+	for j, songID := range playlist.SongIDs {
+		if !removed[j] {
+			kept = append(kept, songID)
+			continue
+		}
+		songs[songID]--
+		if songs[songID] == 0 {
+			delete(songs, songID)
+			removeFrom(m.lookup.songPlaylists, songID, playlist.ID)
+		}
+		m.lookup.durations[playlist.ID] -= m.songDuration(songID)
+	}
+	playlist.SongIDs = kept
+}
+
+// This method appends the top change.Count songs recommended for an
+// existing playlist, see recommend.Recommend, as if they were added with
+// add_songs, so they are checked against the limits the same way and the
+// change is recorded as applied as that add_songs. Recommendations are
+// computed from every playlist as it is when the change is applied.
+
+// runtime: O(s + p*ps*t), see recommend.Recommend, since the mixtape's
+// lookup hash maps do not count songs shared between playlists
+func (m *Mixtape) autoFill(c *call, change models.PlaylistChange) error {
+	if _, ok := m.existingPlaylist(c, change.Playlist); !ok {
+		return nil
+	}
+	if change.Count < 1 {
+		c.skip(reasonCountInvalid)
+		return nil
+	}
+	recommendations, err := recommend.Recommend(m.mixtape, change.Playlist.ID, change.Count, recommend.Jaccard)
+	if err != nil {
+		return err
+	}
+	if len(recommendations) == 0 {
+		c.skip(reasonNoRecommendations)
+		return nil
+	}
+	return m.addSongsToPlaylist(c, models.Playlist{ID: change.Playlist.ID, SongIDs: recommend.SongIDs(recommendations)})
+}
@@ -0,0 +1,174 @@
+package mixtape_test
+
+import (
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Duplicate Songs", func() {
+	var (
+		mixtape *models.Mixtape
+		logs    *gbytes.Buffer
+		m       *mixtape_pkg.Mixtape
+	)
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{{ID: "user_1", Name: "test_user_1"}},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_1", "song_2"}},
+				{ID: "playlist_2", UserID: "user_1", SongIDs: []string{"song_1", "song_2", "song_1", "song_3", "song_1"}, AllowDuplicates: true},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1"},
+				{ID: "song_2", Artist: "some_other_artist", Title: "test_song_2"},
+				{ID: "song_3", Artist: "another_artist", Title: "test_song_3"},
+			},
+		}
+		logs = gbytes.NewBuffer()
+		m = mixtape_pkg.New(mixtape, newTestLogger(logs))
+	})
+
+	apply := func(changes ...models.PlaylistChange) {
+		Expect(m.ApplyChanges(&models.Changes{PlaylistChanges: changes})).To(Succeed())
+		Expect(m.Index()).To(Equal(mixtape_pkg.BuildIndex(mixtape)))
+	}
+
+	Describe("adding songs", func() {
+		It("should only repeat songs in playlists that allow duplicates", func() {
+			apply(
+				models.PlaylistChange{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_1", "song_3", "song_3"}}},
+				models.PlaylistChange{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_2", SongIDs: []string{"song_2", "song_2"}}},
+				models.PlaylistChange{ID: models.Add, Playlist: models.Playlist{ID: "playlist_3", UserID: "user_1", SongIDs: []string{"song_1", "song_1"}}},
+				models.PlaylistChange{ID: models.Add, Playlist: models.Playlist{ID: "playlist_4", UserID: "user_1", SongIDs: []string{"song_1", "song_1"}, AllowDuplicates: true}},
+			)
+
+			Expect(logs).To(gbytes.Say(`msg="skipped song" .* playlist_id=playlist_1 song_id=song_1 reason="song_id already in playlist"`))
+			Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"song_1", "song_2", "song_3"}))
+			Expect(mixtape.Playlists[1].SongIDs).To(Equal([]string{"song_1", "song_2", "song_1", "song_3", "song_1", "song_2", "song_2"}))
+			Expect(mixtape.Playlists[2].SongIDs).To(Equal([]string{"song_1"}))
+			Expect(mixtape.Playlists[3].SongIDs).To(Equal([]string{"song_1", "song_1"}))
+		})
+
+		It("should repeat songs in every playlist when the mixtape allows duplicates", func() {
+			mixtape.AllowDuplicates = true
+			apply(models.PlaylistChange{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_1"}}})
+
+			Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"song_1", "song_2", "song_1"}))
+		})
+	})
+
+	Describe("remove_songs", func() {
+		remove := func(mode models.RemoveMode, songIDs []string, positions []int) models.PlaylistChange {
+			return models.PlaylistChange{ID: models.RemoveSongs, Mode: mode, Playlist: models.Playlist{ID: "playlist_2", SongIDs: songIDs}, Positions: positions}
+		}
+
+		Context("when the playlist does not exist", func() {
+			It("should not remove any songs, output a log, and continue", func() {
+				apply(models.PlaylistChange{ID: models.RemoveSongs, Playlist: models.Playlist{ID: "playlist_x", SongIDs: []string{"song_1"}}})
+				Expect(logs).To(gbytes.Say(`msg="skipped change" .* playlist_id=playlist_x reason="playlist_id not found"`))
+			})
+		})
+
+		Context("when the mode is unknown, or songs and positions are mixed", func() {
+			It("should not remove any songs, output a log, and continue", func() {
+				apply(
+					remove("last", []string{"song_1"}, nil),
+					remove(models.RemoveAt, []string{"song_1"}, []int{1}),
+					remove(models.RemoveAll, nil, []int{1}),
+				)
+				Expect(logs).To(gbytes.Say(`reason="mode must be first, all or position"`))
+				Expect(logs).To(gbytes.Say(`reason="songs are removed by song_ids or by positions, not both"`))
+				Expect(logs).To(gbytes.Say(`reason="songs are removed by song_ids or by positions, not both"`))
+				Expect(mixtape.Playlists[1].SongIDs).To(HaveLen(5))
+				Expect(m.Applied()).To(BeEmpty())
+			})
+		})
+
+		Context("by first occurrence, the default", func() {
+			It("should remove the first remaining occurrence for each song id, and skip songs not in the playlist", func() {
+				apply(remove("", []string{"song_1", "song_1", "song_2", "song_2"}, nil))
+
+				Expect(logs).To(gbytes.Say(`msg="removed song" .* playlist_id=playlist_2 song_id=song_1 position=1`))
+				Expect(logs).To(gbytes.Say(`msg="removed song" .* playlist_id=playlist_2 song_id=song_1 position=3`))
+				Expect(logs).To(gbytes.Say(`msg="skipped song" .* song_id=song_2 reason="song_id not in playlist"`))
+				Expect(mixtape.Playlists[1].SongIDs).To(Equal([]string{"song_3", "song_1"}))
+				Expect(mixtape.Playlists[1].Version).To(Equal(int64(1)))
+				Expect(m.Applied()).To(Equal([]models.PlaylistChange{
+					{ID: models.RemoveSongs, Playlist: models.Playlist{ID: "playlist_2", SongIDs: []string{"song_1", "song_1", "song_2"}}},
+				}))
+			})
+		})
+
+		Context("by all occurrences", func() {
+			It("should remove every occurrence of each song id", func() {
+				apply(remove(models.RemoveAll, []string{"song_1", "song_x"}, nil))
+
+				Expect(logs).To(gbytes.Say(`msg="skipped song" .* song_id=song_x reason="song_id not in playlist"`))
+				Expect(mixtape.Playlists[1].SongIDs).To(Equal([]string{"song_2", "song_3"}))
+				Expect(m.Report().Entries[0].Status).To(Equal(models.Applied))
+			})
+		})
+
+		Context("by position", func() {
+			It("should remove the songs at positions in the playlist as it was before the change, and skip invalid ones", func() {
+				apply(remove(models.RemoveAt, nil, []int{5, 1, 0, 6, 1}))
+
+				Expect(logs).To(gbytes.Say(`msg="removed song" .* song_id=song_1 position=5`))
+				Expect(mixtape.Playlists[1].SongIDs).To(Equal([]string{"song_2", "song_1", "song_3"}))
+				Expect(m.Report().Entries[0].SkippedPositions).To(Equal([]models.SkippedPosition{
+					{Position: 0, Reason: "position out of range"},
+					{Position: 6, Reason: "position out of range"},
+					{Position: 1, Reason: "position already removed"},
+				}))
+				Expect(m.Applied()[0].Positions).To(Equal([]int{5, 1}))
+			})
+		})
+
+		Context("when nothing is removed", func() {
+			It("should report nothing to apply", func() {
+				apply(remove(models.RemoveFirst, []string{"song_x"}, nil))
+				Expect(m.Report().Entries[0].Reason).To(Equal("nothing to apply"))
+				Expect(mixtape.Playlists[1].Version).To(BeZero())
+			})
+		})
+	})
+
+	Describe("auto_fill", func() {
+		fill := func(playlistID string, count int) models.PlaylistChange {
+			return models.PlaylistChange{ID: models.AutoFill, Playlist: models.Playlist{ID: playlistID}, Count: count}
+		}
+
+		It("should append the recommended songs, as an add_songs", func() {
+			apply(fill("playlist_1", 2))
+
+			Expect(logs).To(gbytes.Say(`msg="added song" change=auto_fill .* playlist_id=playlist_1 song_id=song_3`))
+			Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"song_1", "song_2", "song_3"}))
+			Expect(mixtape.Playlists[0].Version).To(Equal(int64(1)))
+			Expect(m.Report().Entries[0].Change).To(Equal(models.AutoFill))
+			Expect(m.Applied()).To(Equal([]models.PlaylistChange{
+				{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_3"}}},
+			}))
+		})
+
+		It("should skip a missing playlist, a count below 1, and a playlist with nothing to recommend", func() {
+			apply(fill("playlist_x", 1), fill("playlist_1", 0), fill("playlist_2", 1))
+
+			Expect(logs).To(gbytes.Say(`reason="playlist_id not found"`))
+			Expect(logs).To(gbytes.Say(`reason="count must be at least 1"`))
+			Expect(logs).To(gbytes.Say(`playlist_id=playlist_2 reason="no songs to recommend"`))
+			Expect(m.Applied()).To(BeEmpty())
+		})
+
+		It("should skip recommended songs that exceed the limits", func() {
+			m = mixtape_pkg.New(mixtape, newTestLogger(logs), mixtape_pkg.WithLimits(mixtape_pkg.Limits{MaxSongs: 2}))
+			apply(fill("playlist_1", 1))
+
+			Expect(m.Report().Entries[0].SkippedSongs).To(Equal([]models.SkippedSong{{SongID: "song_3", Reason: "playlist would exceed max songs"}}))
+			Expect(m.Report().Entries[0].Reason).To(Equal("nothing to apply"))
+		})
+	})
+})
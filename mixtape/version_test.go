@@ -0,0 +1,105 @@
+package mixtape_test
+
+import (
+	"errors"
+
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gbytes"
+)
+
+var _ = Describe("Versions", func() {
+	var (
+		mixtape *models.Mixtape
+		logs    *gbytes.Buffer
+		m       *mixtape_pkg.Mixtape
+	)
+
+	version := func(v int64) *int64 {
+		return &v
+	}
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{{ID: "user_1", Name: "test_user_1"}},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_1"}, Version: 4},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1"},
+				{ID: "song_2", Artist: "some_other_artist", Title: "test_song_2"},
+			},
+			Version: 10,
+		}
+		logs = gbytes.NewBuffer()
+		m = mixtape_pkg.New(mixtape, newTestLogger(logs))
+	})
+
+	It("should bump versions once per change that takes effect", func() {
+		err := m.ApplyChanges(&models.Changes{PlaylistChanges: []models.PlaylistChange{
+			{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_2", "song_1"}}},
+			{ID: models.Add, Playlist: models.Playlist{ID: "playlist_2", UserID: "user_1", SongIDs: []string{"song_1"}, Version: 7}},
+			{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_2", SongIDs: []string{"song_1"}}},
+		}})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(mixtape.Playlists[0].Version).To(Equal(int64(5)))
+		Expect(mixtape.Playlists[1].Version).To(Equal(int64(1)))
+		Expect(mixtape.Version).To(Equal(int64(12)))
+		Expect(m.Applied()[1].Playlist.Version).To(BeZero())
+		Expect(m.Report().Entries[0].Version).To(Equal(int64(5)))
+	})
+
+	It("should apply a change whose if_match is the current version", func() {
+		err := m.ApplyChanges(&models.Changes{PlaylistChanges: []models.PlaylistChange{
+			{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_2"}}, IfMatch: version(4)},
+			{ID: models.Add, Playlist: models.Playlist{ID: "playlist_2", UserID: "user_1", SongIDs: []string{"song_1"}}, IfMatch: version(0)},
+		}})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m.Applied()).To(HaveLen(2))
+	})
+
+	It("should skip a change based on a stale read", func() {
+		err := m.ApplyChanges(&models.Changes{PlaylistChanges: []models.PlaylistChange{
+			{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_1"}, IfMatch: version(3)},
+		}})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(mixtape.Playlists).To(HaveLen(1))
+		Expect(m.Report().Entries[0].Reason).To(Equal("if_match does not match playlist version"))
+		Expect(logs).To(gbytes.Say(`playlist_id=playlist_1 if_match=3 version=4 reason="if_match does not match playlist version"`))
+	})
+
+	It("should skip a change expecting no playlist when there is one without a version", func() {
+		mixtape.Playlists[0].Version = 0
+		m = mixtape_pkg.New(mixtape, newTestLogger(logs))
+		err := m.ApplyChanges(&models.Changes{PlaylistChanges: []models.PlaylistChange{
+			{ID: models.UpsertPlaylist, Playlist: models.Playlist{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_2"}}, IfMatch: version(0)},
+		}})
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(mixtape.Playlists[0].SongIDs).To(Equal([]string{"song_1"}))
+		Expect(logs).To(gbytes.Say(`playlist_id=playlist_1 if_match=0 version=0 reason="if_match does not match playlist version"`))
+	})
+
+	It("should apply nothing if the batch is based on another version of the mixtape", func() {
+		changes := &models.Changes{
+			PlaylistChanges: []models.PlaylistChange{
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_1"}},
+			},
+			IfMatch: version(9),
+		}
+		err := m.ApplyChanges(changes)
+		Expect(errors.Is(err, mixtape_pkg.ErrVersionMismatch)).To(BeTrue())
+		err = mixtape_pkg.New(mixtape, util.Discard).ApplyChangesParallel(changes, 2)
+		Expect(errors.Is(err, mixtape_pkg.ErrVersionMismatch)).To(BeTrue())
+		Expect(mixtape.Playlists).To(HaveLen(1))
+
+		changes.IfMatch = version(10)
+		Expect(m.ApplyChanges(changes)).To(Succeed())
+		Expect(mixtape.Playlists).To(BeEmpty())
+	})
+})
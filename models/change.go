@@ -4,15 +4,144 @@ const (
 	Add      PlaylistChangeID = "add"
 	Remove   PlaylistChangeID = "remove"
 	AddSongs PlaylistChangeID = "add_songs"
+	// Playlist.Collaborators holds the collaborators to add, or set the
+	// role of, and the user ids of the collaborators to remove
+	AddCollaborators    PlaylistChangeID = "add_collaborators"
+	RemoveCollaborators PlaylistChangeID = "remove_collaborators"
+	// Playlist.UserID holds the new owner
+	TransferOwnership PlaylistChangeID = "transfer_ownership"
+	// Playlist.Name, Description and Visibility hold the new values, empty
+	// ones are left unchanged
+	UpdatePlaylist PlaylistChangeID = "update_playlist"
+	// Playlist.SongIDs, or PlaylistChange.Positions, hold the songs to
+	// remove, see RemoveMode
+	RemoveSongs PlaylistChangeID = "remove_songs"
+	// PlaylistChange.Count holds the number of recommended songs to
+	// append, see recommend.Recommend. It is applied as the add_songs of
+	// the songs that were recommended.
+	AutoFill PlaylistChangeID = "auto_fill"
+	// PlaylistChange.Operation is applied to every playlist that
+	// PlaylistChange.Where matches, see selector.Parse, as if it were a
+	// change to each one
+	Bulk PlaylistChangeID = "bulk"
+	// Playlist holds the new playlist's id, and optionally its owner and
+	// name, which default to those of the playlist it copies, From
+	ClonePlaylist PlaylistChangeID = "clone_playlist"
+	// Playlist.ID is the playlist the songs of Sources are appended to
+	MergePlaylists PlaylistChangeID = "merge_playlists"
+	// Playlist.ID is the playlist whose songs are moved to new playlists,
+	// by Size or by Where, with the ids in Targets
+	SplitPlaylist PlaylistChangeID = "split_playlist"
+	// Playlist is added, see add, if there is no playlist with its id.
+	// Otherwise the playlist's owner and songs are replaced with
+	// Playlist.UserID and SongIDs, and the rest of it is left unchanged.
+	UpsertPlaylist PlaylistChangeID = "upsert_playlist"
+	// Playlist.SongIDs replace the playlist's songs, in their order
+	SetSongs PlaylistChangeID = "set_songs"
 )
 
 type PlaylistChangeID string
 
+// How remove_songs picks the songs to remove, which matters for playlists
+// that allow duplicates. RemoveFirst is the default.
+const (
+	// Each song id removes the first remaining occurrence of the song,
+	// so a song id given twice removes two occurrences
+	RemoveFirst RemoveMode = "first"
+	// Each song id removes every occurrence of the song
+	RemoveAll RemoveMode = "all"
+	// Positions, starting at 1, in the playlist as it was before the
+	// change
+	RemoveAt RemoveMode = "position"
+)
+
+type RemoveMode string
+
 type PlaylistChange struct {
 	ID       PlaylistChangeID `json:"id"`
 	Playlist Playlist         `json:"playlist"`
+	// Optional, the user id making the change, see policy.Policy
+	Actor string `json:"actor,omitempty"`
+	// Optional, set by whoever writes the changes file to trace a change
+	// through logs and reports
+	CorrelationID string `json:"correlation_id,omitempty"`
+	// Optional, a change with a key that was already processed is skipped,
+	// see dedupe.Store
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Optional, the version of the playlist the change was based on, 0 if
+	// it did not exist. The change is skipped if the playlist has changed
+	// since, or exists when 0 was given, even at version 0.
+	IfMatch *int64 `json:"if_match,omitempty"`
+	// Optional, checked against the playlist before the change is
+	// validated, see Preconditions
+	Preconditions *Preconditions `json:"preconditions,omitempty"`
+	// Only for remove_songs
+	Mode      RemoveMode `json:"mode,omitempty"`
+	Positions []int      `json:"positions,omitempty"`
+	// Only for auto_fill
+	Count int `json:"count,omitempty"`
+	// Only for bulk and split_playlist, a selector, see selector.Parse
+	Where string `json:"where,omitempty"`
+	// Only for bulk, any change to a single existing playlist, without a
+	// playlist id, which is filled in for each match
+	Operation *PlaylistChange `json:"operation,omitempty"`
+	// Only for clone_playlist
+	From string `json:"from,omitempty"`
+	// Only for merge_playlists, the playlists to merge, deleted once
+	// merged if DeleteSources is set
+	Sources       []string `json:"sources,omitempty"`
+	DeleteSources bool     `json:"delete_sources,omitempty"`
+	// Only for split_playlist, the number of songs in each playlist, or a
+	// selector that the songs to move match, see Where. Targets are the
+	// ids of the new playlists, in order.
+	Size    int      `json:"size,omitempty"`
+	Targets []string `json:"targets,omitempty"`
+	// Where the change was read from, eg. changes.json:12, set when the
+	// changes file is read
+	Source string `json:"-"`
+}
+
+// Ref identifies a change in logs and reports: its correlation ID if it
+// has one, else where it was read from.
+func (c PlaylistChange) Ref() string {
+	if c.CorrelationID != "" {
+		return c.CorrelationID
+	}
+	return c.Source
 }
 
+// Preconditions are conditions on the playlist with the change's id, as it
+// is when the change is applied, that must all hold for the change to be
+// applied. Conditions left empty always hold.
+type Preconditions struct {
+	// true if the playlist must exist, false if it must not
+	Exists *bool `json:"exists,omitempty"`
+	// the user id that must own the playlist
+	OwnedBy string `json:"owned_by,omitempty"`
+	// song ids that must all be in the playlist
+	Contains []string `json:"contains,omitempty"`
+	// the most songs the playlist may have, a playlist that does not
+	// exist has none
+	MaxSongs *int `json:"max_songs,omitempty"`
+	// Optional, what happens to the batch when a precondition fails,
+	// defaults to the batch's, see FailureAction
+	OnFailure FailureAction `json:"on_failure,omitempty"`
+}
+
+// What happens when a change's precondition fails. SkipChange is the
+// default.
+const (
+	// The change is skipped, like an invalid one, and the batch goes on
+	SkipChange FailureAction = "skip"
+	// The batch stops with an error, so nothing is written
+	FailBatch FailureAction = "fail"
+)
+
+type FailureAction string
+
 type Changes struct {
 	PlaylistChanges []PlaylistChange `json:"playlist_changes"`
+	// Optional, the version of the mixtape the changes were based on. No
+	// change is applied if the mixtape has changed since.
+	IfMatch *int64 `json:"if_match,omitempty"`
 }
@@ -1,24 +1,120 @@
 package models
 
+import "time"
+
 type User struct {
 	ID   string `json:"id"`
 	Name string `json:"name"`
 }
 
+// Everything past the title is optional. A song without a duration counts
+// as 0 towards the duration of its playlists.
 type Song struct {
-	ID     string `json:"id"`
-	Artist string `json:"artist"`
-	Title  string `json:"title"`
+	ID         string `json:"id"`
+	Artist     string `json:"artist"`
+	Title      string `json:"title"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Album      string `json:"album,omitempty"`
+	Genre      string `json:"genre,omitempty"`
+	Year       int    `json:"year,omitempty"`
+	ISRC       string `json:"isrc,omitempty"`
+}
+
+func (s Song) Duration() time.Duration {
+	return time.Duration(s.DurationMs) * time.Millisecond
 }
 
+// Versions start at 0 for documents written before they existed, and are
+// bumped on every change that takes effect, see PlaylistChange.IfMatch.
+// Playlists written before metadata existed have none: no name or
+// description, public visibility and zero timestamps.
 type Playlist struct {
-	ID      string   `json:"id"`
-	UserID  string   `json:"user_id"`
-	SongIDs []string `json:"song_ids"`
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	Name        string     `json:"name,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Visibility  Visibility `json:"visibility,omitempty"`
+	SongIDs     []string   `json:"song_ids"`
+	// Lets the same song appear more than once, see
+	// Mixtape.AllowDuplicates
+	AllowDuplicates bool `json:"allow_duplicates,omitempty"`
+	// Users besides the owner, at most once each
+	Collaborators []Collaborator `json:"collaborators,omitempty"`
+	Version       int64          `json:"version,omitempty"`
+	CreatedAt     time.Time      `json:"created_at,omitzero"`
+	UpdatedAt     time.Time      `json:"updated_at,omitzero"`
+}
+
+const (
+	Public  Visibility = "public"
+	Private Visibility = "private"
+)
+
+type Visibility string
+
+// IsPublic is true unless the playlist was made private, so playlists
+// without a visibility are public.
+func (p Playlist) IsPublic() bool {
+	return p.Visibility != Private
+}
+
+// EqualSongIDs tells whether two lists of song IDs have the same songs in
+// the same order, eg. whether setting a playlist's songs changes them.
+func EqualSongIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Editors can change a playlist's songs, viewers can only see it. Only the
+// owner can change who collaborates on it, and transfer it.
+const (
+	Editor Role = "editor"
+	Viewer Role = "viewer"
+)
+
+type Role string
+
+type Collaborator struct {
+	UserID string `json:"user_id"`
+	Role   Role   `json:"role"`
+}
+
+// DiffCollaborators returns the collaborators to remove, by user id, and
+// the ones to add or change the role of, for a playlist's collaborators to
+// go from existing to desired.
+func DiffCollaborators(existing, desired []Collaborator) (removed, added []Collaborator) {
+	roles := map[string]Role{}
+	for _, collaborator := range existing {
+		roles[collaborator.UserID] = collaborator.Role
+	}
+	wanted := map[string]bool{}
+	for _, collaborator := range desired {
+		wanted[collaborator.UserID] = true
+		if role, exist := roles[collaborator.UserID]; !exist || role != collaborator.Role {
+			added = append(added, collaborator)
+		}
+	}
+	for _, collaborator := range existing {
+		if !wanted[collaborator.UserID] {
+			removed = append(removed, Collaborator{UserID: collaborator.UserID})
+		}
+	}
+	return removed, added
 }
 
 type Mixtape struct {
 	Users     []User     `json:"users"`
 	Playlists []Playlist `json:"playlists"`
 	Songs     []Song     `json:"songs"`
+	Version   int64      `json:"version,omitempty"`
+	// Lets every playlist have the same song more than once. Otherwise
+	// only playlists that allow duplicates themselves can.
+	AllowDuplicates bool `json:"allow_duplicates,omitempty"`
 }
@@ -0,0 +1,66 @@
+package models
+
+// A report has one entry per change in a batch, in order, saying whether
+// it was applied and why not. Entries carry the change's correlation ID and
+// source, so they can be traced back to the changes file.
+type Report struct {
+	Entries []ReportEntry `json:"entries"`
+}
+
+const (
+	Applied ChangeStatus = "applied"
+	Skipped ChangeStatus = "skipped"
+)
+
+type ChangeStatus string
+
+type ReportEntry struct {
+	ChangeIndex   int              `json:"change_index"`
+	CorrelationID string           `json:"correlation_id,omitempty"`
+	Source        string           `json:"source,omitempty"`
+	Change        PlaylistChangeID `json:"change"`
+	Actor         string           `json:"actor,omitempty"`
+	PlaylistID    string           `json:"playlist_id"`
+	Status        ChangeStatus     `json:"status"`
+	Reason        string           `json:"reason,omitempty"`
+	// the playlist's version after the change, for the if_match of the
+	// next one
+	Version int64 `json:"version,omitempty"`
+	// songs left out of a change that was otherwise applied
+	SkippedSongs []SkippedSong `json:"skipped_songs,omitempty"`
+	// positions left out of a remove_songs change that was otherwise
+	// applied
+	SkippedPositions []SkippedPosition `json:"skipped_positions,omitempty"`
+	// collaborators left out of a change that was otherwise applied
+	SkippedCollaborators []SkippedCollaborator `json:"skipped_collaborators,omitempty"`
+	// source playlists left out of a merge_playlists change that was
+	// otherwise applied, or not deleted
+	SkippedPlaylists []SkippedPlaylist `json:"skipped_playlists,omitempty"`
+	// songs that an upsert_playlist or set_songs change added to, and
+	// dropped from, the playlist's songs as they were before it
+	AddedSongs   []string `json:"added_songs,omitempty"`
+	DroppedSongs []string `json:"dropped_songs,omitempty"`
+	// the entries of a bulk change's operation on each playlist it
+	// matched, in the order they were applied
+	Targets []ReportEntry `json:"targets,omitempty"`
+}
+
+type SkippedSong struct {
+	SongID string `json:"song_id"`
+	Reason string `json:"reason"`
+}
+
+type SkippedPosition struct {
+	Position int    `json:"position"`
+	Reason   string `json:"reason"`
+}
+
+type SkippedCollaborator struct {
+	UserID string `json:"user_id"`
+	Reason string `json:"reason"`
+}
+
+type SkippedPlaylist struct {
+	PlaylistID string `json:"playlist_id"`
+	Reason     string `json:"reason"`
+}
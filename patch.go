@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/n4wei/highspot/fileio"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/patch"
+)
+
+// Formats accepted for the changes file
+const (
+	changesFormat    = "changes"
+	jsonPatchFormat  = "json-patch"
+	mergePatchFormat = "merge-patch"
+)
+
+// readChanges reads the changes file in the given format. Patches are
+// translated into changes against the mixtape they will be applied to.
+// Every change is given its source in the file, see sourceLines.
+func readChanges(filepath, format string, mixtape *models.Mixtape) (*models.Changes, error) {
+	data, err := readAll(filepath)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath
+	if filepath == fileio.Stdio {
+		name = "stdin"
+	}
+
+	var changes *models.Changes
+	var lines []int
+	switch format {
+	case changesFormat:
+		changes = &models.Changes{}
+		err = json.Unmarshal(data, changes)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling %s to JSON: %v", filepath, err)
+		}
+		lines, err = sourceLines(data, "playlist_changes")
+	case jsonPatchFormat:
+		p := patch.Patch{}
+		err = json.Unmarshal(data, &p)
+		if err != nil {
+			return nil, fmt.Errorf("error unmarshaling %s to JSON: %v", filepath, err)
+		}
+		changes, err = patch.NewDocument(mixtape).ToChanges(p)
+		if err != nil {
+			return nil, err
+		}
+		// one change per operation
+		lines, err = sourceLines(data, "")
+	case mergePatchFormat:
+		// changes come from a diff, not from a line of the file
+		changes, err = patch.MergeToChanges(mixtape, data)
+	default:
+		return nil, fmt.Errorf("unknown changes format %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range changes.PlaylistChanges {
+		source := name
+		if i < len(lines) {
+			source = fmt.Sprintf("%s:%d", name, lines[i])
+		}
+		changes.PlaylistChanges[i].Source = source
+	}
+	return changes, nil
+}
+
+// sourceLines returns the line on which each element of an array starts.
+// The array is the given member of the top level object, or the top level
+// itself if member is empty. The JSON must already be known to be valid.
+func sourceLines(data []byte, member string) ([]int, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if member != "" {
+		_, err := dec.Token() // {
+		if err != nil {
+			return nil, err
+		}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			if key == member {
+				break
+			}
+			skip := json.RawMessage{}
+			err = dec.Decode(&skip)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	delim, err := dec.Token()
+	if err != nil || delim != json.Delim('[') {
+		// eg. "playlist_changes": null
+		return nil, err
+	}
+
+	lines := []int{}
+	for dec.More() {
+		// the offset is right after the previous element, before the
+		// comma and whitespace leading up to this one
+		start := int(dec.InputOffset())
+		for start < len(data) && strings.ContainsRune(", \t\r\n", rune(data[start])) {
+			start++
+		}
+		lines = append(lines, 1+bytes.Count(data[:start], []byte("\n")))
+
+		skip := json.RawMessage{}
+		err = dec.Decode(&skip)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
+
+// writePatch writes the changes that took effect as a JSON Patch, see
+// patch.Document.FromChanges.
+func writePatch(p patch.Patch, filepath string) error {
+	w, err := fileio.Create(filepath, fileio.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	err = json.NewEncoder(w).Encode(p)
+	if err != nil {
+		w.Close()
+		return fmt.Errorf("error marshaling JSON Patch: %v", err)
+	}
+	return w.Close()
+}
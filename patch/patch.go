@@ -0,0 +1,542 @@
+package patch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/n4wei/highspot/models"
+)
+
+// JSON Patch (RFC 6902) and JSON Merge Patch (RFC 7396) documents are not
+// applied to the mixtape JSON directly. They are translated into changes,
+// so that they go through the same validation as a changes file and keep
+// the lookup hash maps consistent.
+//
+// Playlists are addressed by array index, as in RFC 6901, so a patch means
+// the same to this tool as to any other. The index is resolved against the
+// playlists array as it is in the mixtape JSON, and as the earlier
+// operations of the patch leave it, see Document. The supported JSON Patch
+// operations are
+//
+//	{"op": "add", "path": "/playlists/-", "value": <playlist>}       add
+//	{"op": "add", "path": "/playlists/<index>", "value": <playlist>} add
+//	{"op": "remove", "path": "/playlists/<index>"}                   remove
+//	{"op": "add", "path": "/playlists/<index>/song_ids/-", "value": <song id>}  add_songs
+//	{"op": "remove", "path": "/playlists/<index>/song_ids/<index>"}  remove_songs by position
+//
+// Anything else, such as replace, move, editing users or songs, or
+// inserting a song at an index in a playlist, can not be mapped to a
+// change and is rejected.
+
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+type Patch []Operation
+
+const (
+	opAdd     = "add"
+	opRemove  = "remove"
+	opReplace = "replace"
+
+	playlistsMember     = "playlists"
+	songIDsMember       = "song_ids"
+	userIDMember        = "user_id"
+	collaboratorsMember = "collaborators"
+	appendToken         = "-"
+)
+
+// Document is the playlists array of a mixtape JSON, as a JSON Patch sees
+// it. Removing a playlist shifts the ones after it down by one, unlike
+// mixtape.Mixtape, which swaps it with the last one, so the index of a
+// playlist in the document and in the mixtape differ after a remove. The
+// document follows the patch or the changes it translates, so that the
+// indices of later operations hold.
+type Document struct {
+	playlists []models.Playlist
+}
+
+// NewDocument returns the document of the mixtape as it is now. The
+// playlists are copied, so changes applied to the mixtape afterwards do
+// not show in the document.
+func NewDocument(mixtape *models.Mixtape) *Document {
+	d := &Document{playlists: make([]models.Playlist, len(mixtape.Playlists))}
+	for i, playlist := range mixtape.Playlists {
+		d.playlists[i] = copyPlaylist(playlist)
+	}
+	return d
+}
+
+// ToChanges translates a JSON Patch into changes. It fails on the first
+// operation that can not be mapped, so a patch is applied entirely or not
+// at all. Indices are resolved as if every earlier operation took effect,
+// which is what a tool applying the patch atomically does.
+func (d *Document) ToChanges(patch Patch) (*models.Changes, error) {
+	changes := &models.Changes{PlaylistChanges: []models.PlaylistChange{}}
+	for i, op := range patch {
+		change, err := d.toChange(op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %v", i, op.Op, op.Path, err)
+		}
+		changes.PlaylistChanges = append(changes.PlaylistChanges, change)
+	}
+	return changes, nil
+}
+
+func (d *Document) toChange(op Operation) (models.PlaylistChange, error) {
+	tokens, err := parsePointer(op.Path)
+	if err != nil {
+		return models.PlaylistChange{}, err
+	}
+	if len(tokens) == 0 || tokens[0] != playlistsMember {
+		return models.PlaylistChange{}, errors.New("only paths under /playlists can be mapped to changes")
+	}
+
+	switch {
+	case op.Op == opAdd && len(tokens) == 2:
+		playlist := models.Playlist{}
+		err = json.Unmarshal(op.Value, &playlist)
+		if err != nil {
+			return models.PlaylistChange{}, fmt.Errorf("value is not a playlist: %v", err)
+		}
+		if playlist.ID == "" {
+			return models.PlaylistChange{}, errors.New("value has no playlist id")
+		}
+		// add may insert at the end, so the index can be the length
+		i := len(d.playlists)
+		if tokens[1] != appendToken {
+			i, err = index(tokens[1], len(d.playlists)+1)
+			if err != nil {
+				return models.PlaylistChange{}, err
+			}
+		}
+		d.insert(i, playlist)
+		return models.PlaylistChange{ID: models.Add, Playlist: playlist}, nil
+
+	case op.Op == opRemove && len(tokens) == 2:
+		i, err := index(tokens[1], len(d.playlists))
+		if err != nil {
+			return models.PlaylistChange{}, err
+		}
+		id := d.playlists[i].ID
+		d.remove(i)
+		return models.PlaylistChange{ID: models.Remove, Playlist: models.Playlist{ID: id}}, nil
+
+	case op.Op == opAdd && len(tokens) == 4 && tokens[2] == songIDsMember:
+		i, err := index(tokens[1], len(d.playlists))
+		if err != nil {
+			return models.PlaylistChange{}, err
+		}
+		if tokens[3] != appendToken {
+			return models.PlaylistChange{}, errors.New("songs can only be appended with /-, not inserted at an index")
+		}
+		var songID string
+		err = json.Unmarshal(op.Value, &songID)
+		if err != nil {
+			return models.PlaylistChange{}, fmt.Errorf("value is not a song id: %v", err)
+		}
+		playlist := &d.playlists[i]
+		playlist.SongIDs = append(playlist.SongIDs, songID)
+		return models.PlaylistChange{
+			ID:       models.AddSongs,
+			Playlist: models.Playlist{ID: playlist.ID, SongIDs: []string{songID}},
+		}, nil
+
+	case op.Op == opRemove && len(tokens) == 4 && tokens[2] == songIDsMember:
+		i, err := index(tokens[1], len(d.playlists))
+		if err != nil {
+			return models.PlaylistChange{}, err
+		}
+		playlist := &d.playlists[i]
+		j, err := index(tokens[3], len(playlist.SongIDs))
+		if err != nil {
+			return models.PlaylistChange{}, fmt.Errorf("songs can only be removed at an index: %v", err)
+		}
+		playlist.SongIDs = append(playlist.SongIDs[:j], playlist.SongIDs[j+1:]...)
+		return models.PlaylistChange{
+			ID:        models.RemoveSongs,
+			Mode:      models.RemoveAt,
+			Playlist:  models.Playlist{ID: playlist.ID},
+			Positions: []int{j + 1},
+		}, nil
+	}
+
+	return models.PlaylistChange{}, errors.New("operation can not be mapped to a change")
+}
+
+// FromChanges is the reverse of ToChanges. It is meant for the changes that
+// took effect in a run, see mixtape.Mixtape.Applied, so that other tools can
+// replay them; the document must then be the one of the mixtape before the
+// run. Each song of an add_songs change becomes its own operation, and so
+// does each position of a remove_songs change, from the last one so that
+// the indices of the others still hold. Other remove_songs modes depend on
+// the playlist's songs and can not be expressed. The other changes to a
+// playlist set the members they change: set_songs and upsert_playlist its
+// song_ids, update_playlist its name, description or visibility, and the
+// collaborator changes and transfers its collaborators array, whole, along
+// with user_id. Such patches can be applied by any JSON Patch tool, but
+// ToChanges only maps the operations listed above.
+func (d *Document) FromChanges(changes []models.PlaylistChange) (Patch, error) {
+	patch := Patch{}
+	for _, change := range changes {
+		if change.ID == models.Add {
+			value, err := json.Marshal(change.Playlist)
+			if err != nil {
+				return nil, err
+			}
+			patch = append(patch, Operation{Op: opAdd, Path: "/playlists/-", Value: value})
+			d.insert(len(d.playlists), change.Playlist)
+			continue
+		}
+
+		i := d.index(change.Playlist.ID)
+		if i < 0 {
+			return nil, fmt.Errorf("change %s: playlist_id %s is not in the document", change.ID, change.Playlist.ID)
+		}
+		path := "/playlists/" + strconv.Itoa(i)
+		playlist := &d.playlists[i]
+		switch change.ID {
+		case models.Remove:
+			patch = append(patch, Operation{Op: opRemove, Path: path})
+			d.remove(i)
+		case models.AddSongs:
+			for _, songID := range change.Playlist.SongIDs {
+				value, err := json.Marshal(songID)
+				if err != nil {
+					return nil, err
+				}
+				patch = append(patch, Operation{Op: opAdd, Path: path + "/song_ids/-", Value: value})
+			}
+			playlist.SongIDs = append(playlist.SongIDs, change.Playlist.SongIDs...)
+		case models.RemoveSongs:
+			if change.Mode != models.RemoveAt {
+				return nil, fmt.Errorf("change %s without mode %s can not be expressed as JSON Patch", change.ID, models.RemoveAt)
+			}
+			positions := append([]int{}, change.Positions...)
+			sort.Sort(sort.Reverse(sort.IntSlice(positions)))
+			for _, position := range positions {
+				if position < 1 || position > len(playlist.SongIDs) {
+					return nil, fmt.Errorf("change %s: position %d is not in playlist_id %s of the document", change.ID, position, playlist.ID)
+				}
+				patch = append(patch, Operation{Op: opRemove, Path: path + "/song_ids/" + strconv.Itoa(position-1)})
+				playlist.SongIDs = append(playlist.SongIDs[:position-1], playlist.SongIDs[position:]...)
+			}
+		case models.SetSongs, models.UpsertPlaylist:
+			if change.ID == models.UpsertPlaylist && change.Playlist.UserID != playlist.UserID {
+				ops, err := transfer(path, playlist, change.Playlist.UserID)
+				if err != nil {
+					return nil, err
+				}
+				patch = append(patch, ops...)
+			}
+			if !models.EqualSongIDs(playlist.SongIDs, change.Playlist.SongIDs) {
+				op, err := set(path, songIDsMember, true, change.Playlist.SongIDs)
+				if err != nil {
+					return nil, err
+				}
+				patch = append(patch, op)
+				playlist.SongIDs = append([]string{}, change.Playlist.SongIDs...)
+			}
+		case models.UpdatePlaylist:
+			// only the fields that changed are recorded
+			fields := []struct {
+				member string
+				from   *string
+				to     string
+			}{
+				{"name", &playlist.Name, change.Playlist.Name},
+				{"description", &playlist.Description, change.Playlist.Description},
+				{"visibility", (*string)(&playlist.Visibility), string(change.Playlist.Visibility)},
+			}
+			for _, field := range fields {
+				if field.to == "" {
+					continue
+				}
+				op, err := set(path, field.member, *field.from != "", field.to)
+				if err != nil {
+					return nil, err
+				}
+				patch = append(patch, op)
+				*field.from = field.to
+			}
+		case models.AddCollaborators, models.RemoveCollaborators:
+			had := len(playlist.Collaborators) > 0
+			for _, collaborator := range change.Playlist.Collaborators {
+				if change.ID == models.AddCollaborators {
+					setCollaborator(playlist, collaborator)
+				} else {
+					deleteCollaborator(playlist, collaborator.UserID)
+				}
+			}
+			op, err := setCollaborators(path, had, playlist.Collaborators)
+			if err != nil {
+				return nil, err
+			}
+			patch = append(patch, op)
+		case models.TransferOwnership:
+			ops, err := transfer(path, playlist, change.Playlist.UserID)
+			if err != nil {
+				return nil, err
+			}
+			patch = append(patch, ops...)
+		default:
+			return nil, fmt.Errorf("change %s can not be expressed as JSON Patch", change.ID)
+		}
+	}
+	return patch, nil
+}
+
+// set returns the operation that sets a member of the playlist at path:
+// replace if the member is in the JSON, or add if it is left out for being
+// empty, since replace requires the member to exist.
+func set(path, member string, present bool, value interface{}) (Operation, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return Operation{}, err
+	}
+	op := opAdd
+	if present {
+		op = opReplace
+	}
+	return Operation{Op: op, Path: path + "/" + member, Value: data}, nil
+}
+
+// setCollaborators returns the operation that sets the collaborators of the
+// playlist at path, which are left out of the JSON once there are none.
+func setCollaborators(path string, had bool, collaborators []models.Collaborator) (Operation, error) {
+	if len(collaborators) == 0 {
+		return Operation{Op: opRemove, Path: path + "/" + collaboratorsMember}, nil
+	}
+	return set(path, collaboratorsMember, had, collaborators)
+}
+
+// transfer returns the operations that make userID the owner of the
+// playlist at path, which then has the collaborators that
+// mixtape.Mixtape.transfer leaves: the new owner stops being one, and the
+// previous owner becomes an editor.
+func transfer(path string, playlist *models.Playlist, userID string) ([]Operation, error) {
+	had := len(playlist.Collaborators) > 0
+	previous := playlist.UserID
+	deleteCollaborator(playlist, userID)
+	playlist.UserID = userID
+	setCollaborator(playlist, models.Collaborator{UserID: previous, Role: models.Editor})
+
+	owner, err := set(path, userIDMember, true, userID)
+	if err != nil {
+		return nil, err
+	}
+	collaborators, err := setCollaborators(path, had, playlist.Collaborators)
+	if err != nil {
+		return nil, err
+	}
+	return []Operation{owner, collaborators}, nil
+}
+
+// setCollaborator changes the role of a collaborator, or appends a new one,
+// as mixtape.Mixtape.setCollaborator does.
+func setCollaborator(playlist *models.Playlist, collaborator models.Collaborator) {
+	for i := range playlist.Collaborators {
+		if playlist.Collaborators[i].UserID == collaborator.UserID {
+			playlist.Collaborators[i].Role = collaborator.Role
+			return
+		}
+	}
+	playlist.Collaborators = append(playlist.Collaborators, collaborator)
+}
+
+// deleteCollaborator keeps the order of the remaining collaborators.
+func deleteCollaborator(playlist *models.Playlist, userID string) {
+	for i, collaborator := range playlist.Collaborators {
+		if collaborator.UserID == userID {
+			playlist.Collaborators = append(playlist.Collaborators[:i], playlist.Collaborators[i+1:]...)
+			return
+		}
+	}
+}
+
+func (d *Document) index(id string) int {
+	for i, playlist := range d.playlists {
+		if playlist.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (d *Document) insert(i int, playlist models.Playlist) {
+	d.playlists = append(d.playlists, models.Playlist{})
+	copy(d.playlists[i+1:], d.playlists[i:])
+	d.playlists[i] = copyPlaylist(playlist)
+}
+
+// copyPlaylist copies the arrays the document changes in place.
+func copyPlaylist(playlist models.Playlist) models.Playlist {
+	playlist.SongIDs = append([]string{}, playlist.SongIDs...)
+	playlist.Collaborators = append([]models.Collaborator{}, playlist.Collaborators...)
+	return playlist
+}
+
+func (d *Document) remove(i int) {
+	d.playlists = append(d.playlists[:i], d.playlists[i+1:]...)
+}
+
+// MergeToChanges translates a JSON Merge Patch into the changes that turn
+// the current mixtape into the patched one. Only the playlists member can
+// be patched. Since a merge patch replaces arrays whole, the patched
+// playlists are complete, and are compared to the current ones by ID:
+// missing playlists are removed and new ones are added. For an existing
+// playlist, songs appended to the end are added to it, a different name,
+// description or visibility is an update_playlist, and collaborators are
+// removed and added, all of them if the patched playlist has none. Changing
+// the user or allow_duplicates of a playlist, clearing its name or
+// description, or removing or reordering its songs, has no matching change
+// and is rejected. Versions and timestamps are ignored, the changes set
+// them. The order of the playlists array itself is not preserved.
+func MergeToChanges(mixtape *models.Mixtape, mergePatch json.RawMessage) (*models.Changes, error) {
+	members := map[string]json.RawMessage{}
+	err := json.Unmarshal(mergePatch, &members)
+	if err != nil {
+		return nil, fmt.Errorf("merge patch is not a JSON object: %v", err)
+	}
+
+	changes := &models.Changes{PlaylistChanges: []models.PlaylistChange{}}
+	for member, value := range members {
+		if member != playlistsMember {
+			return nil, fmt.Errorf("member %s can not be mapped to changes, only %s can", member, playlistsMember)
+		}
+
+		// null deletes the member, ie. removes every playlist
+		desired := []models.Playlist{}
+		if string(value) != "null" {
+			err = json.Unmarshal(value, &desired)
+			if err != nil {
+				return nil, fmt.Errorf("%s is not an array of playlists: %v", member, err)
+			}
+		}
+
+		changes.PlaylistChanges, err = diffPlaylists(mixtape.Playlists, desired)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return changes, nil
+}
+
+func diffPlaylists(current, desired []models.Playlist) ([]models.PlaylistChange, error) {
+	currentByID := map[string]models.Playlist{}
+	for _, playlist := range current {
+		currentByID[playlist.ID] = playlist
+	}
+	desiredIDs := map[string]bool{}
+	for _, playlist := range desired {
+		if desiredIDs[playlist.ID] {
+			return nil, fmt.Errorf("playlist_id %s appears more than once", playlist.ID)
+		}
+		desiredIDs[playlist.ID] = true
+	}
+
+	changes := []models.PlaylistChange{}
+	for _, playlist := range current {
+		if !desiredIDs[playlist.ID] {
+			changes = append(changes, models.PlaylistChange{ID: models.Remove, Playlist: models.Playlist{ID: playlist.ID}})
+		}
+	}
+
+	for _, playlist := range desired {
+		existing, exist := currentByID[playlist.ID]
+		if !exist {
+			changes = append(changes, models.PlaylistChange{ID: models.Add, Playlist: playlist})
+			continue
+		}
+
+		if playlist.UserID != existing.UserID {
+			return nil, fmt.Errorf("playlist_id %s: changing user_id can not be mapped to changes", playlist.ID)
+		}
+		if playlist.AllowDuplicates != existing.AllowDuplicates {
+			return nil, fmt.Errorf("playlist_id %s: changing allow_duplicates can not be mapped to changes", playlist.ID)
+		}
+
+		// the same fields update_playlist changes, which can not clear one
+		updated := models.Playlist{ID: playlist.ID}
+		if playlist.Name != existing.Name {
+			if playlist.Name == "" {
+				return nil, fmt.Errorf("playlist_id %s: clearing name can not be mapped to changes", playlist.ID)
+			}
+			updated.Name = playlist.Name
+		}
+		if playlist.Description != existing.Description {
+			if playlist.Description == "" {
+				return nil, fmt.Errorf("playlist_id %s: clearing description can not be mapped to changes", playlist.ID)
+			}
+			updated.Description = playlist.Description
+		}
+		if playlist.IsPublic() != existing.IsPublic() {
+			// no visibility is public
+			updated.Visibility = models.Public
+			if !playlist.IsPublic() {
+				updated.Visibility = models.Private
+			}
+		}
+		if updated.Name != "" || updated.Description != "" || updated.Visibility != "" {
+			changes = append(changes, models.PlaylistChange{ID: models.UpdatePlaylist, Playlist: updated})
+		}
+
+		removed, added := models.DiffCollaborators(existing.Collaborators, playlist.Collaborators)
+		if len(removed) > 0 {
+			changes = append(changes, models.PlaylistChange{ID: models.RemoveCollaborators, Playlist: models.Playlist{ID: playlist.ID, Collaborators: removed}})
+		}
+		if len(added) > 0 {
+			changes = append(changes, models.PlaylistChange{ID: models.AddCollaborators, Playlist: models.Playlist{ID: playlist.ID, Collaborators: added}})
+		}
+
+		if len(playlist.SongIDs) < len(existing.SongIDs) {
+			return nil, fmt.Errorf("playlist_id %s: removing songs can not be mapped to changes", playlist.ID)
+		}
+		for i, songID := range existing.SongIDs {
+			if playlist.SongIDs[i] != songID {
+				return nil, fmt.Errorf("playlist_id %s: reordering or replacing songs can not be mapped to changes", playlist.ID)
+			}
+		}
+		if added := playlist.SongIDs[len(existing.SongIDs):]; len(added) > 0 {
+			changes = append(changes, models.PlaylistChange{
+				ID:       models.AddSongs,
+				Playlist: models.Playlist{ID: playlist.ID, SongIDs: added},
+			})
+		}
+	}
+	return changes, nil
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("path %q does not start with /", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// index parses an array index token, which must be in [0, length).
+func index(token string, length int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || token != strconv.Itoa(i) {
+		return 0, fmt.Errorf("%q is not an array index", token)
+	}
+	if i >= length {
+		return 0, fmt.Errorf("index %d is out of range [0, %d)", i, length)
+	}
+	return i, nil
+}
@@ -0,0 +1,236 @@
+package patch_test
+
+import (
+	"encoding/json"
+	"time"
+
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/patch"
+	"github.com/n4wei/highspot/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Patch", func() {
+	var mixtape *models.Mixtape
+
+	newMixtape := func() *models.Mixtape {
+		return &models.Mixtape{
+			Users: []models.User{
+				{ID: "user_1", Name: "test_user_1"},
+				{ID: "user_2", Name: "test_user_2"},
+			},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_1"}},
+				{ID: "playlist_2", UserID: "user_2", SongIDs: []string{"song_2"}},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1"},
+				{ID: "song_2", Artist: "some_other_artist", Title: "test_song_2"},
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		mixtape = newMixtape()
+	})
+
+	parse := func(document string) patch.Patch {
+		p := patch.Patch{}
+		Expect(json.Unmarshal([]byte(document), &p)).To(Succeed())
+		return p
+	}
+
+	Describe("ToChanges", func() {
+		It("should map supported operations to changes", func() {
+			changes, err := patch.NewDocument(mixtape).ToChanges(parse(`[
+				{"op": "add", "path": "/playlists/-", "value": {"id": "playlist_3", "user_id": "user_1", "song_ids": ["song_2"]}},
+				{"op": "add", "path": "/playlists/0", "value": {"id": "playlist_4", "user_id": "user_2", "song_ids": ["song_1"]}},
+				{"op": "remove", "path": "/playlists/1"},
+				{"op": "add", "path": "/playlists/1/song_ids/-", "value": "song_1"},
+				{"op": "remove", "path": "/playlists/1/song_ids/0"}
+			]`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes.PlaylistChanges).To(Equal([]models.PlaylistChange{
+				{ID: models.Add, Playlist: models.Playlist{ID: "playlist_3", UserID: "user_1", SongIDs: []string{"song_2"}}},
+				{ID: models.Add, Playlist: models.Playlist{ID: "playlist_4", UserID: "user_2", SongIDs: []string{"song_1"}}},
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_1"}},
+				{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_2", SongIDs: []string{"song_1"}}},
+				{ID: models.RemoveSongs, Mode: models.RemoveAt, Playlist: models.Playlist{ID: "playlist_2"}, Positions: []int{1}},
+			}))
+		})
+
+		It("should shift the playlists after a removed one down, as RFC 6902 does", func() {
+			changes, err := patch.NewDocument(mixtape).ToChanges(parse(`[
+				{"op": "remove", "path": "/playlists/0"},
+				{"op": "remove", "path": "/playlists/0"}
+			]`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes.PlaylistChanges).To(Equal([]models.PlaylistChange{
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_1"}},
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_2"}},
+			}))
+		})
+
+		expectRejected := func(document, message string) {
+			_, err := patch.NewDocument(mixtape).ToChanges(parse(document))
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		}
+
+		It("should reject operations that can not be mapped", func() {
+			expectRejected(`[{"op": "replace", "path": "/playlists/0", "value": {}}]`, "can not be mapped")
+			expectRejected(`[{"op": "move", "from": "/playlists/0", "path": "/playlists/1"}]`, "can not be mapped")
+			expectRejected(`[{"op": "add", "path": "/users/-", "value": {"id": "user_3"}}]`, "only paths under /playlists")
+			expectRejected(`[{"op": "add", "path": "/playlists/0/song_ids/0", "value": "song_2"}]`, "not inserted at an index")
+			expectRejected(`[{"op": "remove", "path": "/playlists/0/song_ids/-"}]`, "only be removed at an index")
+			expectRejected(`[{"op": "add", "path": "/playlists/-", "value": {"user_id": "user_1"}}]`, "no playlist id")
+		})
+
+		It("should reject paths that do not address a playlist by index", func() {
+			expectRejected(`[{"op": "remove", "path": "/playlists/playlist_1"}]`, `"playlist_1" is not an array index`)
+			expectRejected(`[{"op": "remove", "path": "/playlists/01"}]`, `"01" is not an array index`)
+			expectRejected(`[{"op": "remove", "path": "/playlists/2"}]`, "index 2 is out of range [0, 2)")
+			expectRejected(`[{"op": "remove", "path": "/playlists/0/song_ids/1"}]`, "index 1 is out of range [0, 1)")
+		})
+
+		It("should name the operation that failed", func() {
+			_, err := patch.NewDocument(mixtape).ToChanges(parse(`[{"op": "remove", "path": "/playlists/0"}, {"op": "test", "path": "/playlists"}]`))
+			Expect(err).To(MatchError(HavePrefix("operation 1 (test /playlists)")))
+		})
+	})
+
+	Describe("MergeToChanges", func() {
+		It("should diff the patched playlists against the current ones", func() {
+			changes, err := patch.MergeToChanges(mixtape, json.RawMessage(`{"playlists": [
+				{"id": "playlist_2", "user_id": "user_2", "song_ids": ["song_2", "song_1"]},
+				{"id": "playlist_3", "user_id": "user_1", "song_ids": ["song_1"]}
+			]}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes.PlaylistChanges).To(Equal([]models.PlaylistChange{
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_1"}},
+				{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_2", SongIDs: []string{"song_1"}}},
+				{ID: models.Add, Playlist: models.Playlist{ID: "playlist_3", UserID: "user_1", SongIDs: []string{"song_1"}}},
+			}))
+		})
+
+		It("should update the metadata and collaborators of existing playlists", func() {
+			mixtape.Playlists[0].Name = "test_name"
+			mixtape.Playlists[0].Collaborators = []models.Collaborator{{UserID: "user_2", Role: models.Viewer}}
+			mixtape.Playlists[1].Visibility = models.Private
+			mixtape.Playlists[1].Collaborators = []models.Collaborator{{UserID: "user_1", Role: models.Editor}}
+
+			changes, err := patch.MergeToChanges(mixtape, json.RawMessage(`{"playlists": [
+				{"id": "playlist_1", "user_id": "user_1", "name": "test_other_name", "description": "test_description", "song_ids": ["song_1"], "collaborators": [{"user_id": "user_2", "role": "editor"}]},
+				{"id": "playlist_2", "user_id": "user_2", "song_ids": ["song_2"]}
+			]}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes.PlaylistChanges).To(Equal([]models.PlaylistChange{
+				{ID: models.UpdatePlaylist, Playlist: models.Playlist{ID: "playlist_1", Name: "test_other_name", Description: "test_description"}},
+				{ID: models.AddCollaborators, Playlist: models.Playlist{ID: "playlist_1", Collaborators: []models.Collaborator{{UserID: "user_2", Role: models.Editor}}}},
+				{ID: models.UpdatePlaylist, Playlist: models.Playlist{ID: "playlist_2", Visibility: models.Public}},
+				{ID: models.RemoveCollaborators, Playlist: models.Playlist{ID: "playlist_2", Collaborators: []models.Collaborator{{UserID: "user_1"}}}},
+			}))
+		})
+
+		It("should remove every playlist when playlists is null", func() {
+			changes, err := patch.MergeToChanges(mixtape, json.RawMessage(`{"playlists": null}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes.PlaylistChanges).To(HaveLen(2))
+		})
+
+		It("should reject members other than playlists", func() {
+			_, err := patch.MergeToChanges(mixtape, json.RawMessage(`{"users": []}`))
+			Expect(err).To(MatchError(ContainSubstring("member users can not be mapped")))
+		})
+
+		It("should reject changes to existing playlists that have no matching change", func() {
+			_, err := patch.MergeToChanges(mixtape, json.RawMessage(`{"playlists": [{"id": "playlist_1", "user_id": "user_2", "song_ids": ["song_1"]}]}`))
+			Expect(err).To(MatchError(ContainSubstring("changing user_id")))
+
+			_, err = patch.MergeToChanges(mixtape, json.RawMessage(`{"playlists": [{"id": "playlist_1", "user_id": "user_1", "song_ids": []}]}`))
+			Expect(err).To(MatchError(ContainSubstring("removing songs")))
+
+			_, err = patch.MergeToChanges(mixtape, json.RawMessage(`{"playlists": [{"id": "playlist_1", "user_id": "user_1", "song_ids": ["song_2", "song_1"]}]}`))
+			Expect(err).To(MatchError(ContainSubstring("reordering or replacing songs")))
+
+			_, err = patch.MergeToChanges(mixtape, json.RawMessage(`{"playlists": [{"id": "playlist_1", "user_id": "user_1", "song_ids": ["song_1"], "allow_duplicates": true}]}`))
+			Expect(err).To(MatchError(ContainSubstring("changing allow_duplicates")))
+
+			mixtape.Playlists[0].Name = "test_name"
+			_, err = patch.MergeToChanges(mixtape, json.RawMessage(`{"playlists": [{"id": "playlist_1", "user_id": "user_1", "song_ids": ["song_1"]}]}`))
+			Expect(err).To(MatchError(ContainSubstring("clearing name")))
+		})
+	})
+
+	Describe("FromChanges", func() {
+		It("should export the applied changes as a patch that round trips", func() {
+			// a fixed clock, so that the playlist added in both runs compares
+			clock := mixtape_pkg.WithClock(func() time.Time { return time.Unix(0, 0) })
+			before := patch.NewDocument(mixtape)
+			m := mixtape_pkg.New(mixtape, util.Discard, clock)
+			err := m.ApplyChanges(&models.Changes{PlaylistChanges: []models.PlaylistChange{
+				{ID: models.Add, Playlist: models.Playlist{ID: "playlist_3", UserID: "user_1", SongIDs: []string{"song_x", "song_2"}}},
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_x"}},
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_1"}},
+				{ID: models.AddSongs, Playlist: models.Playlist{ID: "playlist_2", SongIDs: []string{"song_2", "song_1"}}},
+				{ID: models.RemoveSongs, Mode: models.RemoveAt, Playlist: models.Playlist{ID: "playlist_2"}, Positions: []int{1, 3, 2}},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+
+			p, err := before.FromChanges(m.Applied())
+			Expect(err).ToNot(HaveOccurred())
+			document, err := json.Marshal(p)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(document).To(MatchJSON(`[
+				{"op": "add", "path": "/playlists/-", "value": {"id": "playlist_3", "user_id": "user_1", "song_ids": ["song_2"]}},
+				{"op": "remove", "path": "/playlists/0"},
+				{"op": "add", "path": "/playlists/0/song_ids/-", "value": "song_1"},
+				{"op": "remove", "path": "/playlists/0/song_ids/1"},
+				{"op": "remove", "path": "/playlists/0/song_ids/0"}
+			]`))
+
+			replayed := newMixtape()
+			changes, err := patch.NewDocument(replayed).ToChanges(p)
+			Expect(err).ToNot(HaveOccurred())
+			// each position becomes its own change, so versions differ but
+			// songs do not
+			Expect(mixtape_pkg.New(replayed, util.Discard, clock).ApplyChanges(changes)).To(Succeed())
+			Expect(replayed.Playlists).To(HaveLen(len(mixtape.Playlists)))
+			for i, playlist := range mixtape.Playlists {
+				Expect(replayed.Playlists[i].ID).To(Equal(playlist.ID))
+				Expect(replayed.Playlists[i].SongIDs).To(Equal(playlist.SongIDs))
+			}
+		})
+
+		It("should set the members that other changes change", func() {
+			mixtape.Playlists[0].Collaborators = []models.Collaborator{{UserID: "user_2", Role: models.Viewer}}
+			before := patch.NewDocument(mixtape)
+			m := mixtape_pkg.New(mixtape, util.Discard)
+			err := m.ApplyChanges(&models.Changes{PlaylistChanges: []models.PlaylistChange{
+				{ID: models.SetSongs, Playlist: models.Playlist{ID: "playlist_1", SongIDs: []string{"song_2", "song_1"}}},
+				{ID: models.UpdatePlaylist, Playlist: models.Playlist{ID: "playlist_1", Name: "test_name", Visibility: models.Private}},
+				{ID: models.AddCollaborators, Playlist: models.Playlist{ID: "playlist_2", Collaborators: []models.Collaborator{{UserID: "user_1", Role: models.Viewer}}}},
+				{ID: models.TransferOwnership, Playlist: models.Playlist{ID: "playlist_1", UserID: "user_2"}},
+				{ID: models.RemoveCollaborators, Playlist: models.Playlist{ID: "playlist_1", Collaborators: []models.Collaborator{{UserID: "user_1"}}}},
+				{ID: models.UpsertPlaylist, Playlist: models.Playlist{ID: "playlist_2", UserID: "user_2", SongIDs: []string{"song_1"}}},
+			}})
+			Expect(err).ToNot(HaveOccurred())
+
+			p, err := before.FromChanges(m.Applied())
+			Expect(err).ToNot(HaveOccurred())
+			document, err := json.Marshal(p)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(document).To(MatchJSON(`[
+				{"op": "replace", "path": "/playlists/0/song_ids", "value": ["song_2", "song_1"]},
+				{"op": "add", "path": "/playlists/0/name", "value": "test_name"},
+				{"op": "add", "path": "/playlists/0/visibility", "value": "private"},
+				{"op": "add", "path": "/playlists/1/collaborators", "value": [{"user_id": "user_1", "role": "viewer"}]},
+				{"op": "replace", "path": "/playlists/0/user_id", "value": "user_2"},
+				{"op": "replace", "path": "/playlists/0/collaborators", "value": [{"user_id": "user_1", "role": "editor"}]},
+				{"op": "remove", "path": "/playlists/0/collaborators"},
+				{"op": "replace", "path": "/playlists/1/song_ids", "value": ["song_1"]}
+			]`))
+		})
+	})
+})
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/n4wei/highspot/fileio"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/state"
+)
+
+// highspot plan -m <mixtape file> -s <desired state file> [-prune] [-actor <user id>] [-o <changes file>]
+// Writes the changes that take the mixtape to the desired state, see
+// state.Plan, as a changes file that apply -c carries out, and a summary
+// to stderr. apply -s plans and applies in one go.
+func runPlan(args []string) {
+	var mixtapeFile, stateFile, outputFile string
+	var opts state.Options
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+	flags.StringVar(&mixtapeFile, "m", "", "filepath to the JSON mixtape file, - for stdin")
+	flags.StringVar(&stateFile, "s", "", "filepath to the JSON desired state, a mixtape listing the playlists as they should be, - for stdin")
+	flags.BoolVar(&opts.Prune, "prune", false, "remove the playlists that are not in the desired state, instead of leaving them alone")
+	flags.StringVar(&opts.Actor, "actor", "", "user ID the planned changes are made by, for apply -a to authorize them")
+	flags.StringVar(&outputFile, "o", fileio.Stdio, "filepath to write the planned changes JSON file, - for stdout")
+	flags.Parse(args)
+	if mixtapeFile == "" || stateFile == "" {
+		handleFlagError(flags, errors.New("missing required flags -m and -s"))
+	}
+	if mixtapeFile == fileio.Stdio && stateFile == fileio.Stdio {
+		handleFlagError(flags, errors.New("only one of -m and -s can read from stdin"))
+	}
+
+	mixtape, _, err := readMixtape(mixtapeFile)
+	handleError(err)
+	changes, err := readState(stateFile, mixtape, opts)
+	handleError(err)
+
+	err = writeToFile(changes, outputFile, fileio.DefaultCompression)
+	handleError(err)
+	fmt.Fprintf(os.Stderr, "Plan: %s.\n", state.Summarize(changes))
+}
+
+// readState reads a desired state and plans the changes from the mixtape
+// to it. The changes come from a diff, not from a line of the file, so
+// their source is the file.
+func readState(filepath string, mixtape *models.Mixtape, opts state.Options) (*models.Changes, error) {
+	desired := &models.Mixtape{}
+	err := readFromFile(filepath, desired)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := state.Plan(mixtape, desired, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath
+	if filepath == fileio.Stdio {
+		name = "stdin"
+	}
+	for i := range changes.PlaylistChanges {
+		changes.PlaylistChanges[i].Source = name
+	}
+	return changes, nil
+}
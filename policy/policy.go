@@ -0,0 +1,128 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/n4wei/highspot/fileio"
+	"github.com/n4wei/highspot/models"
+)
+
+// A policy decides who may make a change, given as the change's actor, a
+// user ID. Admins may make any change. Other users may only add playlists
+// for themselves, and only change playlists they own, except that editors
+// of a playlist, and collaborators granted by the policy, may add songs to
+// it, including by auto_fill and merge_playlists, remove songs from it and
+// set its songs. A private playlist may only be read, by cloning it or
+// merging it into another playlist, by its owner and editors. Changes to several playlists are also checked as the
+// changes they are made of, see mixtape.Mixtape. Without a policy, any
+// change can be made by anyone, as before actors existed.
+type Policy struct {
+	Admins []string `json:"admins"`
+	// map of playlist id to the user ids that may add and remove its
+	// songs, on top of its editors
+	Collaborators map[string][]string `json:"collaborators"`
+}
+
+// ErrUnauthorized is wrapped by every error Authorize returns, whose
+// messages are used as skip reasons.
+var (
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrActorMissing    = fmt.Errorf("%w: actor missing", ErrUnauthorized)
+	ErrNotOwner        = fmt.Errorf("%w: actor does not own the playlist", ErrUnauthorized)
+	ErrNotCollaborator = fmt.Errorf("%w: actor neither owns nor edits the playlist", ErrUnauthorized)
+)
+
+// Authorize returns nil if the change's actor may make the change. The
+// existing playlist is nil if there is no playlist with the change's
+// playlist ID, in which case only adds, including upserts, are checked,
+// since there is nothing else to protect and the change is skipped anyway.
+// For clone_playlist, it is the playlist cloned from instead.
+// runtime: O(a + c), a is the number of admins and c the number of
+// collaborators on the playlist
+func (p *Policy) Authorize(change models.PlaylistChange, existing *models.Playlist) error {
+	actor := change.Actor
+	if actor == "" {
+		return ErrActorMissing
+	}
+	if contains(p.Admins, actor) {
+		return nil
+	}
+
+	switch change.ID {
+	case models.Add:
+		if change.Playlist.UserID != actor {
+			return ErrNotOwner
+		}
+	case models.Remove, models.AddCollaborators, models.RemoveCollaborators, models.TransferOwnership, models.UpdatePlaylist, models.SplitPlaylist:
+		if existing != nil && existing.UserID != actor {
+			return ErrNotOwner
+		}
+	case models.UpsertPlaylist:
+		// an add if there is no playlist, else a transfer of ownership
+		if (existing == nil && change.Playlist.UserID != actor) || (existing != nil && existing.UserID != actor) {
+			return ErrNotOwner
+		}
+	case models.ClonePlaylist:
+		if existing != nil {
+			return p.AuthorizeRead(actor, existing)
+		}
+	case models.AddSongs, models.RemoveSongs, models.SetSongs, models.AutoFill, models.MergePlaylists:
+		if existing != nil && existing.UserID != actor && !isEditor(existing, actor) && !contains(p.Collaborators[existing.ID], actor) {
+			return ErrNotCollaborator
+		}
+	}
+	return nil
+}
+
+// AuthorizeRead returns nil if the actor may read the playlist's songs, eg.
+// to merge them into another playlist. Public playlists may be read by
+// anyone, private ones only by admins, their owner and their editors.
+// runtime: O(a + c), see Authorize
+func (p *Policy) AuthorizeRead(actor string, playlist *models.Playlist) error {
+	if actor == "" {
+		return ErrActorMissing
+	}
+	if playlist.IsPublic() || playlist.UserID == actor || isEditor(playlist, actor) || contains(p.Admins, actor) {
+		return nil
+	}
+	return ErrNotCollaborator
+}
+
+func isEditor(playlist *models.Playlist, userID string) bool {
+	for _, collaborator := range playlist.Collaborators {
+		if collaborator.UserID == userID {
+			return collaborator.Role == models.Editor
+		}
+	}
+	return false
+}
+
+func contains(userIDs []string, userID string) bool {
+	for _, id := range userIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func Read(r io.Reader) (*Policy, error) {
+	p := &Policy{}
+	err := json.NewDecoder(r).Decode(p)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func ReadFile(filepath string) (*Policy, error) {
+	r, err := fileio.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return Read(r)
+}
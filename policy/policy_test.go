@@ -0,0 +1,99 @@
+package policy_test
+
+import (
+	"strings"
+
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/policy"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Policy", func() {
+	p := &policy.Policy{
+		Admins:        []string{"admin"},
+		Collaborators: map[string][]string{"playlist_1": {"friend"}},
+	}
+	playlist := &models.Playlist{
+		ID:      "playlist_1",
+		UserID:  "owner",
+		SongIDs: []string{"song_1"},
+		Collaborators: []models.Collaborator{
+			{UserID: "editor", Role: models.Editor},
+			{UserID: "viewer", Role: models.Viewer},
+		},
+	}
+
+	private := *playlist
+	private.Visibility = models.Private
+
+	change := func(id models.PlaylistChangeID, actor, userID string) models.PlaylistChange {
+		return models.PlaylistChange{ID: id, Actor: actor, Playlist: models.Playlist{ID: "playlist_1", UserID: userID}}
+	}
+
+	table.DescribeTable("Authorize",
+		func(c models.PlaylistChange, existing *models.Playlist, expected error) {
+			err := p.Authorize(c, existing)
+			if expected == nil {
+				Expect(err).ToNot(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(expected))
+				Expect(err).To(MatchError(policy.ErrUnauthorized))
+			}
+		},
+		table.Entry("no actor", change(models.AddSongs, "", ""), playlist, policy.ErrActorMissing),
+		table.Entry("admin removing any playlist", change(models.Remove, "admin", ""), playlist, nil),
+		table.Entry("admin adding for another user", change(models.Add, "admin", "owner"), nil, nil),
+		table.Entry("user adding for themselves", change(models.Add, "owner", "owner"), nil, nil),
+		table.Entry("user adding for another user", change(models.Add, "friend", "owner"), nil, policy.ErrNotOwner),
+		table.Entry("owner removing", change(models.Remove, "owner", ""), playlist, nil),
+		table.Entry("collaborator removing", change(models.Remove, "friend", ""), playlist, policy.ErrNotOwner),
+		table.Entry("collaborator adding songs", change(models.AddSongs, "friend", ""), playlist, nil),
+		table.Entry("stranger adding songs", change(models.AddSongs, "stranger", ""), playlist, policy.ErrNotCollaborator),
+		table.Entry("stranger adding songs claiming to be the owner", change(models.AddSongs, "stranger", "owner"), playlist, policy.ErrNotCollaborator),
+		table.Entry("editor adding songs", change(models.AddSongs, "editor", ""), playlist, nil),
+		table.Entry("viewer adding songs", change(models.AddSongs, "viewer", ""), playlist, policy.ErrNotCollaborator),
+		table.Entry("editor auto filling", change(models.AutoFill, "editor", ""), playlist, nil),
+		table.Entry("viewer auto filling", change(models.AutoFill, "viewer", ""), playlist, policy.ErrNotCollaborator),
+		table.Entry("editor merging into the playlist", change(models.MergePlaylists, "editor", ""), playlist, nil),
+		table.Entry("editor splitting", change(models.SplitPlaylist, "editor", ""), playlist, policy.ErrNotOwner),
+		table.Entry("editor setting songs", change(models.SetSongs, "editor", ""), playlist, nil),
+		table.Entry("viewer setting songs", change(models.SetSongs, "viewer", ""), playlist, policy.ErrNotCollaborator),
+		table.Entry("user upserting a new playlist for themselves", change(models.UpsertPlaylist, "owner", "owner"), nil, nil),
+		table.Entry("user upserting a new playlist for another user", change(models.UpsertPlaylist, "friend", "owner"), nil, policy.ErrNotOwner),
+		table.Entry("owner upserting to another owner", change(models.UpsertPlaylist, "owner", "editor"), playlist, nil),
+		table.Entry("editor upserting", change(models.UpsertPlaylist, "editor", "editor"), playlist, policy.ErrNotOwner),
+		table.Entry("editor adding collaborators", change(models.AddCollaborators, "editor", ""), playlist, policy.ErrNotOwner),
+		table.Entry("owner transferring", change(models.TransferOwnership, "owner", "editor"), playlist, nil),
+		table.Entry("editor transferring", change(models.TransferOwnership, "editor", "editor"), playlist, policy.ErrNotOwner),
+		table.Entry("stranger cloning a public playlist", change(models.ClonePlaylist, "stranger", "stranger"), playlist, nil),
+		table.Entry("editor cloning a private playlist", change(models.ClonePlaylist, "editor", "editor"), &private, nil),
+		table.Entry("viewer cloning a private playlist", change(models.ClonePlaylist, "viewer", "viewer"), &private, policy.ErrNotCollaborator),
+		table.Entry("collaborator cloning a private playlist", change(models.ClonePlaylist, "friend", "friend"), &private, policy.ErrNotCollaborator),
+		table.Entry("stranger removing a missing playlist", change(models.Remove, "stranger", ""), nil, nil),
+	)
+
+	table.DescribeTable("AuthorizeRead",
+		func(actor string, existing *models.Playlist, expected error) {
+			err := p.AuthorizeRead(actor, existing)
+			if expected == nil {
+				Expect(err).ToNot(HaveOccurred())
+			} else {
+				Expect(err).To(MatchError(expected))
+			}
+		},
+		table.Entry("no actor", "", playlist, policy.ErrActorMissing),
+		table.Entry("stranger reading a public playlist", "stranger", playlist, nil),
+		table.Entry("admin reading a private playlist", "admin", &private, nil),
+		table.Entry("owner reading a private playlist", "owner", &private, nil),
+		table.Entry("editor reading a private playlist", "editor", &private, nil),
+		table.Entry("viewer reading a private playlist", "viewer", &private, policy.ErrNotCollaborator),
+	)
+
+	It("should read a policy", func() {
+		read, err := policy.Read(strings.NewReader(`{"admins": ["admin"], "collaborators": {"playlist_1": ["friend"]}}`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read).To(Equal(p))
+	})
+})
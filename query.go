@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/selector"
+	"github.com/n4wei/highspot/util"
+)
+
+// Queries are dispatched on the first argument after query. Each one reads
+// the mixtape, answers from its lookup hash maps and writes the result to
+// stdout as a table or as JSON.
+var queries = map[string]func(args []string){
+	"user-playlists": queryUserPlaylists,
+	"playlist":       queryPlaylist,
+	"song-playlists": querySongPlaylists,
+	"search-songs":   querySearchSongs,
+	"playlists":      queryPlaylists,
+}
+
+const (
+	tableFormat = "table"
+	jsonFormat  = "json"
+)
+
+// highspot query <query> -m <mixtape file> [-format table|json] ...
+func runQuery(args []string) {
+	if len(args) == 0 {
+		handleQueryError(errors.New("missing query"))
+	}
+	query, exist := queries[args[0]]
+	if !exist {
+		handleQueryError(fmt.Errorf("unknown query %s", args[0]))
+	}
+	query(args[1:])
+}
+
+func handleQueryError(err error) {
+	names := []string{}
+	for name := range queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintf(os.Stderr, "Error: %v, expected one of: %s\n", err, strings.Join(names, ", "))
+	os.Exit(1)
+}
+
+// queryFlags are the flags every query has, and -where for the ones that
+// list playlists, see addWhere.
+type queryFlags struct {
+	*flag.FlagSet
+	mixtapeFile string
+	format      string
+	where       string
+	// compiled from where by parse, nil without one
+	selector *selector.Selector
+}
+
+func newQueryFlags(name string) *queryFlags {
+	flags := &queryFlags{FlagSet: flag.NewFlagSet("query "+name, flag.ExitOnError)}
+	flags.StringVar(&flags.mixtapeFile, "m", "", "filepath to the JSON mixtape file, - for stdin")
+	flags.StringVar(&flags.format, "format", tableFormat, "format of the output: table or json")
+	return flags
+}
+
+// addWhere adds -where, so that queries that do not list playlists reject
+// it as an unknown flag rather than ignore it.
+func (flags *queryFlags) addWhere() {
+	flags.StringVar(&flags.where, "where", "", "selector expression the listed playlists must match, eg. 'songs < 2'")
+}
+
+// parse parses the flags, checks the required ones and reads the mixtape.
+// A snapshot's index is used as is, else the index is built.
+func (flags *queryFlags) parse(args []string, required map[string]*string) *mixtape_pkg.Mixtape {
+	flags.Parse(args)
+	if flags.mixtapeFile == "" {
+		handleFlagError(flags.FlagSet, errors.New("missing required flag -m"))
+	}
+	for name, value := range required {
+		if *value == "" {
+			handleFlagError(flags.FlagSet, fmt.Errorf("missing required flag -%s", name))
+		}
+	}
+	if flags.format != tableFormat && flags.format != jsonFormat {
+		handleFlagError(flags.FlagSet, fmt.Errorf("unknown format %s", flags.format))
+	}
+	if flags.where != "" {
+		var err error
+		flags.selector, err = selector.Parse(flags.where)
+		if err != nil {
+			handleFlagError(flags.FlagSet, err)
+		}
+	}
+
+	mixtape, index, err := readMixtape(flags.mixtapeFile)
+	handleError(err)
+	if index != nil {
+		return mixtape_pkg.NewWithIndex(mixtape, index, util.Discard)
+	}
+	return mixtape_pkg.New(mixtape, util.Discard)
+}
+
+// matches tells whether a playlist is listed, which is always without
+// -where.
+func (flags *queryFlags) matches(m *mixtape_pkg.Mixtape, playlist models.Playlist) bool {
+	return flags.selector == nil || flags.selector.Match(playlist, m)
+}
+
+// write writes the result to stdout in the query's format, see
+// writeResult.
+func (flags *queryFlags) write(result interface{}, table func(w io.Writer)) {
+	handleError(writeResult(os.Stdout, flags.format, result, table))
+}
+
+// writeResult writes the result as indented JSON with the json format, or
+// else as the table that the table function writes to an aligning writer.
+func writeResult(w io.Writer, format string, result interface{}, table func(w io.Writer)) error {
+	if format == jsonFormat {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	table(tw)
+	return tw.Flush()
+}
+
+// userPlaylist is a playlist with the role of the user it was queried for.
+type userPlaylist struct {
+	Role string `json:"role"`
+	models.Playlist
+}
+
+const ownerRole = "owner"
+
+// highspot query user-playlists -m <mixtape file> -u <user id>
+// Lists the playlists the user owns, then the ones they are an editor of.
+// Like every query listing playlists, it only lists the ones matching
+// -where, if given.
+func queryUserPlaylists(args []string) {
+	var userID string
+	flags := newQueryFlags("user-playlists")
+	flags.addWhere()
+	flags.StringVar(&userID, "u", "", "user id")
+	m := flags.parse(args, map[string]*string{"u": &userID})
+
+	if _, exist := m.User(userID); !exist {
+		handleError(fmt.Errorf("user_id %s not in mixtape", userID))
+	}
+	result := []userPlaylist{}
+	owned := map[string]bool{}
+	for _, id := range m.PlaylistsOwnedBy(userID) {
+		owned[id] = true
+		playlist, _ := m.Playlist(id)
+		if flags.matches(m, playlist) {
+			result = append(result, userPlaylist{Role: ownerRole, Playlist: playlist})
+		}
+	}
+	for _, id := range m.PlaylistsEditableBy(userID) {
+		if !owned[id] {
+			playlist, _ := m.Playlist(id)
+			if flags.matches(m, playlist) {
+				result = append(result, userPlaylist{Role: string(models.Editor), Playlist: playlist})
+			}
+		}
+	}
+
+	flags.write(result, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tNAME\tROLE\tSONGS\tVISIBILITY")
+		for _, playlist := range result {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", playlist.ID, playlist.Name, playlist.Role, len(playlist.SongIDs), visibility(playlist.Playlist))
+		}
+	})
+}
+
+// playlistView is a playlist with its songs resolved, in order.
+type playlistView struct {
+	models.Playlist
+	Songs      []models.Song `json:"songs"`
+	DurationMs int64         `json:"duration_ms"`
+}
+
+// highspot query playlist -m <mixtape file> -p <playlist id>
+func queryPlaylist(args []string) {
+	var playlistID string
+	flags := newQueryFlags("playlist")
+	flags.StringVar(&playlistID, "p", "", "playlist id")
+	m := flags.parse(args, map[string]*string{"p": &playlistID})
+
+	playlist, exist := m.Playlist(playlistID)
+	if !exist {
+		handleError(fmt.Errorf("playlist_id %s not in mixtape", playlistID))
+	}
+	duration, _ := m.Duration(playlistID)
+	result := playlistView{Playlist: playlist, Songs: []models.Song{}, DurationMs: duration.Milliseconds()}
+	for _, songID := range playlist.SongIDs {
+		song, _ := m.Song(songID)
+		song.ID = songID
+		result.Songs = append(result.Songs, song)
+	}
+
+	flags.write(result, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tOWNER\tNAME\tVISIBILITY\tSONGS\tDURATION")
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\n", playlist.ID, playlist.UserID, playlist.Name, visibility(playlist), len(playlist.SongIDs), formatDuration(duration))
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "POSITION\tSONG_ID\tARTIST\tTITLE\tDURATION")
+		for i, song := range result.Songs {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", i+1, song.ID, song.Artist, song.Title, formatDuration(song.Duration()))
+		}
+	})
+}
+
+// highspot query song-playlists -m <mixtape file> -s <song id>
+func querySongPlaylists(args []string) {
+	var songID string
+	flags := newQueryFlags("song-playlists")
+	flags.addWhere()
+	flags.StringVar(&songID, "s", "", "song id")
+	m := flags.parse(args, map[string]*string{"s": &songID})
+
+	if _, exist := m.Song(songID); !exist {
+		handleError(fmt.Errorf("song_id %s not in mixtape", songID))
+	}
+	result := []models.Playlist{}
+	for _, id := range m.PlaylistsWithSong(songID) {
+		playlist, _ := m.Playlist(id)
+		if flags.matches(m, playlist) {
+			result = append(result, playlist)
+		}
+	}
+	flags.writePlaylists(result)
+}
+
+// highspot query playlists -m <mixtape file> [-where <selector>]
+// Lists every playlist matching -where, sorted by id.
+func queryPlaylists(args []string) {
+	flags := newQueryFlags("playlists")
+	flags.addWhere()
+	m := flags.parse(args, nil)
+
+	result := []models.Playlist{}
+	for _, id := range m.PlaylistsMatching(flags.selector) {
+		playlist, _ := m.Playlist(id)
+		result = append(result, playlist)
+	}
+	flags.writePlaylists(result)
+}
+
+func (flags *queryFlags) writePlaylists(result []models.Playlist) {
+	flags.write(result, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tOWNER\tNAME\tSONGS\tVISIBILITY")
+		for _, playlist := range result {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", playlist.ID, playlist.UserID, playlist.Name, len(playlist.SongIDs), visibility(playlist))
+		}
+	})
+}
+
+// highspot query search-songs -m <mixtape file> [-artist <text>] [-title <text>]
+func querySearchSongs(args []string) {
+	var artist, title string
+	flags := newQueryFlags("search-songs")
+	flags.StringVar(&artist, "artist", "", "text the artist contains, ignoring case")
+	flags.StringVar(&title, "title", "", "text the title contains, ignoring case")
+	m := flags.parse(args, nil)
+	if artist == "" && title == "" {
+		handleFlagError(flags.FlagSet, errors.New("missing -artist or -title"))
+	}
+
+	result := m.SearchSongs(artist, title)
+
+	flags.write(result, func(w io.Writer) {
+		fmt.Fprintln(w, "ID\tARTIST\tTITLE\tALBUM\tYEAR\tDURATION")
+		for _, song := range result {
+			year := ""
+			if song.Year != 0 {
+				year = fmt.Sprint(song.Year)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", song.ID, song.Artist, song.Title, song.Album, year, formatDuration(song.Duration()))
+		}
+	})
+}
+
+func visibility(playlist models.Playlist) models.Visibility {
+	if playlist.IsPublic() {
+		return models.Public
+	}
+	return models.Private
+}
+
+// formatDuration leaves unknown durations empty, eg. 3m35s otherwise.
+func formatDuration(duration time.Duration) string {
+	if duration == 0 {
+		return ""
+	}
+	return duration.String()
+}
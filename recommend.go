@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/recommend"
+)
+
+// recommendation is a recommended song with its artist and title resolved.
+type recommendation struct {
+	recommend.Recommendation
+	Artist string `json:"artist,omitempty"`
+	Title  string `json:"title,omitempty"`
+}
+
+// highspot recommend -m <mixtape file> -p <playlist id> [-k <n>] [-similarity jaccard|cooccurrence] [-format table|json]
+// Lists the top k songs to add to the playlist, see recommend.Recommend.
+func runRecommend(args []string) {
+	var playlistID, similarity string
+	var k int
+	flags := &queryFlags{FlagSet: flag.NewFlagSet("recommend", flag.ExitOnError)}
+	flags.StringVar(&flags.mixtapeFile, "m", "", "filepath to the JSON mixtape file, - for stdin")
+	flags.StringVar(&flags.format, "format", tableFormat, "format of the output: table or json")
+	flags.StringVar(&playlistID, "p", "", "playlist id")
+	flags.IntVar(&k, "k", 10, "number of songs to recommend")
+	flags.StringVar(&similarity, "similarity", string(recommend.Jaccard), "how songs are scored: jaccard or cooccurrence")
+	flags.Parse(args)
+	if flags.mixtapeFile == "" {
+		handleFlagError(flags.FlagSet, errors.New("missing required flag -m"))
+	}
+	if playlistID == "" {
+		handleFlagError(flags.FlagSet, errors.New("missing required flag -p"))
+	}
+	if flags.format != tableFormat && flags.format != jsonFormat {
+		handleFlagError(flags.FlagSet, fmt.Errorf("unknown format %s", flags.format))
+	}
+
+	mixtape, _, err := readMixtape(flags.mixtapeFile)
+	handleError(err)
+	recommendations, err := recommend.Recommend(mixtape, playlistID, k, recommend.Similarity(similarity))
+	handleError(err)
+
+	songs := map[string]models.Song{}
+	for _, song := range mixtape.Songs {
+		songs[song.ID] = song
+	}
+	result := []recommendation{}
+	for _, r := range recommendations {
+		song := songs[r.SongID]
+		result = append(result, recommendation{Recommendation: r, Artist: song.Artist, Title: song.Title})
+	}
+
+	flags.write(result, func(w io.Writer) {
+		fmt.Fprintln(w, "RANK\tSONG_ID\tARTIST\tTITLE\tSCORE")
+		for i, r := range result {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%.3f\n", i+1, r.SongID, r.Artist, r.Title, r.Score)
+		}
+	})
+}
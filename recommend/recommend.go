@@ -0,0 +1,144 @@
+package recommend
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/n4wei/highspot/analytics"
+	"github.com/n4wei/highspot/models"
+)
+
+// Recommendations are computed over a models.Mixtape alone, like the
+// analytics, so they work on any mixtape read from a file. A song is
+// recommended for a playlist when it is in other playlists together with
+// the playlist's songs: item-item collaborative filtering, where two songs
+// are similar if the same playlists have them. Songs are counted once per
+// playlist, and song ids that are not in the mixtape are ignored.
+
+// Similarity is how similar two songs are, given the playlists they are in.
+type Similarity string
+
+const (
+	// The number of playlists both songs are in. Favors popular songs.
+	CoOccurrence Similarity = "cooccurrence"
+	// The number of playlists both songs are in, divided by the number of
+	// playlists either one is in. Between 0 and 1, so a song in every
+	// playlist is not similar to everything. The default.
+	Jaccard Similarity = "jaccard"
+)
+
+type Recommendation struct {
+	SongID string `json:"song_id"`
+	// The sum of the song's similarity to each song in the playlist
+	Score float64 `json:"score"`
+}
+
+// Recommend returns the top k songs, not already in the playlist, by score,
+// highest first, then by song id. Songs with a score of 0, which share no
+// playlist with any of the playlist's songs, are never recommended, so
+// fewer than k songs may be returned.
+// runtime: O(s + p*ps*t + r*t + r log r), t is the number of songs in the
+// playlist and r the number of songs sharing a playlist with them
+func Recommend(mixtape *models.Mixtape, playlistID string, k int, similarity Similarity) ([]Recommendation, error) {
+	if similarity == "" {
+		similarity = Jaccard
+	}
+	if similarity != Jaccard && similarity != CoOccurrence {
+		return nil, fmt.Errorf("unknown similarity %s, expected %s or %s", similarity, Jaccard, CoOccurrence)
+	}
+	if k < 0 {
+		return nil, fmt.Errorf("k must not be negative, got %d", k)
+	}
+	var target *models.Playlist
+	for i := range mixtape.Playlists {
+		if mixtape.Playlists[i].ID == playlistID {
+			target = &mixtape.Playlists[i]
+			break
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("playlist_id %s not in mixtape", playlistID)
+	}
+
+	songs := map[string]bool{}
+	for _, song := range mixtape.Songs {
+		songs[song.ID] = true
+	}
+	// the playlist's songs in order, so scores are summed in the same
+	// order, and round the same, every time
+	in := map[string]bool{}
+	targetSongs := []string{}
+	for _, songID := range target.SongIDs {
+		if songs[songID] && !in[songID] {
+			in[songID] = true
+			targetSongs = append(targetSongs, songID)
+		}
+	}
+
+	// both[candidate][song] is the number of playlists both are in
+	both := map[string]map[string]int{}
+	for _, playlist := range mixtape.Playlists {
+		distinct := map[string]bool{}
+		shared := []string{}
+		for _, songID := range playlist.SongIDs {
+			if !songs[songID] || distinct[songID] {
+				continue
+			}
+			distinct[songID] = true
+			if in[songID] {
+				shared = append(shared, songID)
+			}
+		}
+		if len(shared) == 0 {
+			continue
+		}
+		for candidate := range distinct {
+			if in[candidate] {
+				continue
+			}
+			if both[candidate] == nil {
+				both[candidate] = map[string]int{}
+			}
+			for _, songID := range shared {
+				both[candidate][songID]++
+			}
+		}
+	}
+
+	playlists := analytics.SongPlaylists(mixtape)
+	result := make([]Recommendation, 0, len(both))
+	for candidate, counts := range both {
+		score := 0.0
+		for _, songID := range targetSongs {
+			n := counts[songID]
+			if n == 0 {
+				continue
+			}
+			if similarity == CoOccurrence {
+				score += float64(n)
+			} else {
+				score += float64(n) / float64(playlists[songID]+playlists[candidate]-n)
+			}
+		}
+		result = append(result, Recommendation{SongID: candidate, Score: score})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].SongID < result[j].SongID
+	})
+	if len(result) > k {
+		result = result[:k]
+	}
+	return result, nil
+}
+
+// SongIDs returns the song ids of the recommendations, in order.
+func SongIDs(recommendations []Recommendation) []string {
+	songIDs := make([]string, 0, len(recommendations))
+	for _, recommendation := range recommendations {
+		songIDs = append(songIDs, recommendation.SongID)
+	}
+	return songIDs
+}
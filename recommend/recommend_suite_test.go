@@ -0,0 +1,13 @@
+package recommend_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestRecommend(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Recommend Suite")
+}
@@ -0,0 +1,80 @@
+package recommend_test
+
+import (
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/recommend"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Recommend", func() {
+	var mixtape *models.Mixtape
+
+	BeforeEach(func() {
+		// song_3 is in more playlists with the playlist's songs than
+		// song_4, but it is also in more playlists overall
+		mixtape = &models.Mixtape{
+			Users: []models.User{{ID: "user_1", Name: "test_user_1"}},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_1", "song_2"}},
+				{ID: "playlist_2", UserID: "user_1", SongIDs: []string{"song_1", "song_3", "song_x"}},
+				{ID: "playlist_3", UserID: "user_1", SongIDs: []string{"song_1", "song_2", "song_3", "song_4"}},
+				{ID: "playlist_4", UserID: "user_1", SongIDs: []string{"song_5", "song_6"}},
+				{ID: "playlist_5", UserID: "user_1", SongIDs: []string{"song_2", "song_4"}},
+				{ID: "playlist_6", UserID: "user_1", SongIDs: []string{"song_3"}},
+				{ID: "playlist_7", UserID: "user_1", SongIDs: []string{"song_3"}},
+				{ID: "playlist_8", UserID: "user_1", SongIDs: []string{"song_1", "song_3", "song_3"}, AllowDuplicates: true},
+			},
+			Songs: []models.Song{
+				{ID: "song_1"}, {ID: "song_2"}, {ID: "song_3"},
+				{ID: "song_4"}, {ID: "song_5"}, {ID: "song_6"},
+			},
+		}
+	})
+
+	It("should score songs by the number of playlists they share with the playlist's songs", func() {
+		recommendations, err := recommend.Recommend(mixtape, "playlist_1", 10, recommend.CoOccurrence)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recommendations).To(Equal([]recommend.Recommendation{
+			{SongID: "song_3", Score: 4},
+			{SongID: "song_4", Score: 3},
+		}))
+	})
+
+	It("should score songs by Jaccard similarity by default", func() {
+		recommendations, err := recommend.Recommend(mixtape, "playlist_1", 10, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recommend.SongIDs(recommendations)).To(Equal([]string{"song_4", "song_3"}))
+		// 1/5 + 2/3 and 3/6 + 1/7
+		Expect(recommendations[0].Score).To(BeNumerically("~", 0.2+2.0/3, 1e-9))
+		Expect(recommendations[1].Score).To(BeNumerically("~", 0.5+1.0/7, 1e-9))
+	})
+
+	It("should keep the top k songs", func() {
+		recommendations, err := recommend.Recommend(mixtape, "playlist_1", 1, recommend.Jaccard)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recommend.SongIDs(recommendations)).To(Equal([]string{"song_4"}))
+
+		recommendations, err = recommend.Recommend(mixtape, "playlist_1", 0, recommend.Jaccard)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recommendations).To(BeEmpty())
+	})
+
+	It("should recommend nothing for a playlist sharing no songs with others", func() {
+		recommendations, err := recommend.Recommend(mixtape, "playlist_4", 10, recommend.Jaccard)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recommendations).To(BeEmpty())
+	})
+
+	It("should fail for a playlist not in mixtape", func() {
+		_, err := recommend.Recommend(mixtape, "playlist_x", 10, recommend.Jaccard)
+		Expect(err).To(MatchError("playlist_id playlist_x not in mixtape"))
+	})
+
+	It("should fail for an unknown similarity or a negative k", func() {
+		_, err := recommend.Recommend(mixtape, "playlist_1", 10, "cosine")
+		Expect(err).To(HaveOccurred())
+		_, err = recommend.Recommend(mixtape, "playlist_1", -1, recommend.Jaccard)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,248 @@
+package selector
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The grammar, lowest precedence first:
+//
+//	expr       = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | "(" expr ")" | comparison
+//	comparison = field operator literal
+//	operator   = "=" | "!=" | "<" | "<=" | ">" | ">=" | "~"
+//	literal    = string | integer | "true" | "false"
+//
+// Strings are double quoted, with Go escapes. Keywords are lower case.
+
+type tokenKind int
+
+const (
+	endToken tokenKind = iota
+	identToken
+	stringToken
+	intToken
+	operatorToken
+	openToken
+	closeToken
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// offset in the expression, starting at 1, for errors
+	pos int
+}
+
+// tokenize splits the expression into tokens, ending with an endToken.
+// runtime: O(n), n is the length of the expression
+func tokenize(expr string) ([]token, error) {
+	tokens := []token{}
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		start := i
+		switch {
+		case unicode.IsSpace(c):
+			i++
+			continue
+		case c == '(':
+			i++
+			tokens = append(tokens, token{kind: openToken, text: "(", pos: start + 1})
+		case c == ')':
+			i++
+			tokens = append(tokens, token{kind: closeToken, text: ")", pos: start + 1})
+		case c == '"':
+			i++
+			for i < len(expr) && expr[i] != '"' {
+				if expr[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(expr) {
+				return nil, fmt.Errorf("%w: unterminated string at %d", ErrInvalid, start+1)
+			}
+			i++
+			text, err := strconv.Unquote(expr[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("%w: bad string %s at %d", ErrInvalid, expr[start:i], start+1)
+			}
+			tokens = append(tokens, token{kind: stringToken, text: text, pos: start + 1})
+		case strings.ContainsRune("=!<>~", c):
+			i++
+			if i < len(expr) && expr[i] == '=' && c != '=' && c != '~' {
+				i++
+			}
+			text := expr[start:i]
+			if text == "!" {
+				return nil, fmt.Errorf("%w: unknown operator ! at %d, use not or !=", ErrInvalid, start+1)
+			}
+			tokens = append(tokens, token{kind: operatorToken, text: text, pos: start + 1})
+		case c == '-' || unicode.IsDigit(c):
+			i++
+			for i < len(expr) && unicode.IsDigit(rune(expr[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: intToken, text: expr[start:i], pos: start + 1})
+		case c == '_' || unicode.IsLetter(c):
+			for i < len(expr) && (expr[i] == '_' || expr[i] == '.' || unicode.IsLetter(rune(expr[i])) || unicode.IsDigit(rune(expr[i]))) {
+				i++
+			}
+			tokens = append(tokens, token{kind: identToken, text: expr[start:i], pos: start + 1})
+		default:
+			return nil, fmt.Errorf("%w: unexpected %q at %d", ErrInvalid, c, start+1)
+		}
+	}
+	return append(tokens, token{kind: endToken, text: "end of expression", pos: len(expr) + 1}), nil
+}
+
+// parser is a recursive descent parser, one method per rule of the
+// grammar. Each method compiles what it parsed into a predicate.
+type parser struct {
+	tokens []token
+	i      int
+}
+
+type predicate func(e *env) bool
+
+// String is how the token is shown in errors.
+func (t token) String() string {
+	if t.kind == stringToken {
+		return strconv.Quote(t.text)
+	}
+	return t.text
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != endToken {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == identToken && t.text == word {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func unexpected(t token) error {
+	return fmt.Errorf("%w: unexpected %s at %d", ErrInvalid, t, t.pos)
+}
+
+func (p *parser) expr() (predicate, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(e *env) bool { return l(e) || right(e) }
+	}
+	return left, nil
+}
+
+func (p *parser) and() (predicate, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(e *env) bool { return l(e) && right(e) }
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (predicate, error) {
+	if p.keyword("not") {
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(e *env) bool { return !operand(e) }, nil
+	}
+	if p.peek().kind == openToken {
+		p.next()
+		inner, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != closeToken {
+			return nil, unexpected(t)
+		}
+		return inner, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (predicate, error) {
+	t := p.next()
+	if t.kind != identToken {
+		return nil, unexpected(t)
+	}
+	f, exist := fields[t.text]
+	if !exist {
+		return nil, fmt.Errorf("%w: unknown field %s at %d", ErrInvalid, t.text, t.pos)
+	}
+
+	op := p.next()
+	if op.kind != operatorToken {
+		return nil, unexpected(op)
+	}
+	if !f.kind.supports(op.text) {
+		return nil, fmt.Errorf("%w: operator %s does not apply to %s field %s at %d", ErrInvalid, op.text, f.kind, t.text, op.pos)
+	}
+
+	literal, err := p.literal(t.text, f.kind)
+	if err != nil {
+		return nil, err
+	}
+	return func(e *env) bool {
+		for _, v := range f.values(e) {
+			if compare(op.text, v, literal) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// literal parses a literal of the kind of the field it is compared to.
+func (p *parser) literal(name string, k kind) (value, error) {
+	t := p.next()
+	switch {
+	case k == stringKind && t.kind == stringToken:
+		return value{s: t.text}, nil
+	case k == intKind && t.kind == intToken:
+		n, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return value{}, fmt.Errorf("%w: bad integer %s at %d", ErrInvalid, t.text, t.pos)
+		}
+		return value{n: n}, nil
+	case k == boolKind && t.kind == identToken && (t.text == "true" || t.text == "false"):
+		return boolValue(t.text == "true"), nil
+	case t.kind == endToken:
+		return value{}, unexpected(t)
+	}
+	return value{}, fmt.Errorf("%w: %s field %s compared to %s at %d", ErrInvalid, k, name, t, t.pos)
+}
@@ -0,0 +1,264 @@
+package selector
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/n4wei/highspot/models"
+)
+
+// A selector is an expression that matches playlists, eg.
+//
+//	user_id = "3" and songs < 2
+//	song.artist ~ "weeknd" or (visibility = "private" and not collaborator = "2")
+//
+// Fields are those of the playlist, its owner and its songs and
+// collaborators, see fields. A comparison on song or collaborator fields
+// is true if it is true of any of them, so `not song.id = "7"` matches the
+// playlists without song 7, while `song.id != "7"` matches the ones with
+// any other song. ~ is true if the field contains the text, ignoring case.
+// Songs that are not in the mixtape have only an id.
+type Selector struct {
+	expr  string
+	match predicate
+}
+
+// ErrInvalid is wrapped by every error Parse returns.
+var ErrInvalid = errors.New("invalid selector")
+
+// Parse compiles the expression into a Selector, checking that every field
+// exists and is compared to a literal of its type.
+// runtime: O(n), n is the length of the expression
+func Parse(expr string) (*Selector, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	match, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.next(); t.kind != endToken {
+		return nil, unexpected(t)
+	}
+	return &Selector{expr: expr, match: match}, nil
+}
+
+func (s *Selector) String() string {
+	return s.expr
+}
+
+// Lookup resolves the owner and the songs of a playlist, eg. a
+// mixtape.Mixtape.
+type Lookup interface {
+	User(id string) (models.User, bool)
+	Song(id string) (models.Song, bool)
+}
+
+// Match tells whether the playlist matches. Its owner and songs are only
+// looked up if the expression needs them.
+// runtime: O(n * ps), n is the length of the expression and ps the number
+// of songs in the playlist
+func (s *Selector) Match(playlist models.Playlist, lookup Lookup) bool {
+	return s.match(&env{playlist: playlist, lookup: lookup})
+}
+
+// NewLookup returns a Lookup over a models.Mixtape, for callers without a
+// mixtape.Mixtape.
+// runtime: O(u + s)
+func NewLookup(mixtape *models.Mixtape) Lookup {
+	l := &lookup{users: map[string]models.User{}, songs: map[string]models.Song{}}
+	for _, user := range mixtape.Users {
+		l.users[user.ID] = user
+	}
+	for _, song := range mixtape.Songs {
+		l.songs[song.ID] = song
+	}
+	return l
+}
+
+type lookup struct {
+	users map[string]models.User
+	songs map[string]models.Song
+}
+
+func (l *lookup) User(id string) (models.User, bool) {
+	user, exist := l.users[id]
+	return user, exist
+}
+
+func (l *lookup) Song(id string) (models.Song, bool) {
+	song, exist := l.songs[id]
+	return song, exist
+}
+
+// env is what an expression is evaluated against.
+type env struct {
+	playlist models.Playlist
+	lookup   Lookup
+}
+
+func (e *env) owner() models.User {
+	user, _ := e.lookup.User(e.playlist.UserID)
+	return user
+}
+
+func (e *env) songs() []models.Song {
+	songs := make([]models.Song, 0, len(e.playlist.SongIDs))
+	for _, id := range e.playlist.SongIDs {
+		song, exist := e.lookup.Song(id)
+		if !exist {
+			song = models.Song{ID: id}
+		}
+		songs = append(songs, song)
+	}
+	return songs
+}
+
+type kind int
+
+const (
+	stringKind kind = iota
+	intKind
+	boolKind
+)
+
+func (k kind) String() string {
+	switch k {
+	case intKind:
+		return "integer"
+	case boolKind:
+		return "boolean"
+	}
+	return "string"
+}
+
+func (k kind) supports(op string) bool {
+	switch op {
+	case "=", "!=":
+		return true
+	case "~":
+		return k == stringKind
+	}
+	return k == intKind
+}
+
+// value holds a string, or an integer, or a boolean as 0 or 1.
+type value struct {
+	s string
+	n int64
+}
+
+func boolValue(b bool) value {
+	if b {
+		return value{n: 1}
+	}
+	return value{}
+}
+
+func compare(op string, a, b value) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "~":
+		return strings.Contains(strings.ToLower(a.s), strings.ToLower(b.s))
+	case "<":
+		return a.n < b.n
+	case "<=":
+		return a.n <= b.n
+	case ">":
+		return a.n > b.n
+	}
+	return a.n >= b.n
+}
+
+type field struct {
+	kind   kind
+	values func(e *env) []value
+}
+
+func playlistString(get func(p models.Playlist) string) field {
+	return field{kind: stringKind, values: func(e *env) []value { return []value{{s: get(e.playlist)}} }}
+}
+
+func playlistInt(get func(p models.Playlist) int64) field {
+	return field{kind: intKind, values: func(e *env) []value { return []value{{n: get(e.playlist)}} }}
+}
+
+func songString(get func(s models.Song) string) field {
+	return field{kind: stringKind, values: func(e *env) []value {
+		values := []value{}
+		for _, song := range e.songs() {
+			values = append(values, value{s: get(song)})
+		}
+		return values
+	}}
+}
+
+func songInt(get func(s models.Song) int64) field {
+	return field{kind: intKind, values: func(e *env) []value {
+		values := []value{}
+		for _, song := range e.songs() {
+			values = append(values, value{n: get(song)})
+		}
+		return values
+	}}
+}
+
+// fields maps the name of every field to its kind and values.
+var fields = map[string]field{
+	"id":          playlistString(func(p models.Playlist) string { return p.ID }),
+	"user_id":     playlistString(func(p models.Playlist) string { return p.UserID }),
+	"name":        playlistString(func(p models.Playlist) string { return p.Name }),
+	"description": playlistString(func(p models.Playlist) string { return p.Description }),
+	// public unless the playlist was made private
+	"visibility": playlistString(func(p models.Playlist) string {
+		if p.IsPublic() {
+			return string(models.Public)
+		}
+		return string(models.Private)
+	}),
+	// the number of songs
+	"songs":   playlistInt(func(p models.Playlist) int64 { return int64(len(p.SongIDs)) }),
+	"version": playlistInt(func(p models.Playlist) int64 { return p.Version }),
+	"allow_duplicates": {kind: boolKind, values: func(e *env) []value {
+		return []value{boolValue(e.playlist.AllowDuplicates)}
+	}},
+	// the sum of the songs' durations
+	"duration_ms": {kind: intKind, values: func(e *env) []value {
+		total := int64(0)
+		for _, song := range e.songs() {
+			total += song.DurationMs
+		}
+		return []value{{n: total}}
+	}},
+	"owner.name": {kind: stringKind, values: func(e *env) []value { return []value{{s: e.owner().Name}} }},
+	// the user ids of the collaborators, and of the editors only
+	"collaborator": {kind: stringKind, values: func(e *env) []value {
+		values := []value{}
+		for _, collaborator := range e.playlist.Collaborators {
+			values = append(values, value{s: collaborator.UserID})
+		}
+		return values
+	}},
+	"editor": {kind: stringKind, values: func(e *env) []value {
+		values := []value{}
+		for _, collaborator := range e.playlist.Collaborators {
+			if collaborator.Role == models.Editor {
+				values = append(values, value{s: collaborator.UserID})
+			}
+		}
+		return values
+	}},
+	"song.id":          songString(func(s models.Song) string { return s.ID }),
+	"song.artist":      songString(func(s models.Song) string { return s.Artist }),
+	"song.title":       songString(func(s models.Song) string { return s.Title }),
+	"song.album":       songString(func(s models.Song) string { return s.Album }),
+	"song.genre":       songString(func(s models.Song) string { return s.Genre }),
+	"song.isrc":        songString(func(s models.Song) string { return s.ISRC }),
+	"song.year":        songInt(func(s models.Song) int64 { return int64(s.Year) }),
+	"song.duration_ms": songInt(func(s models.Song) int64 { return s.DurationMs }),
+}
@@ -0,0 +1,88 @@
+package selector_test
+
+import (
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/selector"
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Selector", func() {
+	mixtape := &models.Mixtape{
+		Users: []models.User{
+			{ID: "user_1", Name: "test_user_1"},
+			{ID: "user_2", Name: "test_user_2"},
+		},
+		Playlists: []models.Playlist{
+			{ID: "playlist_1", UserID: "user_1", Name: "Road Trip", SongIDs: []string{"song_1", "song_2"}, Version: 3,
+				Collaborators: []models.Collaborator{{UserID: "user_2", Role: models.Viewer}}},
+			{ID: "playlist_2", UserID: "user_2", Visibility: models.Private, SongIDs: []string{"song_3"}, AllowDuplicates: true,
+				Collaborators: []models.Collaborator{{UserID: "user_1", Role: models.Editor}}},
+			{ID: "playlist_3", UserID: "user_1", SongIDs: []string{"song_x"}},
+		},
+		Songs: []models.Song{
+			{ID: "song_1", Artist: "The Weeknd", Title: "Pray For Me", DurationMs: 200000, Year: 2018},
+			{ID: "song_2", Artist: "Drake", Title: "God's Plan", DurationMs: 100000, Genre: "hip hop"},
+			{ID: "song_3", Artist: "Drake", Title: "Nice For What", DurationMs: 210000, Year: 2018},
+		},
+	}
+	lookup := selector.NewLookup(mixtape)
+
+	matching := func(expr string) []string {
+		s, err := selector.Parse(expr)
+		Expect(err).ToNot(HaveOccurred())
+		ids := []string{}
+		for _, playlist := range mixtape.Playlists {
+			if s.Match(playlist, lookup) {
+				ids = append(ids, playlist.ID)
+			}
+		}
+		return ids
+	}
+
+	table.DescribeTable("matching playlists",
+		func(expr string, expected ...string) {
+			Expect(matching(expr)).To(ConsistOf(expected))
+		},
+		table.Entry("by owner", `user_id = "user_1"`, "playlist_1", "playlist_3"),
+		table.Entry("by owner name", `owner.name = "test_user_2"`, "playlist_2"),
+		table.Entry("by number of songs", `songs < 2`, "playlist_2", "playlist_3"),
+		table.Entry("by version", `version >= 3`, "playlist_1"),
+		table.Entry("by visibility, public when unset", `visibility = "public"`, "playlist_1", "playlist_3"),
+		table.Entry("by name, ignoring case", `name ~ "road"`, "playlist_1"),
+		table.Entry("by a flag", `allow_duplicates = true`, "playlist_2"),
+		table.Entry("by total duration", `duration_ms > 250000`, "playlist_1"),
+		table.Entry("by any song", `song.artist = "Drake"`, "playlist_1", "playlist_2"),
+		table.Entry("by a song not in mixtape", `song.id = "song_x"`, "playlist_3"),
+		table.Entry("by a song's number field", `song.year = 2018`, "playlist_1", "playlist_2"),
+		table.Entry("without a song", `not song.id = "song_1"`, "playlist_2", "playlist_3"),
+		table.Entry("with any other song", `song.id != "song_1"`, "playlist_1", "playlist_2", "playlist_3"),
+		table.Entry("by collaborator", `collaborator = "user_2"`, "playlist_1"),
+		table.Entry("by editor", `editor = "user_1"`, "playlist_2"),
+		table.Entry("with and binding tighter than or", `songs = 1 or songs = 2 and user_id = "user_2"`, "playlist_2", "playlist_3"),
+		table.Entry("with parentheses", `(songs = 1 or songs = 2) and user_id = "user_2"`, "playlist_2"),
+		table.Entry("with escapes in strings", `name != "say \"hi\""`, "playlist_1", "playlist_2", "playlist_3"),
+		table.Entry("with nothing matching", `songs > 10`),
+	)
+
+	table.DescribeTable("invalid expressions",
+		func(expr, message string) {
+			_, err := selector.Parse(expr)
+			Expect(err).To(MatchError(selector.ErrInvalid))
+			Expect(err.Error()).To(Equal("invalid selector: " + message))
+		},
+		table.Entry("empty", ``, "unexpected end of expression at 1"),
+		table.Entry("unknown field", `owner = "user_1"`, "unknown field owner at 1"),
+		table.Entry("string compared to a number", `name = 1`, "string field name compared to 1 at 8"),
+		table.Entry("number compared to a string", `songs > "1"`, `integer field songs compared to "1" at 9`),
+		table.Entry("ordering strings", `name < "b"`, "operator < does not apply to string field name at 6"),
+		table.Entry("containing a number", `songs ~ 1`, "operator ~ does not apply to integer field songs at 7"),
+		table.Entry("missing operand", `songs = 1 and`, "unexpected end of expression at 14"),
+		table.Entry("unclosed parenthesis", `(songs = 1`, "unexpected end of expression at 11"),
+		table.Entry("trailing tokens", `songs = 1 songs`, "unexpected songs at 11"),
+		table.Entry("unterminated string", `name = "road`, "unterminated string at 8"),
+		table.Entry("bang", `! songs = 1`, "unknown operator ! at 1, use not or !="),
+		table.Entry("unexpected character", `songs = 1 & songs = 2`, `unexpected '&' at 11`),
+	)
+})
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/n4wei/highspot/fileio"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/shard"
+)
+
+// highspot shard -m <mixtape file> -c <changes file> -n <shards> -d <dir> [-ext .gz|.zst]
+// Writes mixtape-<i>.json and changes-<i>.json for each shard to dir, to be
+// applied separately with highspot apply. With -ext, the files are
+// compressed and named eg. mixtape-<i>.json.gz.
+func runShard(args []string) {
+	var mixtapeFile, changesFile, changesFormatName, shardDir, extension string
+	var shards, compressionLevel int
+	flags := flag.NewFlagSet("shard", flag.ExitOnError)
+	flags.StringVar(&mixtapeFile, "m", "", "filepath to the JSON mixtape file, - for stdin")
+	flags.StringVar(&changesFile, "c", "", "filepath to the JSON changes file, - for stdin")
+	flags.StringVar(&changesFormatName, "f", changesFormat, "format of the changes file: changes, json-patch (RFC 6902) or merge-patch (RFC 7396)")
+	flags.IntVar(&shards, "n", 0, "number of shards")
+	flags.StringVar(&shardDir, "d", "", "directory to write the shard files to")
+	flags.StringVar(&extension, "ext", "", "extension appended to the shard file names to compress them, .gz or .zst")
+	addCompressionFlag(flags, &compressionLevel)
+	flags.Parse(args)
+
+	if mixtapeFile == "" || changesFile == "" || shardDir == "" || shards < 1 {
+		handleFlagError(flags, errors.New("missing required flags -m, -c, -n and -d"))
+	}
+	if mixtapeFile == fileio.Stdio && changesFile == fileio.Stdio {
+		handleFlagError(flags, errors.New("only one of -m and -c can read from stdin"))
+	}
+	if extension != "" && extension != fileio.GzipExtension && extension != fileio.ZstdExtension {
+		handleFlagError(flags, errors.New("-ext must be .gz or .zst"))
+	}
+
+	mixtape, _, err := readMixtape(mixtapeFile)
+	handleError(err)
+	changes, err := readChanges(changesFile, changesFormatName, mixtape)
+	handleError(err)
+
+	// keep pointing at the original changes file, not the shard's
+	for i, change := range changes.PlaylistChanges {
+		changes.PlaylistChanges[i].CorrelationID = change.Ref()
+	}
+
+	split, err := shard.Split(mixtape, changes, shards)
+	handleError(err)
+
+	err = os.MkdirAll(shardDir, 0755)
+	handleError(err)
+
+	for i, s := range split {
+		err = writeToFile(s.Mixtape, filepath.Join(shardDir, shard.MixtapeFile(i)+extension), compressionLevel)
+		handleError(err)
+		err = writeToFile(s.Changes, filepath.Join(shardDir, shard.ChangesFile(i)+extension), compressionLevel)
+		handleError(err)
+	}
+}
+
+// highspot unshard -m <original mixtape file> -o <output file> <shard output>...
+// Shard outputs must be given in shard order.
+func runUnshard(args []string) {
+	var mixtapeFile, outputFile string
+	var compressionLevel int
+	flags := flag.NewFlagSet("unshard", flag.ExitOnError)
+	flags.StringVar(&mixtapeFile, "m", "", "filepath to the original JSON mixtape file the shards were split from, - for stdin")
+	flags.StringVar(&outputFile, "o", "./output.json", "filepath to write the joined mixtape JSON file, - for stdout")
+	addCompressionFlag(flags, &compressionLevel)
+	flags.Parse(args)
+
+	if mixtapeFile == "" || flags.NArg() == 0 {
+		handleFlagError(flags, errors.New("missing required flag -m and shard output files"))
+	}
+
+	original, _, err := readMixtape(mixtapeFile)
+	handleError(err)
+
+	shards := []*models.Mixtape{}
+	for _, shardFile := range flags.Args() {
+		s, _, err := readMixtape(shardFile)
+		handleError(err)
+		shards = append(shards, s)
+	}
+
+	joined, err := shard.Join(original, shards)
+	handleError(err)
+
+	err = writeMixtape(joined, nil, outputFile, compressionLevel)
+	handleError(err)
+}
@@ -0,0 +1,173 @@
+package shard
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+)
+
+// Every change only touches the playlist with its ID (see mixtape.Groups),
+// so a mixtape and a changes file can be partitioned by playlist ID, each
+// shard applied on its own, and the outputs joined back together. Users and
+// songs are never changed, so each shard gets a copy of the ones it needs
+// and Join checks that they come back unchanged. Shards start at version 0,
+// so the versions they end at add up to the changes applied to all of them.
+
+// Of returns the shard of a playlist ID, out of n shards. FNV-1a is used
+// because it is stable across processes and Go versions.
+func Of(playlistID string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(playlistID))
+	return int(h.Sum32() % uint32(n))
+}
+
+type Shard struct {
+	Mixtape *models.Mixtape
+	Changes *models.Changes
+}
+
+// Split partitions the mixtape and the changes into n shards. Each shard
+// has its playlists and the changes to them, in their original order, and
+// the users and songs that those playlists and changes reference. Users and
+// songs that do not exist are left out, so that changes referencing them
+// are still skipped when the shard is applied. Bulk changes have no
+// playlist ID and go to every shard, since whether a playlist matches does
+// not depend on the others. Other changes that read or change several
+// playlists, see mixtape.SpansPlaylists, eg. merge_playlists or auto_fill,
+// can not be split.
+// runtime: O(u + s + p*ps + c*cs), see buildLookup for the variables,
+// c is the number of changes and cs the most songs in a change
+func Split(mixtape *models.Mixtape, changes *models.Changes, n int) ([]Shard, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("number of shards must be at least 1, got %d", n)
+	}
+	// checked here since shards have their own versions
+	if changes.IfMatch != nil && *changes.IfMatch != mixtape.Version {
+		return nil, fmt.Errorf("changes if_match %d does not match the mixtape version %d", *changes.IfMatch, mixtape.Version)
+	}
+
+	shards := make([]Shard, n)
+	users := make([]map[string]bool, n)
+	songs := make([]map[string]bool, n)
+	for i := range shards {
+		shards[i] = Shard{
+			Mixtape: &models.Mixtape{Users: []models.User{}, Playlists: []models.Playlist{}, Songs: []models.Song{}, AllowDuplicates: mixtape.AllowDuplicates},
+			Changes: &models.Changes{PlaylistChanges: []models.PlaylistChange{}},
+		}
+		users[i] = map[string]bool{}
+		songs[i] = map[string]bool{}
+	}
+
+	reference := func(i int, playlist models.Playlist) {
+		users[i][playlist.UserID] = true
+		for _, collaborator := range playlist.Collaborators {
+			users[i][collaborator.UserID] = true
+		}
+		for _, songID := range playlist.SongIDs {
+			songs[i][songID] = true
+		}
+	}
+	for _, playlist := range mixtape.Playlists {
+		i := Of(playlist.ID, n)
+		shards[i].Mixtape.Playlists = append(shards[i].Mixtape.Playlists, playlist)
+		reference(i, playlist)
+	}
+	for c, change := range changes.PlaylistChanges {
+		switch change.ID {
+		case models.Bulk:
+			// it matches playlists in every shard, each matching its own
+			for i := range shards {
+				shards[i].Changes.PlaylistChanges = append(shards[i].Changes.PlaylistChanges, change)
+				if change.Operation != nil {
+					reference(i, change.Operation.Playlist)
+				}
+			}
+		default:
+			if mixtape_pkg.SpansPlaylists(change) {
+				return nil, fmt.Errorf("change %d: %s reads or changes playlists that may be in different shards", c, change.ID)
+			}
+			i := Of(change.Playlist.ID, n)
+			shards[i].Changes.PlaylistChanges = append(shards[i].Changes.PlaylistChanges, change)
+			reference(i, change.Playlist)
+		}
+	}
+
+	// filling users and songs in their global order keeps shards
+	// deterministic and comparable to the original
+	for i := range shards {
+		for _, user := range mixtape.Users {
+			if users[i][user.ID] {
+				shards[i].Mixtape.Users = append(shards[i].Mixtape.Users, user)
+			}
+		}
+		for _, song := range mixtape.Songs {
+			if songs[i][song.ID] {
+				shards[i].Mixtape.Songs = append(shards[i].Mixtape.Songs, song)
+			}
+		}
+	}
+	return shards, nil
+}
+
+// Join reassembles the applied shards, given in shard order, into one
+// mixtape. Users and songs are taken from the original mixtape, after
+// checking that every shard's copies are identical to the original ones.
+// Playlists are ordered by shard, then by their order within the shard, so
+// the same shards always join to the same mixtape.
+func Join(original *models.Mixtape, shards []*models.Mixtape) (*models.Mixtape, error) {
+	users := map[string]models.User{}
+	for _, user := range original.Users {
+		users[user.ID] = user
+	}
+	songs := map[string]models.Song{}
+	for _, song := range original.Songs {
+		songs[song.ID] = song
+	}
+
+	playlists := []models.Playlist{}
+	seen := map[string]bool{}
+	version := original.Version
+	for i, shard := range shards {
+		version += shard.Version
+		for _, user := range shard.Users {
+			if users[user.ID] != user {
+				return nil, fmt.Errorf("shard %d: user_id %s differs from the original mixtape", i, user.ID)
+			}
+		}
+		for _, song := range shard.Songs {
+			if songs[song.ID] != song {
+				return nil, fmt.Errorf("shard %d: song_id %s differs from the original mixtape", i, song.ID)
+			}
+		}
+		for _, playlist := range shard.Playlists {
+			if Of(playlist.ID, len(shards)) != i {
+				return nil, fmt.Errorf("shard %d: playlist_id %s belongs to shard %d", i, playlist.ID, Of(playlist.ID, len(shards)))
+			}
+			if seen[playlist.ID] {
+				return nil, fmt.Errorf("shard %d: playlist_id %s appears more than once", i, playlist.ID)
+			}
+			seen[playlist.ID] = true
+		}
+		playlists = append(playlists, shard.Playlists...)
+	}
+
+	return &models.Mixtape{
+		Users:     original.Users,
+		Playlists: playlists,
+		Songs:     original.Songs,
+		Version:   version,
+
+		AllowDuplicates: original.AllowDuplicates,
+	}, nil
+}
+
+// File names of the shards written by the shard command
+func MixtapeFile(i int) string {
+	return fmt.Sprintf("mixtape-%d.json", i)
+}
+
+func ChangesFile(i int) string {
+	return fmt.Sprintf("changes-%d.json", i)
+}
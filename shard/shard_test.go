@@ -0,0 +1,200 @@
+package shard_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/shard"
+	"github.com/n4wei/highspot/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Shard", func() {
+	var (
+		mixtape *models.Mixtape
+		changes *models.Changes
+	)
+
+	// fixtures are loaded fresh, since applying changes modifies the mixtape in place
+	load := func(path string, object interface{}) {
+		bytes, err := ioutil.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(json.Unmarshal(bytes, object)).To(Succeed())
+	}
+
+	apply := func(mixtape *models.Mixtape, changes *models.Changes) {
+		// a fixed clock, so that playlists added in different runs compare
+		clock := mixtape_pkg.WithClock(func() time.Time { return time.Unix(0, 0) })
+		err := mixtape_pkg.New(mixtape, util.Discard, clock).ApplyChanges(changes)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	byID := func(playlists []models.Playlist) []models.Playlist {
+		sorted := append([]models.Playlist{}, playlists...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+		return sorted
+	}
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{}
+		load("../test_assets/expected/input.json", mixtape)
+		changes = &models.Changes{}
+		load("../test_assets/expected/changes.json", changes)
+	})
+
+	It("should reject less than one shard", func() {
+		_, err := shard.Split(mixtape, changes, 0)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject changes based on another version of the mixtape", func() {
+		stale := int64(1)
+		changes.IfMatch = &stale
+		_, err := shard.Split(mixtape, changes, 2)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should place every playlist and change in the shard of its playlist ID", func() {
+		shards, err := shard.Split(mixtape, changes, 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(shards).To(HaveLen(3))
+
+		playlists, playlistChanges := 0, 0
+		for i, s := range shards {
+			for _, playlist := range s.Mixtape.Playlists {
+				Expect(shard.Of(playlist.ID, 3)).To(Equal(i))
+			}
+			for _, change := range s.Changes.PlaylistChanges {
+				Expect(shard.Of(change.Playlist.ID, 3)).To(Equal(i))
+			}
+			playlists += len(s.Mixtape.Playlists)
+			playlistChanges += len(s.Changes.PlaylistChanges)
+		}
+		Expect(playlists).To(Equal(len(mixtape.Playlists)))
+		Expect(playlistChanges).To(Equal(len(changes.PlaylistChanges)))
+	})
+
+	It("should only copy the users and songs a shard references", func() {
+		shards, err := shard.Split(mixtape, changes, 4)
+		Expect(err).ToNot(HaveOccurred())
+
+		for _, s := range shards {
+			users := map[string]bool{}
+			songs := map[string]bool{}
+			for _, playlist := range append(s.Mixtape.Playlists, playlistsOf(s.Changes)...) {
+				users[playlist.UserID] = true
+				for _, songID := range playlist.SongIDs {
+					songs[songID] = true
+				}
+			}
+			for _, user := range s.Mixtape.Users {
+				Expect(users).To(HaveKey(user.ID))
+			}
+			for _, song := range s.Mixtape.Songs {
+				Expect(songs).To(HaveKey(song.ID))
+			}
+		}
+	})
+
+	It("should join applied shards into the same mixtape as applying all changes at once", func() {
+		for n := 1; n <= 5; n++ {
+			original := &models.Mixtape{}
+			load("../test_assets/expected/input.json", original)
+
+			shards, err := shard.Split(original, changes, n)
+			Expect(err).ToNot(HaveOccurred())
+
+			outputs := []*models.Mixtape{}
+			for _, s := range shards {
+				apply(s.Mixtape, s.Changes)
+				outputs = append(outputs, s.Mixtape)
+			}
+			joined, err := shard.Join(original, outputs)
+			Expect(err).ToNot(HaveOccurred())
+
+			apply(mixtape, changes)
+			Expect(joined.Users).To(Equal(mixtape.Users))
+			Expect(joined.Songs).To(Equal(mixtape.Songs))
+			Expect(byID(joined.Playlists)).To(Equal(byID(mixtape.Playlists)), "%d shards", n)
+			Expect(joined.Version).To(Equal(mixtape.Version), "%d shards", n)
+
+			again, err := shard.Join(original, outputs)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(again).To(Equal(joined))
+
+			mixtape = &models.Mixtape{}
+			load("../test_assets/expected/input.json", mixtape)
+		}
+	})
+
+	It("should reject changes to several named playlists", func() {
+		changes.PlaylistChanges = append(changes.PlaylistChanges, models.PlaylistChange{ID: models.MergePlaylists, Playlist: models.Playlist{ID: "1"}, Sources: []string{"2"}})
+		_, err := shard.Split(mixtape, changes, 2)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should reject auto_fill, whose recommendations read every playlist", func() {
+		changes.PlaylistChanges = append(changes.PlaylistChanges, models.PlaylistChange{ID: models.AutoFill, Playlist: models.Playlist{ID: "1"}, Count: 2})
+		_, err := shard.Split(mixtape, changes, 2)
+		Expect(err).To(MatchError(ContainSubstring("auto_fill reads or changes playlists that may be in different shards")))
+	})
+
+	It("should copy bulk changes to every shard, and join them into the same mixtape", func() {
+		changes.PlaylistChanges = append(changes.PlaylistChanges, models.PlaylistChange{
+			ID:        models.Bulk,
+			Where:     `songs < 3`,
+			Operation: &models.PlaylistChange{ID: models.AddSongs, Playlist: models.Playlist{SongIDs: []string{"8"}}},
+		})
+		original := &models.Mixtape{}
+		load("../test_assets/expected/input.json", original)
+
+		shards, err := shard.Split(original, changes, 3)
+		Expect(err).ToNot(HaveOccurred())
+		outputs := []*models.Mixtape{}
+		for _, s := range shards {
+			Expect(s.Changes.PlaylistChanges[len(s.Changes.PlaylistChanges)-1].ID).To(Equal(models.Bulk))
+			apply(s.Mixtape, s.Changes)
+			outputs = append(outputs, s.Mixtape)
+		}
+		joined, err := shard.Join(original, outputs)
+		Expect(err).ToNot(HaveOccurred())
+
+		apply(mixtape, changes)
+		Expect(byID(joined.Playlists)).To(Equal(byID(mixtape.Playlists)))
+		Expect(joined.Version).To(Equal(mixtape.Version))
+	})
+
+	Context("when a shard's users or songs differ from the original", func() {
+		It("should return an error", func() {
+			shards, err := shard.Split(mixtape, changes, 2)
+			Expect(err).ToNot(HaveOccurred())
+			shards[1].Mixtape.Songs[0].Title = "changed"
+
+			_, err = shard.Join(mixtape, []*models.Mixtape{shards[0].Mixtape, shards[1].Mixtape})
+			Expect(err).To(MatchError(ContainSubstring("shard 1: song_id")))
+		})
+	})
+
+	Context("when shards are given out of order", func() {
+		It("should return an error", func() {
+			shards, err := shard.Split(mixtape, changes, 2)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = shard.Join(mixtape, []*models.Mixtape{shards[1].Mixtape, shards[0].Mixtape})
+			Expect(err).To(MatchError(ContainSubstring("belongs to shard")))
+		})
+	})
+})
+
+func playlistsOf(changes *models.Changes) []models.Playlist {
+	playlists := []models.Playlist{}
+	for _, change := range changes.PlaylistChanges {
+		playlists = append(playlists, change.Playlist)
+	}
+	return playlists
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"flag"
+)
+
+// highspot convert -i <mixtape file> -o <mixtape file>
+// Converts between JSON and snapshot, the format of each side is picked
+// by its extension. Either side may also be compressed.
+func runConvert(args []string) {
+	var inputFile, outputFile string
+	var compressionLevel int
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	flags.StringVar(&inputFile, "i", "", "filepath to the JSON or .snap mixtape file to convert, - for JSON from stdin")
+	flags.StringVar(&outputFile, "o", "", "filepath to write the converted JSON or .snap mixtape file, - for JSON to stdout")
+	addCompressionFlag(flags, &compressionLevel)
+	flags.Parse(args)
+
+	if inputFile == "" || outputFile == "" {
+		handleFlagError(flags, errors.New("missing required flags -i and -o"))
+	}
+
+	mixtape, index, err := readMixtape(inputFile)
+	handleError(err)
+
+	err = writeMixtape(mixtape, index, outputFile, compressionLevel)
+	handleError(err)
+}
@@ -0,0 +1,151 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+)
+
+// A snapshot stores a mixtape together with its lookup hash maps so that
+// loading it skips both JSON parsing and rebuilding the maps. The layout is
+//
+//	magic    4 bytes  "HSMX"
+//	version  2 bytes  big endian
+//	length   8 bytes  big endian, length of the payload
+//	payload  gob encoding of the mixtape and its index
+//	checksum 4 bytes  big endian, CRC-32 (IEEE) of the payload
+//
+// The payload is length prefixed so the checksum can be verified without
+// relying on where the gob decoder stops reading. Gob follows the struct
+// definitions in models, so new optional fields do not need a new version.
+// Version must be bumped whenever the shape of the index changes.
+const (
+	Version   uint16 = 5
+	Extension        = ".snap"
+)
+
+var magic = [4]byte{'H', 'S', 'M', 'X'}
+
+var (
+	ErrBadMagic = errors.New("not a mixtape snapshot")
+	ErrChecksum = errors.New("snapshot checksum mismatch")
+)
+
+type payload struct {
+	Mixtape *models.Mixtape
+	Index   *mixtape_pkg.Index
+}
+
+type header struct {
+	Magic   [4]byte
+	Version uint16
+	Length  uint64
+}
+
+// Write encodes the mixtape and its index as a snapshot. A nil index is
+// built from the mixtape.
+func Write(w io.Writer, mixtape *models.Mixtape, index *mixtape_pkg.Index) error {
+	if index == nil {
+		index = mixtape_pkg.BuildIndex(mixtape)
+	}
+
+	// the payload is buffered because its length goes in the header
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(payload{Mixtape: mixtape, Index: index})
+	if err != nil {
+		return fmt.Errorf("error encoding snapshot: %v", err)
+	}
+
+	err = binary.Write(w, binary.BigEndian, header{
+		Magic:   magic,
+		Version: Version,
+		Length:  uint64(buf.Len()),
+	})
+	if err != nil {
+		return err
+	}
+	checksum := crc32.ChecksumIEEE(buf.Bytes())
+	_, err = buf.WriteTo(w)
+	if err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, checksum)
+}
+
+// Read decodes a snapshot written by Write. The returned index can be
+// passed to mixtape.NewWithIndex.
+func Read(r io.Reader) (*models.Mixtape, *mixtape_pkg.Index, error) {
+	h := header{}
+	err := binary.Read(r, binary.BigEndian, &h)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading snapshot header: %v", err)
+	}
+	if h.Magic != magic {
+		return nil, nil, ErrBadMagic
+	}
+	if h.Version != Version {
+		return nil, nil, fmt.Errorf("unsupported snapshot version %d, expected %d", h.Version, Version)
+	}
+
+	crc := crc32.NewIEEE()
+	body := io.TeeReader(io.LimitReader(r, int64(h.Length)), crc)
+	p := payload{}
+	err = gob.NewDecoder(bufio.NewReader(body)).Decode(&p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error decoding snapshot: %v", err)
+	}
+	// drain anything the decoder did not consume so the checksum covers
+	// the whole payload
+	_, err = io.Copy(io.Discard, body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var checksum uint32
+	err = binary.Read(r, binary.BigEndian, &checksum)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading snapshot checksum: %v", err)
+	}
+	if checksum != crc.Sum32() {
+		return nil, nil, ErrChecksum
+	}
+	if p.Mixtape == nil || p.Index == nil {
+		return nil, nil, errors.New("snapshot is missing the mixtape or its index")
+	}
+	// gob does not transmit empty maps, they come back as nil
+	if p.Index.Users == nil {
+		p.Index.Users = map[string]int{}
+	}
+	if p.Index.Songs == nil {
+		p.Index.Songs = map[string]int{}
+	}
+	if p.Index.Playlists == nil {
+		p.Index.Playlists = map[string]int{}
+	}
+	if p.Index.PlaylistSongs == nil {
+		p.Index.PlaylistSongs = map[string]map[string]int{}
+	}
+	if p.Index.Editable == nil {
+		p.Index.Editable = map[string]map[string]bool{}
+	}
+	if p.Index.Durations == nil {
+		p.Index.Durations = map[string]time.Duration{}
+	}
+	if p.Index.Owned == nil {
+		p.Index.Owned = map[string]map[string]bool{}
+	}
+	if p.Index.SongPlaylists == nil {
+		p.Index.SongPlaylists = map[string]map[string]bool{}
+	}
+
+	return p.Mixtape, p.Index, nil
+}
@@ -0,0 +1,108 @@
+package snapshot_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"strconv"
+	"testing"
+
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/snapshot"
+)
+
+// Benchmarks compare loading a mixtape from JSON plus building the lookup
+// hash maps against loading a snapshot. The default size is the one that
+// motivated the snapshot format, pass eg. -songs=100000 for a quick run.
+//
+//	go test ./snapshot -run NONE -bench . -benchmem
+var songs = flag.Int("songs", 10000000, "number of songs in the synthetic benchmark mixtape")
+
+const (
+	songsPerPlaylist = 20
+	playlistsPerUser = 5
+)
+
+// synthetic builds a mixtape with the given number of songs, where every
+// song is in exactly 2 playlists.
+func synthetic(songCount int) *models.Mixtape {
+	playlistCount := songCount * 2 / songsPerPlaylist
+	userCount := playlistCount/playlistsPerUser + 1
+
+	mixtape := &models.Mixtape{
+		Users:     make([]models.User, userCount),
+		Playlists: make([]models.Playlist, playlistCount),
+		Songs:     make([]models.Song, songCount),
+	}
+	for i := range mixtape.Users {
+		id := strconv.Itoa(i)
+		mixtape.Users[i] = models.User{ID: id, Name: "user " + id}
+	}
+	for i := range mixtape.Songs {
+		id := strconv.Itoa(i)
+		mixtape.Songs[i] = models.Song{ID: id, Artist: "artist " + strconv.Itoa(i%1000), Title: "title " + id}
+	}
+	for i := range mixtape.Playlists {
+		songIDs := make([]string, songsPerPlaylist)
+		for j := range songIDs {
+			songIDs[j] = mixtape.Songs[(i*songsPerPlaylist+j)%songCount].ID
+		}
+		mixtape.Playlists[i] = models.Playlist{
+			ID:      strconv.Itoa(i),
+			UserID:  mixtape.Users[i/playlistsPerUser].ID,
+			SongIDs: songIDs,
+		}
+	}
+	return mixtape
+}
+
+func BenchmarkLoadJSON(b *testing.B) {
+	data, err := json.Marshal(synthetic(*songs))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		mixtape := &models.Mixtape{}
+		err = json.Unmarshal(data, mixtape)
+		if err != nil {
+			b.Fatal(err)
+		}
+		mixtape_pkg.New(mixtape, nil)
+	}
+}
+
+func BenchmarkLoadSnapshot(b *testing.B) {
+	buf := &bytes.Buffer{}
+	err := snapshot.Write(buf, synthetic(*songs), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	data := buf.Bytes()
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		mixtape, index, err := snapshot.Read(bytes.NewReader(data))
+		if err != nil {
+			b.Fatal(err)
+		}
+		mixtape_pkg.NewWithIndex(mixtape, index, nil)
+	}
+}
+
+func BenchmarkWriteSnapshot(b *testing.B) {
+	mixtape := synthetic(*songs)
+	index := mixtape_pkg.BuildIndex(mixtape)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		err := snapshot.Write(&bytes.Buffer{}, mixtape, index)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
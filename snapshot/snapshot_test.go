@@ -0,0 +1,108 @@
+package snapshot_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/snapshot"
+	"github.com/n4wei/highspot/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Snapshot", func() {
+	var (
+		mixtape *models.Mixtape
+		buf     *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{
+				{ID: "user_1", Name: "test_user_1"},
+				{ID: "user_2", Name: "test_user_2"},
+			},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", SongIDs: []string{"song_1", "song_2"}},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1"},
+				{ID: "song_2", Artist: "some_other_artist", Title: "test_song_2"},
+			},
+		}
+
+		buf = &bytes.Buffer{}
+		Expect(snapshot.Write(buf, mixtape, nil)).To(Succeed())
+	})
+
+	It("should start with the magic bytes and version", func() {
+		Expect(buf.Bytes()[:4]).To(Equal([]byte("HSMX")))
+		Expect(binary.BigEndian.Uint16(buf.Bytes()[4:6])).To(Equal(snapshot.Version))
+	})
+
+	It("should round trip the mixtape and its index", func() {
+		readMixtape, index, err := snapshot.Read(buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readMixtape).To(Equal(mixtape))
+		Expect(index).To(Equal(mixtape_pkg.BuildIndex(mixtape)))
+	})
+
+	It("should load an index that changes can be applied with", func() {
+		readMixtape, index, err := snapshot.Read(buf)
+		Expect(err).ToNot(HaveOccurred())
+
+		now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		m := mixtape_pkg.NewWithIndex(readMixtape, index, util.Discard, mixtape_pkg.WithClock(func() time.Time { return now }))
+		err = m.ApplyChanges(&models.Changes{
+			PlaylistChanges: []models.PlaylistChange{
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_1"}},
+				{ID: models.Add, Playlist: models.Playlist{ID: "playlist_2", UserID: "user_2", SongIDs: []string{"song_2"}}},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(readMixtape.Playlists).To(Equal([]models.Playlist{
+			{ID: "playlist_2", UserID: "user_2", SongIDs: []string{"song_2"}, Version: 1, CreatedAt: now, UpdatedAt: now},
+		}))
+		Expect(index).To(Equal(mixtape_pkg.BuildIndex(readMixtape)))
+	})
+
+	Context("when the payload is corrupted", func() {
+		It("should fail the checksum or decoding", func() {
+			data := buf.Bytes()
+			data[len(data)-5] ^= 0xff
+
+			_, _, err := snapshot.Read(bytes.NewReader(data))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("when the checksum is corrupted", func() {
+		It("should return ErrChecksum", func() {
+			data := buf.Bytes()
+			data[len(data)-1] ^= 0xff
+
+			_, _, err := snapshot.Read(bytes.NewReader(data))
+			Expect(err).To(Equal(snapshot.ErrChecksum))
+		})
+	})
+
+	Context("when the input is not a snapshot", func() {
+		It("should return ErrBadMagic", func() {
+			_, _, err := snapshot.Read(bytes.NewReader([]byte(`{"users": [], "playlists": [], "songs": []}`)))
+			Expect(err).To(Equal(snapshot.ErrBadMagic))
+		})
+	})
+
+	Context("when the version is not supported", func() {
+		It("should return an error", func() {
+			data := buf.Bytes()
+			binary.BigEndian.PutUint16(data[4:6], snapshot.Version+1)
+
+			_, _, err := snapshot.Read(bytes.NewReader(data))
+			Expect(err).To(MatchError(ContainSubstring("unsupported snapshot version")))
+		})
+	})
+})
@@ -0,0 +1,174 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/n4wei/highspot/models"
+)
+
+// A desired state is a partial mixtape that lists the playlists as they
+// should be. Plan computes the changes that take the current mixtape there,
+// using the existing change types, so that applying a plan goes through
+// the same authorization, validation and logging as a changes file, and
+// keeps the lookup hash maps consistent.
+//
+// Only the playlists of the desired state are read. A playlist that does
+// not exist is added as is. For a playlist that exists, only what the
+// desired state sets is managed, and the rest is left as it is:
+//
+//	user_id              transfer_ownership, if set and different
+//	name, description,   update_playlist of the ones set and different,
+//	visibility           since a field can not be cleared
+//	collaborators        remove_collaborators and add_collaborators, if
+//	                     the array is given, even empty
+//	song_ids             set_songs, if the array is given, even empty
+//
+// allow_duplicates is only read for playlists that are added, no change
+// type sets it. Existing playlists that are not in the desired state are
+// unmanaged, and are removed if Prune is set, or else left alone.
+
+type Options struct {
+	// Remove the playlists that are not in the desired state
+	Prune bool
+	// The user the changes are made by, see models.PlaylistChange.Actor,
+	// so that they can be applied under a policy
+	Actor string
+}
+
+// Plan returns the changes from current to desired, in the order they must
+// be applied: removes first, then the changes to each desired playlist in
+// the order they are listed. The changes carry the current mixtape's
+// version as their if_match, so a plan is not applied to a mixtape that
+// changed since it was made, and the actor of the options.
+// runtime: O(p*ps + d*(ds + dc*pc)), p is the number of current playlists,
+// ps the most songs in one of them, d the number of desired playlists, ds
+// the most songs in one of them, and dc and pc the most collaborators on a
+// desired and a current playlist
+func Plan(current, desired *models.Mixtape, opts Options) (*models.Changes, error) {
+	currentByID := map[string]models.Playlist{}
+	for _, playlist := range current.Playlists {
+		currentByID[playlist.ID] = playlist
+	}
+	desiredIDs := map[string]bool{}
+	for _, playlist := range desired.Playlists {
+		if playlist.ID == "" {
+			return nil, errors.New("desired playlist without an id")
+		}
+		if desiredIDs[playlist.ID] {
+			return nil, fmt.Errorf("playlist_id %s appears more than once", playlist.ID)
+		}
+		desiredIDs[playlist.ID] = true
+	}
+
+	version := current.Version
+	changes := &models.Changes{PlaylistChanges: []models.PlaylistChange{}, IfMatch: &version}
+	if opts.Prune {
+		for _, playlist := range current.Playlists {
+			if !desiredIDs[playlist.ID] {
+				changes.PlaylistChanges = append(changes.PlaylistChanges, models.PlaylistChange{ID: models.Remove, Playlist: models.Playlist{ID: playlist.ID}})
+			}
+		}
+	}
+
+	for _, playlist := range desired.Playlists {
+		existing, exist := currentByID[playlist.ID]
+		if !exist {
+			// add ignores the version and timestamps it is given
+			changes.PlaylistChanges = append(changes.PlaylistChanges, models.PlaylistChange{ID: models.Add, Playlist: playlist})
+			continue
+		}
+		changes.PlaylistChanges = append(changes.PlaylistChanges, diff(existing, playlist)...)
+	}
+	for i := range changes.PlaylistChanges {
+		changes.PlaylistChanges[i].Actor = opts.Actor
+	}
+	return changes, nil
+}
+
+// diff returns the changes that make an existing playlist as desired.
+func diff(existing, desired models.Playlist) []models.PlaylistChange {
+	id := existing.ID
+	changes := []models.PlaylistChange{}
+
+	collaborators := existing.Collaborators
+	if desired.UserID != "" && desired.UserID != existing.UserID {
+		changes = append(changes, models.PlaylistChange{ID: models.TransferOwnership, Playlist: models.Playlist{ID: id, UserID: desired.UserID}})
+		collaborators = transferred(existing, desired.UserID)
+	}
+
+	// the same fields update_playlist changes
+	updated := models.Playlist{ID: id}
+	if desired.Name != "" && desired.Name != existing.Name {
+		updated.Name = desired.Name
+	}
+	if desired.Description != "" && desired.Description != existing.Description {
+		updated.Description = desired.Description
+	}
+	if desired.Visibility != "" && desired.IsPublic() != existing.IsPublic() {
+		updated.Visibility = desired.Visibility
+	}
+	if updated.Name != "" || updated.Description != "" || updated.Visibility != "" {
+		changes = append(changes, models.PlaylistChange{ID: models.UpdatePlaylist, Playlist: updated})
+	}
+
+	if desired.Collaborators != nil {
+		removed, added := models.DiffCollaborators(collaborators, desired.Collaborators)
+		if len(removed) > 0 {
+			changes = append(changes, models.PlaylistChange{ID: models.RemoveCollaborators, Playlist: models.Playlist{ID: id, Collaborators: removed}})
+		}
+		if len(added) > 0 {
+			changes = append(changes, models.PlaylistChange{ID: models.AddCollaborators, Playlist: models.Playlist{ID: id, Collaborators: added}})
+		}
+	}
+
+	if desired.SongIDs != nil && !models.EqualSongIDs(existing.SongIDs, desired.SongIDs) {
+		changes = append(changes, models.PlaylistChange{ID: models.SetSongs, Playlist: models.Playlist{ID: id, SongIDs: desired.SongIDs}})
+	}
+	return changes
+}
+
+// transferred returns the collaborators a playlist has once transferred to
+// userID: the new owner stops being one, and the previous owner becomes an
+// editor, see transfer_ownership.
+func transferred(playlist models.Playlist, userID string) []models.Collaborator {
+	collaborators := []models.Collaborator{}
+	for _, collaborator := range playlist.Collaborators {
+		if collaborator.UserID != userID && collaborator.UserID != playlist.UserID {
+			collaborators = append(collaborators, collaborator)
+		}
+	}
+	return append(collaborators, models.Collaborator{UserID: playlist.UserID, Role: models.Editor})
+}
+
+// Summary counts the playlists a plan adds, changes and removes.
+type Summary struct {
+	Add    int `json:"add"`
+	Change int `json:"change"`
+	Remove int `json:"remove"`
+}
+
+// Summarize counts the playlists that the changes of a plan add, change
+// and remove. A playlist with several changes is counted once.
+func Summarize(changes *models.Changes) Summary {
+	summary := Summary{}
+	changed := map[string]bool{}
+	for _, change := range changes.PlaylistChanges {
+		switch change.ID {
+		case models.Add:
+			summary.Add++
+		case models.Remove:
+			summary.Remove++
+		default:
+			if !changed[change.Playlist.ID] {
+				changed[change.Playlist.ID] = true
+				summary.Change++
+			}
+		}
+	}
+	return summary
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%d to add, %d to change, %d to remove", s.Add, s.Change, s.Remove)
+}
@@ -0,0 +1,141 @@
+package state_test
+
+import (
+	mixtape_pkg "github.com/n4wei/highspot/mixtape"
+	"github.com/n4wei/highspot/models"
+	"github.com/n4wei/highspot/policy"
+	"github.com/n4wei/highspot/state"
+	"github.com/n4wei/highspot/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("State", func() {
+	var mixtape *models.Mixtape
+
+	BeforeEach(func() {
+		mixtape = &models.Mixtape{
+			Users: []models.User{
+				{ID: "user_1", Name: "test_user_1"},
+				{ID: "user_2", Name: "test_user_2"},
+				{ID: "user_3", Name: "test_user_3"},
+			},
+			Playlists: []models.Playlist{
+				{ID: "playlist_1", UserID: "user_1", Name: "Mix", SongIDs: []string{"song_1", "song_2"},
+					Collaborators: []models.Collaborator{{UserID: "user_2", Role: models.Viewer}, {UserID: "user_3", Role: models.Editor}}},
+				{ID: "playlist_2", UserID: "user_2", SongIDs: []string{"song_2"}},
+				{ID: "playlist_3", UserID: "user_3", SongIDs: []string{"song_3"}},
+			},
+			Songs: []models.Song{
+				{ID: "song_1", Artist: "some_artist", Title: "test_song_1"},
+				{ID: "song_2", Artist: "some_other_artist", Title: "test_song_2"},
+				{ID: "song_3", Artist: "another_artist", Title: "test_song_3"},
+			},
+			Version: 7,
+		}
+	})
+
+	desired := func(playlists ...models.Playlist) *models.Mixtape {
+		return &models.Mixtape{Playlists: playlists}
+	}
+
+	Describe("Plan", func() {
+		It("should add new playlists, and only change what the desired state sets on existing ones", func() {
+			changes, err := state.Plan(mixtape, desired(
+				models.Playlist{ID: "playlist_4", UserID: "user_1", SongIDs: []string{"song_3"}, Version: 3},
+				models.Playlist{ID: "playlist_2", Name: "Renamed", SongIDs: []string{"song_2", "song_3"}},
+				models.Playlist{ID: "playlist_3"},
+			), state.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*changes.IfMatch).To(Equal(int64(7)))
+			Expect(changes.PlaylistChanges).To(Equal([]models.PlaylistChange{
+				{ID: models.Add, Playlist: models.Playlist{ID: "playlist_4", UserID: "user_1", SongIDs: []string{"song_3"}, Version: 3}},
+				{ID: models.UpdatePlaylist, Playlist: models.Playlist{ID: "playlist_2", Name: "Renamed"}},
+				{ID: models.SetSongs, Playlist: models.Playlist{ID: "playlist_2", SongIDs: []string{"song_2", "song_3"}}},
+			}))
+			Expect(state.Summarize(changes).String()).To(Equal("1 to add, 1 to change, 0 to remove"))
+		})
+
+		It("should remove the playlists that are not in the desired state only when pruning", func() {
+			changes, err := state.Plan(mixtape, desired(models.Playlist{ID: "playlist_2"}), state.Options{Prune: true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes.PlaylistChanges).To(Equal([]models.PlaylistChange{
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_1"}},
+				{ID: models.Remove, Playlist: models.Playlist{ID: "playlist_3"}},
+			}))
+
+			changes, err = state.Plan(mixtape, desired(models.Playlist{ID: "playlist_2"}), state.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes.PlaylistChanges).To(BeEmpty())
+		})
+
+		It("should diff collaborators against those left after a transfer", func() {
+			changes, err := state.Plan(mixtape, desired(models.Playlist{
+				ID: "playlist_1", UserID: "user_2",
+				Collaborators: []models.Collaborator{{UserID: "user_1", Role: models.Viewer}},
+			}), state.Options{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes.PlaylistChanges).To(Equal([]models.PlaylistChange{
+				{ID: models.TransferOwnership, Playlist: models.Playlist{ID: "playlist_1", UserID: "user_2"}},
+				{ID: models.RemoveCollaborators, Playlist: models.Playlist{ID: "playlist_1", Collaborators: []models.Collaborator{{UserID: "user_3"}}}},
+				{ID: models.AddCollaborators, Playlist: models.Playlist{ID: "playlist_1", Collaborators: []models.Collaborator{{UserID: "user_1", Role: models.Viewer}}}},
+			}))
+		})
+
+		It("should reach the desired state when applied, after which there is nothing left to plan", func() {
+			target := desired(
+				models.Playlist{ID: "playlist_1", UserID: "user_3", Visibility: models.Private, SongIDs: []string{"song_3", "song_1"},
+					Collaborators: []models.Collaborator{{UserID: "user_2", Role: models.Editor}}},
+				models.Playlist{ID: "playlist_4", UserID: "user_2", SongIDs: []string{"song_1"}},
+				models.Playlist{ID: "playlist_3", SongIDs: []string{}},
+			)
+			changes, err := state.Plan(mixtape, target, state.Options{Prune: true})
+			Expect(err).ToNot(HaveOccurred())
+			m := mixtape_pkg.New(mixtape, util.Discard)
+			Expect(m.ApplyChanges(changes)).To(Succeed())
+			for _, entry := range m.Report().Entries {
+				Expect(entry.Status).To(Equal(models.Applied), string(entry.Change))
+			}
+
+			playlist, _ := m.Playlist("playlist_1")
+			Expect(playlist.UserID).To(Equal("user_3"))
+			Expect(playlist.Visibility).To(Equal(models.Private))
+			Expect(playlist.SongIDs).To(Equal([]string{"song_3", "song_1"}))
+			Expect(playlist.Collaborators).To(Equal([]models.Collaborator{{UserID: "user_2", Role: models.Editor}}))
+			_, exist := m.Playlist("playlist_2")
+			Expect(exist).To(BeFalse())
+
+			changes, err = state.Plan(mixtape, target, state.Options{Prune: true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes.PlaylistChanges).To(BeEmpty())
+		})
+
+		It("should make every change as the actor, for a policy to authorize", func() {
+			target := desired(
+				models.Playlist{ID: "playlist_1", SongIDs: []string{"song_3"}},
+				models.Playlist{ID: "playlist_2", SongIDs: []string{"song_3"}},
+			)
+			changes, err := state.Plan(mixtape, target, state.Options{Actor: "user_3"})
+			Expect(err).ToNot(HaveOccurred())
+			for _, change := range changes.PlaylistChanges {
+				Expect(change.Actor).To(Equal("user_3"))
+			}
+
+			// user_3 edits playlist_1, but has no say over playlist_2
+			m := mixtape_pkg.New(mixtape, util.Discard, mixtape_pkg.WithPolicy(&policy.Policy{}))
+			Expect(m.ApplyChanges(changes)).To(Succeed())
+			entries := m.Report().Entries
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0].Status).To(Equal(models.Applied))
+			Expect(entries[1].Status).To(Equal(models.Skipped))
+			Expect(entries[1].Reason).To(HavePrefix("unauthorized:"))
+		})
+
+		It("should fail for playlists without an id or listed twice", func() {
+			_, err := state.Plan(mixtape, desired(models.Playlist{UserID: "user_1"}), state.Options{})
+			Expect(err).To(MatchError("desired playlist without an id"))
+			_, err = state.Plan(mixtape, desired(models.Playlist{ID: "playlist_1"}, models.Playlist{ID: "playlist_1"}), state.Options{})
+			Expect(err).To(MatchError("playlist_id playlist_1 appears more than once"))
+		})
+	})
+})
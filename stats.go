@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/n4wei/highspot/analytics"
+)
+
+const textFormat = "text"
+
+// highspot stats -m <mixtape file> [-format text|json] [-top <n>]
+// Computes aggregate metrics over the mixtape, see analytics.Stats.
+func runStats(args []string) {
+	var mixtapeFile, format string
+	var n int
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	flags.StringVar(&mixtapeFile, "m", "", "filepath to the JSON mixtape file, - for stdin")
+	flags.StringVar(&format, "format", textFormat, "format of the output: text or json")
+	flags.IntVar(&n, "top", 10, "number of songs, artists and song pairs to list")
+	flags.Parse(args)
+	if mixtapeFile == "" {
+		handleFlagError(flags, errors.New("missing required flag -m"))
+	}
+	if format != textFormat && format != jsonFormat {
+		handleFlagError(flags, fmt.Errorf("unknown format %s", format))
+	}
+	if n < 0 {
+		handleFlagError(flags, errors.New("-top must not be negative"))
+	}
+
+	mixtape, _, err := readMixtape(mixtapeFile)
+	handleError(err)
+	stats := analytics.Compute(mixtape, n)
+
+	// the text format is a table, like that of the query command
+	err = writeResult(os.Stdout, format, stats, func(w io.Writer) {
+		fmt.Fprintln(w, "USERS\tPLAYLISTS\tSONGS")
+		fmt.Fprintf(w, "%d\t%d\t%d\n", stats.Users, stats.Playlists, stats.Songs)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "DISTRIBUTION\tMIN\tMEAN\tP50\tP90\tP99\tMAX")
+		writeDistribution(w, "playlists per user", stats.PlaylistsPerUser)
+		writeDistribution(w, "songs per playlist", stats.SongsPerPlaylist)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "TOP SONG\tPLAYLISTS")
+		for _, count := range stats.TopSongs {
+			fmt.Fprintf(w, "%s\t%d\n", count.ID, count.Playlists)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "TOP ARTIST\tPLAYLISTS")
+		for _, count := range stats.TopArtists {
+			fmt.Fprintf(w, "%s\t%d\n", count.ID, count.Playlists)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "TOP SONG PAIR\tPLAYLISTS")
+		for _, count := range stats.TopSongPairs {
+			fmt.Fprintf(w, "%s,%s\t%d\n", count.A, count.B, count.Playlists)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "ORPHAN SONGS\t%s\n", strings.Join(stats.OrphanSongs, ","))
+		fmt.Fprintf(w, "USERS WITHOUT PLAYLISTS\t%s\n", strings.Join(stats.UsersWithoutPlaylists, ","))
+	})
+	handleError(err)
+}
+
+func writeDistribution(w io.Writer, name string, d analytics.Distribution) {
+	fmt.Fprintf(w, "%s\t%d\t%.2f\t%d\t%d\t%d\t%d\n", name, d.Min, d.Mean, d.P50, d.P90, d.P99, d.Max)
+}
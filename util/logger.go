@@ -1,6 +1,108 @@
 package util
 
+// Logs are leveled and structured. Messages are constant, eg. "skipped
+// change", and what varies goes in fields, so logs can be filtered and
+// parsed by tools. Implementations must be safe for concurrent use.
 type Logger interface {
-	Printf(format string, v ...interface{})
-	SetPrefix(prefix string)
+	Debug(msg string, fields ...Field)
+	Info(msg string, fields ...Field)
+	// Warn is for changes, or parts of changes, that were skipped
+	Warn(msg string, fields ...Field)
+	Error(msg string, fields ...Field)
+	// With returns a logger that adds fields to every log, eg. the index
+	// of the change being applied. The receiver is not modified, so it can
+	// be used to give each call its own context.
+	With(fields ...Field) Logger
 }
+
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Keys of the fields logged by mixtape operations
+const (
+	ChangeKey         = "change"
+	ChangeIndexKey    = "change_index"
+	CorrelationKey    = "correlation_id"
+	IdempotencyKeyKey = "idempotency_key"
+	ActorKey          = "actor"
+	PlaylistIDKey     = "playlist_id"
+	UserIDKey         = "user_id"
+	SongIDKey         = "song_id"
+	PositionKey       = "position"
+	RoleKey           = "role"
+	ReasonKey         = "reason"
+	IfMatchKey        = "if_match"
+	VersionKey        = "version"
+	// playlists a change reads or writes besides the one with its ID
+	SourceKey = "source_id"
+	TargetKey = "target_id"
+)
+
+func ChangeIndex(i int) Field {
+	return Field{ChangeIndexKey, i}
+}
+
+func CorrelationID(id string) Field {
+	return Field{CorrelationKey, id}
+}
+
+func IdempotencyKey(key string) Field {
+	return Field{IdempotencyKeyKey, key}
+}
+
+func Actor(id string) Field {
+	return Field{ActorKey, id}
+}
+
+func PlaylistID(id string) Field {
+	return Field{PlaylistIDKey, id}
+}
+
+func UserID(id string) Field {
+	return Field{UserIDKey, id}
+}
+
+func SongID(id string) Field {
+	return Field{SongIDKey, id}
+}
+
+func Position(position int) Field {
+	return Field{PositionKey, position}
+}
+
+func IfMatch(version int64) Field {
+	return Field{IfMatchKey, version}
+}
+
+func Version(version int64) Field {
+	return Field{VersionKey, version}
+}
+
+func SourceID(id string) Field {
+	return Field{SourceKey, id}
+}
+
+func TargetID(id string) Field {
+	return Field{TargetKey, id}
+}
+
+func Role(role string) Field {
+	return Field{RoleKey, role}
+}
+
+func Reason(reason string) Field {
+	return Field{ReasonKey, reason}
+}
+
+// Discard is a logger that drops everything.
+var Discard Logger = discard{}
+
+type discard struct{}
+
+func (discard) Debug(string, ...Field) {}
+func (discard) Info(string, ...Field)  {}
+func (discard) Warn(string, ...Field)  {}
+func (discard) Error(string, ...Field) {}
+func (d discard) With(...Field) Logger { return d }
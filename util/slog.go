@@ -0,0 +1,111 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Log formats accepted by NewHandler
+const (
+	TextFormat = "text"
+	JSONFormat = "json"
+)
+
+// NewSlogLogger adapts a *slog.Logger to Logger. Fields become slog
+// attributes.
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{logger: logger}
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func (l *slogLogger) Debug(msg string, fields ...Field) {
+	l.log(slog.LevelDebug, msg, fields)
+}
+
+func (l *slogLogger) Info(msg string, fields ...Field) {
+	l.log(slog.LevelInfo, msg, fields)
+}
+
+func (l *slogLogger) Warn(msg string, fields ...Field) {
+	l.log(slog.LevelWarn, msg, fields)
+}
+
+func (l *slogLogger) Error(msg string, fields ...Field) {
+	l.log(slog.LevelError, msg, fields)
+}
+
+func (l *slogLogger) With(fields ...Field) Logger {
+	return &slogLogger{logger: l.logger.With(attrs(fields)...)}
+}
+
+func (l *slogLogger) log(level slog.Level, msg string, fields []Field) {
+	l.logger.Log(context.Background(), level, msg, attrs(fields)...)
+}
+
+func attrs(fields []Field) []interface{} {
+	args := make([]interface{}, len(fields))
+	for i, field := range fields {
+		args[i] = slog.Any(field.Key, field.Value)
+	}
+	return args
+}
+
+// NewHandler returns a slog handler writing in the given format, text or
+// json, at or above the given level.
+func NewHandler(w io.Writer, format string, level slog.Level) (slog.Handler, error) {
+	options := &slog.HandlerOptions{Level: level}
+	switch format {
+	case TextFormat:
+		return slog.NewTextHandler(w, options), nil
+	case JSONFormat:
+		return slog.NewJSONHandler(w, options), nil
+	}
+	return nil, errors.New("log format must be text or json")
+}
+
+// ParseLevel parses debug, info, warn or error.
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.ToUpper(name)))
+	return level, err
+}
+
+// NewSplitHandler sends records below the split level to low, and the rest
+// to high, eg. info logs to stdout and skipped changes to stderr.
+func NewSplitHandler(low, high slog.Handler, split slog.Level) slog.Handler {
+	return &splitHandler{low: low, high: high, split: split}
+}
+
+type splitHandler struct {
+	low, high slog.Handler
+	split     slog.Level
+}
+
+func (h *splitHandler) pick(level slog.Level) slog.Handler {
+	if level < h.split {
+		return h.low
+	}
+	return h.high
+}
+
+func (h *splitHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.pick(level).Enabled(ctx, level)
+}
+
+func (h *splitHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.pick(record.Level).Handle(ctx, record)
+}
+
+func (h *splitHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &splitHandler{low: h.low.WithAttrs(attrs), high: h.high.WithAttrs(attrs), split: h.split}
+}
+
+func (h *splitHandler) WithGroup(name string) slog.Handler {
+	return &splitHandler{low: h.low.WithGroup(name), high: h.high.WithGroup(name), split: h.split}
+}
@@ -0,0 +1,74 @@
+package util_test
+
+import (
+	"bytes"
+	"log/slog"
+
+	"github.com/n4wei/highspot/util"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Slog Logger", func() {
+	var low, high *bytes.Buffer
+
+	newLogger := func(format string, level slog.Level) util.Logger {
+		lowHandler, err := util.NewHandler(low, format, level)
+		Expect(err).ToNot(HaveOccurred())
+		highHandler, err := util.NewHandler(high, format, level)
+		Expect(err).ToNot(HaveOccurred())
+		return util.NewSlogLogger(slog.New(util.NewSplitHandler(lowHandler, highHandler, slog.LevelWarn)))
+	}
+
+	BeforeEach(func() {
+		low, high = &bytes.Buffer{}, &bytes.Buffer{}
+	})
+
+	It("should split logs by level", func() {
+		logger := newLogger(util.TextFormat, slog.LevelDebug)
+		logger.Debug("debug")
+		logger.Info("info")
+		logger.Warn("warn")
+		logger.Error("error")
+
+		Expect(low.String()).To(ContainSubstring("msg=debug"))
+		Expect(low.String()).To(ContainSubstring("msg=info"))
+		Expect(low.String()).ToNot(ContainSubstring("msg=warn"))
+		Expect(high.String()).To(ContainSubstring("msg=warn"))
+		Expect(high.String()).To(ContainSubstring("msg=error"))
+		Expect(high.String()).ToNot(ContainSubstring("msg=info"))
+	})
+
+	It("should drop logs below the level", func() {
+		logger := newLogger(util.TextFormat, slog.LevelWarn)
+		logger.Info("info")
+		Expect(low.String()).To(BeEmpty())
+	})
+
+	It("should log fields, including those added with With, on both sides of the split", func() {
+		logger := newLogger(util.JSONFormat, slog.LevelInfo).With(util.ChangeIndex(3), util.PlaylistID("p1"))
+		logger.Info("added song", util.SongID("s1"))
+		logger.Warn("skipped song", util.SongID("s2"), util.Reason("song_id not in mixtape"))
+
+		Expect(low.String()).To(ContainSubstring(`"msg":"added song","change_index":3,"playlist_id":"p1","song_id":"s1"`))
+		Expect(high.String()).To(ContainSubstring(`"msg":"skipped song","change_index":3,"playlist_id":"p1","song_id":"s2","reason":"song_id not in mixtape"`))
+	})
+
+	It("should not modify the receiver of With", func() {
+		logger := newLogger(util.TextFormat, slog.LevelInfo)
+		logger.With(util.ChangeIndex(1))
+		logger.Info("info")
+		Expect(low.String()).ToNot(ContainSubstring("change_index"))
+	})
+
+	It("should reject unknown formats and levels", func() {
+		_, err := util.NewHandler(low, "xml", slog.LevelInfo)
+		Expect(err).To(HaveOccurred())
+		_, err = util.ParseLevel("loud")
+		Expect(err).To(HaveOccurred())
+
+		level, err := util.ParseLevel("warn")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(level).To(Equal(slog.LevelWarn))
+	})
+})